@@ -17,6 +17,7 @@ var (
 	showHelp    bool
 	showVersion bool
 	runRepl     bool
+	dumpXLSX    bool
 )
 
 func check(expr bool, msg string, err error) {
@@ -29,6 +30,7 @@ func init() {
 	flag.BoolVar(&showHelp, "h", false, "display this help information")
 	flag.BoolVar(&showVersion, "v", false, "display version information")
 	flag.BoolVar(&runRepl, "i", false, "Run in interactive mode")
+	flag.BoolVar(&dumpXLSX, "xlsx", false, "print the xlsx files given as JSON records")
 }
 
 func main() {
@@ -43,6 +45,7 @@ func main() {
   -h	display this help information
   -i	Run in interactive mode
   -v	display version information
+  -xlsx	print the xlsx files given as JSON records
 
 `)
 		// FIXME: this writes to stderr, need to write to stdout
@@ -54,6 +57,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Dump any workbooks as JSON instead of running JavaScript
+	if dumpXLSX == true {
+		for _, fname := range flag.Args() {
+			src, err := ostdlib.DumpWorkbookJSON(fname)
+			check(err != nil, fmt.Sprintf("Can't read %s", fname), err)
+			fmt.Println(src)
+		}
+		os.Exit(0)
+	}
+
 	// Create our JavaScriptVM
 	vm := otto.New()
 	js := ostdlib.New(vm)
@@ -20,19 +20,40 @@
 package ostdlib
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	// 3rd Party packages
 	"github.com/chzyer/readline"
@@ -44,6 +65,9 @@ import (
 // Version of the Otto Standard Library
 const Version = "0.0.7"
 
+// maxLineSize is the longest line the line oriented readers (e.g. jsonl) will accept
+const maxLineSize = 16 * 1024 * 1024
+
 var (
 	// Workbookfill wraps the xlsx object to provide a more wholistic worbook experience
 	Workbookfill = `
@@ -60,12 +84,19 @@ var (
 				var data = xlsx.read(name);
 			  	if (data) {
 					 this.__data = data;
+					 this.__filename = name;
 					 return true;
 				}
 				return false;
   			},
-			write: function (name) {
-				return xlsx.write(name, this.__data)
+			write: function (name, options) {
+				if (options === undefined) {
+					return xlsx.write(name, this.__data);
+				}
+				return xlsx.write(name, this.__data, options);
+			},
+			validate: function () {
+				return xlsx.validate(this.__data);
 			},
 			getSheetNames: function () {
 				return Object.keys(this.__data);
@@ -77,8 +108,94 @@ var (
 				return this.__data[name];
 			},
 			setSheet: function(name, sheet) {
+				// the workbook no longer matches the file it was read from
+				delete this.__filename;
 				return (this.__data[name] = sheet);
 			},
+			mapCells: function (sheetName, fn) {
+				var rows = this.getSheet(sheetName), count = 0, val, i, j;
+
+				if (rows === null) {
+					return null;
+				}
+				for (i = 0; i < rows.length; i++) {
+					for (j = 0; j < rows[i].length; j++) {
+						val = fn(rows[i][j], i, j);
+						if (val !== rows[i][j]) {
+							rows[i][j] = val;
+							count++;
+						}
+					}
+				}
+				if (count > 0) {
+					// the workbook no longer matches the file it was read from
+					delete this.__filename;
+				}
+				return count;
+			},
+			inferTypes: function (sheetName, options) {
+				var rows = this.getSheet(sheetName), typed, original, header, records = [], coerce = {}, record, val, i, j;
+
+				if (options !== undefined && options.coerce !== undefined) {
+					coerce = options.coerce;
+				}
+				// Use the cell types from the file when the workbook came from one, for the
+				// cells still holding the value read from it (getSheet(name)[i][j] = ... edits
+				// the sheet in place)
+				if (this.__filename !== undefined) {
+					typed = xlsx.readTyped(this.__filename);
+					original = xlsx.read(this.__filename);
+					if (typed.status === "error" || typed[sheetName] === undefined ||
+						original.status === "error" || original[sheetName] === undefined) {
+						typed = undefined;
+					} else {
+						typed = typed[sheetName];
+						original = original[sheetName];
+					}
+				}
+				if (rows === null || rows.length === 0) {
+					return records;
+				}
+				header = rows[0];
+				for (i = 1; i < rows.length; i++) {
+					record = {};
+					for (j = 0; j < header.length; j++) {
+						val = rows[i][j];
+						if (val === undefined) {
+							val = "";
+						}
+						if (typed !== undefined && typed[i] !== undefined && original[i] !== undefined &&
+							typed[i][j] !== undefined && rows[i][j] === original[i][j]) {
+							val = typed[i][j];
+						}
+						if (typeof val === "string" && val.trim() !== "" && isFinite(val)) {
+							val = Number(val);
+						}
+						switch (coerce[header[j]]) {
+						case "number":
+							val = Number(val);
+							break;
+						case "string":
+							val = (val instanceof Date) ? val.toISOString() : String(val);
+							break;
+						case "boolean":
+							val = (val === true || val === 1 || String(val).toLowerCase() === "true");
+							break;
+						case "date":
+							// numbers are Excel serial day numbers (1900 date system)
+							if (typeof val === "number") {
+								val = new Date(Math.round((val - 25569) * 86400000));
+							} else if ((val instanceof Date) === false) {
+								val = new Date(val);
+							}
+							break;
+						}
+						record[header[j]] = val;
+					}
+					records.push(record);
+				}
+				return records;
+			},
 			getSheetNo: function (sheetNo) {
 				var names = Object.keys(this.__data);
 				if (sheetNo >= 0 && sheetNo < names.length) {
@@ -451,30 +568,474 @@ type HelpMsg struct {
 	Msg      string   `xml:"docstring" json:"docstring"`
 }
 
+// HelpTheme sets the colors GetHelp uses for object names, function signatures, parameters and
+// docstrings, an empty list leaves that part uncolored. Colors are left out when color.NoColor is set.
+type HelpTheme struct {
+	Object    []color.Attribute `xml:"-" json:"object"`
+	Signature []color.Attribute `xml:"-" json:"signature"`
+	Params    []color.Attribute `xml:"-" json:"params"`
+	Doc       []color.Attribute `xml:"-" json:"doc"`
+}
+
 // JavaScriptVM is a wrapper for *otto.Otto to make it easy to add features without forking Otto.
 type JavaScriptVM struct {
 	VM                *otto.Otto
 	AutoCompleter     *readline.PrefixCompleter
-	AutoCompleteTerms []string              `xml:"autocomplete_terms" json:"autocomplete_terms"`
+	AutoCompleteTerms []string `xml:"autocomplete_terms" json:"autocomplete_terms"`
+	// AutoCompleteLimit, when positive, is the most AutoCompleteTerms AddAutoComplete offers, the
+	// terms used most in the repl are kept
+	AutoCompleteLimit int                   `xml:"autocomplete_limit" json:"autocomplete_limit"`
 	Help              map[string][]*HelpMsg `xml:"help" json:"help"`
+	// Stdout is where the repl, help and console.log, info and debug write their output, defaults to os.Stdout
+	Stdout io.Writer `xml:"-" json:"-"`
+	// Stderr is where console.warn, console.error and console.trace write, defaults to os.Stderr
+	Stderr io.Writer `xml:"-" json:"-"`
+	// HistoryFile is the file used by .list, .load, .reset and .save in the repl
+	HistoryFile string `xml:"history_file" json:"history_file"`
+	// ReadlineConfig, when set, is the base configuration Repl gives readline (e.g. for VimMode or
+	// HistoryLimit), empty Prompt, HistoryFile, AutoComplete and InterruptPrompt are filled in
+	ReadlineConfig *readline.Config `xml:"-" json:"-"`
+	// HelpTheme, when set, colors the parts of the help GetHelp shows
+	HelpTheme *HelpTheme `xml:"-" json:"help_theme,omitempty"`
+	// HTTPLogger receives the request and response summaries logged after http.setDebug(true),
+	// the standard logger is used when nil
+	HTTPLogger *log.Logger `xml:"-" json:"-"`
+	// OutputIndent is the indent the repl uses showing objects and arrays as JSON, New sets it to
+	// two spaces, empty shows them compactly on one line
+	OutputIndent string `xml:"output_indent" json:"output_indent"`
+	// DisableHistory stops the repl keeping a history file, .list, .load, .save and .reset report history is disabled
+	DisableHistory bool `xml:"disable_history" json:"disable_history"`
+	// ContinueOnError lets Runner and RunDir log a failing script and carry on with the rest
+	ContinueOnError bool `xml:"continue_on_error" json:"continue_on_error"`
+	// DefaultTimeout, when non-zero, stops any script run by Run, Runner, RunDir or the repl
+	// that takes longer with a timeout error
+	DefaultTimeout time.Duration `xml:"default_timeout" json:"default_timeout"`
+	// DisablePager writes .list and the full help directly to Stdout instead of through $PAGER
+	// (less if unset), the pager is only used when Stdout is a terminal
+	DisablePager bool `xml:"disable_pager" json:"disable_pager"`
+	// JSONErrors makes the extensions log their errors as JSON objects, {level, op, location, message},
+	// instead of plain text
+	JSONErrors bool `xml:"json_errors" json:"json_errors"`
+	// EagerExtensions makes AddExtensions install the os, http, xlsx and ods objects immediately
+	// instead of on their first use
+	EagerExtensions bool `xml:"eager_extensions" json:"eager_extensions"`
+	// AllowedHosts, when not empty, limits the http object to requests for these hosts (e.g.
+	// "127.0.0.1", "localhost" or "*.example.org", matched with path.Match against the host name
+	// without its port), requests for other hosts return an error object without connecting
+	AllowedHosts []string `xml:"allowed_hosts" json:"allowed_hosts"`
+	// OnError, when set, is called with each error that stops a script run by Run, Runner, RunDir
+	// or entered in the repl, in addition to the usual reporting
+	OnError func(error) `xml:"-" json:"-"`
+	// ExitFunc is called by os.exit() and the repl's .exit, defaults to os.Exit. When it returns
+	// (e.g. in an embedder or test) the script calling os.exit() is stopped, Run, Runner, the Eval
+	// methods and the repl return normally but a script evaluated with js.VM directly panics.
+	ExitFunc func(int) `xml:"-" json:"-"`
+
+	// extensions is true once AddExtensions has been applied, registered holds the functions
+	// added with Register, Reset uses both to rebuild the VM
+	extensions bool
+	registered []registration
+
+	// events carries callbacks from background operations to the goroutine running Loop,
+	// ops are the background operations still active. opsDone wakes Loop when an operation
+	// finishes so it can notice none are left.
+	events   chan func()
+	ops      map[int]*backgroundOp
+	nextOpID int
+	opsDone  chan struct{}
+
+	// scriptArgs, when not nil, is what os.args() returns (see RunnerWithArgs)
+	scriptArgs *[]string
+
+	// httpMock, when set, answers or records the requests made by the http object
+	httpMock *httpMock
+	// httpDebug is set by http.setDebug() to log each request and response
+	httpDebug bool
+	// httpTransport, when set by SetHTTPTransport, is used by the http object in place of ownTransport
+	httpTransport http.RoundTripper
+	// ownTransport is the VM's copy of http.DefaultTransport, made on first use so Close only closes
+	// the VM's idle connections and not those of other clients in the program
+	ownTransport http.RoundTripper
+
+	// pending holds the installs of extension objects waiting for their first use (see lazyGroup),
+	// installs counts how many times each has been installed
+	pending  map[string]func()
+	installs map[string]int
+
+	// exitHooks are run (last added first) by os.exit before ExitFunc is called
+	exitHooks []func()
+
+	// session holds the repl commands that ran without error, in order, for .export
+	session []string
+
+	// clock, when set by SetClock, is used by the time object in place of time.Now
+	clock func() time.Time
+
+	// envSnapshots are the environments saved by os.snapshotEnv and not yet restored, by handle,
+	// nextEnvID is the last handle given
+	envSnapshots map[int][]string
+	nextEnvID    int
+
+	// locks are the lock files held with os.lockFile, by handle, nextLockID is the last handle given
+	locks      map[int]string
+	nextLockID int
+
+	// termUsage counts the commands entered in the repl using each autocomplete term's function
+	// (e.g. os.exit), it is saved next to the history file. rankedCompleter is the completer made
+	// by AddAutoComplete which the repl reorders as the counts change.
+	termUsage       map[string]int
+	rankedCompleter *readline.PrefixCompleter
+
+	// mu serializes the methods using the VM (Eval, MustEval, the EvalTo* methods, EvalBatch, Run and
+	// so Runner and RunDir, Loop's callbacks, Register, Reset, Clone, MapInputs, Functions, ActiveOps,
+	// CancelOp, Close and the repl's evaluations) as otto is not safe for concurrent use. It is a
+	// coarse guard against accidental sharing, use Clone to evaluate in parallel. It is not reentrant,
+	// Go functions called by scripts (e.g. added with Register) run while it is held so must use
+	// js.VM or call.Otto rather than these methods. AddExtensions, AddHelp and AddAutoComplete set
+	// the VM up and should be called before it is shared.
+	mu sync.Mutex
+}
+
+// httpMockResponse is a canned response for http.setMock, it is also the format http.record saves
+type httpMockResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// httpMock is an http.RoundTripper answering requests from a table keyed by "METHOD URL". When
+// recordTo is set requests are passed to next and the responses saved to recordTo instead.
+type httpMock struct {
+	sync.Mutex
+	responses map[string]httpMockResponse
+	recordTo  string
+	next      http.RoundTripper
+}
+
+// RoundTrip answers req from the mock table or records the real response
+func (mock *httpMock) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := fmt.Sprintf("%s %s", req.Method, req.URL.String())
+	mock.Lock()
+	recordTo, next := mock.recordTo, mock.next
+	if recordTo == "" {
+		canned, ok := mock.responses[key]
+		mock.Unlock()
+		if ok == false {
+			return nil, fmt.Errorf("no mock response for %s", key)
+		}
+		status := canned.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          ioutil.NopCloser(strings.NewReader(canned.Body)),
+			ContentLength: int64(len(canned.Body)),
+			Request:       req,
+		}
+		for k, v := range canned.Headers {
+			resp.Header.Set(k, v)
+		}
+		return resp, nil
+	}
+	mock.Unlock()
+
+	// the request and reading its body (which may take a long time) are made without the lock
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	recorded := httpMockResponse{Status: resp.StatusCode, Headers: make(map[string]string), Body: string(body)}
+	for k := range resp.Header {
+		recorded.Headers[k] = resp.Header.Get(k)
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	mock.responses[key] = recorded
+	src, err := json.MarshalIndent(mock.responses, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(recordTo, src, 0660)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't record %s to %s, %s", key, recordTo, err)
+	}
+	return resp, nil
+}
+
+// buildURL adds params to the query of base, an array value is added as a repeated key. The
+// query is re-encoded with its keys sorted.
+func buildURL(base string, params map[string]interface{}) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for key, val := range params {
+		vals, ok := val.([]interface{})
+		if ok == false {
+			vals = []interface{}{val}
+		}
+		for _, v := range vals {
+			switch v := v.(type) {
+			case string:
+				q.Add(key, v)
+			case float64:
+				q.Add(key, strconv.FormatFloat(v, 'f', -1, 64))
+			case bool:
+				q.Add(key, strconv.FormatBool(v))
+			case nil:
+				q.Add(key, "")
+			default:
+				return "", fmt.Errorf("parameter %s must be a string, number, boolean or array of them", key)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// headerList holds request headers passed from JavaScript either as an array of single key objects
+// ([{"Accept": "text/plain"}]) or as a plain object ({"Accept": "text/plain"})
+type headerList []map[string]string
+
+// UnmarshalJSON accepts both the array and plain object forms of headers
+func (h *headerList) UnmarshalJSON(src []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(src, &m); err == nil {
+		*h = headerList{m}
+		return nil
+	}
+	var l []map[string]string
+	if err := json.Unmarshal(src, &l); err != nil {
+		return err
+	}
+	*h = l
+	return nil
+}
+
+// hostFilter is an http.RoundTripper refusing requests for hosts not matching one of the allowed
+// patterns (see JavaScriptVM.AllowedHosts), redirects are checked too as they pass through it
+type hostFilter struct {
+	next    http.RoundTripper
+	allowed []string
+}
+
+// RoundTrip passes req to the next RoundTripper if its host is allowed
+func (hf *hostFilter) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, pattern := range hf.allowed {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok == true {
+			return hf.next.RoundTrip(req)
+		}
+	}
+	return nil, fmt.Errorf("requests to %s are not allowed", host)
+}
+
+// httpLogger is an http.RoundTripper logging the method, URL and headers of each request and the
+// status and a summary of the headers of each response. Credentials are redacted.
+type httpLogger struct {
+	next http.RoundTripper
+	logf func(string, ...interface{})
+}
+
+// redactedHeaders are the request headers whose values httpLogger doesn't log
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// RoundTrip logs req, passes it on to next then logs the response
+func (hl *httpLogger) RoundTrip(req *http.Request) (*http.Response, error) {
+	var headers []string
+	for k, v := range req.Header {
+		val := strings.Join(v, ", ")
+		for _, name := range redactedHeaders {
+			if http.CanonicalHeaderKey(k) == name {
+				val = "[redacted]"
+			}
+		}
+		headers = append(headers, fmt.Sprintf("%s: %s", k, val))
+	}
+	sort.Strings(headers)
+	hl.logf("http > %s %s [%s]", req.Method, req.URL, strings.Join(headers, "; "))
+	resp, err := hl.next.RoundTrip(req)
+	if err != nil {
+		hl.logf("http < %s %s, %s", req.Method, req.URL, err)
+		return nil, err
+	}
+	hl.logf("http < %s %s %s [Content-Type: %s; Content-Length: %d]", req.Method, req.URL, resp.Status, resp.Header.Get("Content-Type"), resp.ContentLength)
+	return resp, nil
+}
+
+// httpClient returns the client used by the http object
+func (js *JavaScriptVM) httpClient() *http.Client {
+	transport := js.transport()
+	if js.httpMock != nil {
+		transport = js.httpMock
+	}
+	if js.httpDebug == true {
+		logf := log.Printf
+		if js.HTTPLogger != nil {
+			logf = js.HTTPLogger.Printf
+		}
+		transport = &httpLogger{next: transport, logf: logf}
+	}
+	if len(js.AllowedHosts) > 0 {
+		transport = &hostFilter{next: transport, allowed: js.AllowedHosts}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// transport returns the http.RoundTripper set with SetHTTPTransport, otherwise the VM's own copy
+// of http.DefaultTransport
+func (js *JavaScriptVM) transport() http.RoundTripper {
+	if js.httpTransport != nil {
+		return js.httpTransport
+	}
+	if js.ownTransport == nil {
+		js.ownTransport = http.DefaultTransport
+		if t, ok := http.DefaultTransport.(*http.Transport); ok == true {
+			js.ownTransport = t.Clone()
+		}
+	}
+	return js.ownTransport
+}
+
+// SetHTTPTransport sets the http.RoundTripper used for the requests made by the http object (e.g. one
+// recording latencies or injecting faults), nil restores the VM's copy of http.DefaultTransport.
+// http.setMock and http.setDebug still apply on top of it.
+func (js *JavaScriptVM) SetHTTPTransport(rt http.RoundTripper) {
+	js.httpTransport = rt
+}
+
+// backgroundOp is a long running operation (e.g. http.stream) whose callbacks are run on the
+// VM's goroutine by Loop. It is only modified on that goroutine.
+type backgroundOp struct {
+	id       int
+	kind     string
+	target   string
+	done     chan struct{}
+	cancel   func()
+	finished bool
+}
+
+// OpInfo describes an active background operation, Kind is the function which started it (e.g.
+// http.stream) and Target what it works on (e.g. a URL)
+type OpInfo struct {
+	ID     int    `xml:"id" json:"id"`
+	Kind   string `xml:"kind" json:"kind"`
+	Target string `xml:"target" json:"target"`
+}
+
+// registration is a Go function installed in the VM by Register
+type registration struct {
+	objectName string
+	funcName   string
+	fn         func(otto.FunctionCall) otto.Value
+}
+
+// LineReader is the line editing interface used by the repl, *readline.Instance satisfies it
+type LineReader interface {
+	Readline() (string, error)
+	SetPrompt(string)
+	SaveHistory(string) error
 }
 
 // PrintDefaultWelcome display default weclome message based on
-// JavaScriptVM.HelpMsg
+// JavaScriptVM.HelpMsg to js.Stdout
 func (js *JavaScriptVM) PrintDefaultWelcome() {
+	js.PrintDefaultWelcomeTo(js.stdout())
+}
+
+// PrintDefaultWelcomeTo writes the default welcome message to w
+func (js *JavaScriptVM) PrintDefaultWelcomeTo(w io.Writer) {
 	bold := color.New(color.Bold).SprintFunc()
 	appName := path.Base(os.Args[0])
-	fmt.Printf(" Welcome to %s\n\n", bold(appName))
-	fmt.Printf(" Type %s to exit or %s for help information\n (e.g. %s or %s)\n\n", bold(".exit"), bold(".help"), bold(".help os"), bold(".help os.exit"))
-	fmt.Println(" Help is available for the following objects.")
-	for k := range js.Help {
-		fmt.Printf("\t%s", bold(k))
+	fmt.Fprintf(w, " Welcome to %s\n\n", bold(appName))
+	fmt.Fprintf(w, " Type %s to exit or %s for help information\n (e.g. %s or %s)\n\n", bold(".exit"), bold(".help"), bold(".help os"), bold(".help os.exit"))
+	fmt.Fprintln(w, " Help is available for the following objects.")
+	for _, k := range js.helpObjects() {
+		fmt.Fprintf(w, "\t%s", bold(k))
 	}
-	fmt.Println("")
+	fmt.Fprintln(w, "")
 	if js.AutoCompleter != nil {
-		fmt.Println(" Press tab for auto completion")
+		fmt.Fprintln(w, " Press tab for auto completion")
+	}
+	fmt.Fprintf(w, " repl version %s\n\n", Version)
+}
+
+// helpObjects returns the names of the objects with help in alphabetical order
+func (js *JavaScriptVM) helpObjects() []string {
+	var names []string
+	for k := range js.Help {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// extensionObjects are the global objects installed by AddExtensions
+var extensionObjects = []string{"console", "csv", "fmtx", "http", "json", "jsonl", "ods", "os", "strings", "time", "util", "version", "xlsx", "Workbook"}
+
+// Functions returns the sorted names (e.g. os.exit) of the functions found on the extension objects,
+// the objects with help and the objects used by Register. Extensions waiting for their first use
+// are installed.
+func (js *JavaScriptVM) Functions() []string {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var objects []string
+	if js.extensions == true {
+		objects = append(objects, extensionObjects...)
+	}
+	objects = append(objects, js.helpObjects()...)
+	for _, r := range js.registered {
+		objects = append(objects, r.objectName)
+	}
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, objectName := range objects {
+		if seen[objectName] == true {
+			continue
+		}
+		seen[objectName] = true
+		val, err := js.VM.Get(objectName)
+		if err != nil || val.IsObject() == false {
+			continue
+		}
+		obj := val.Object()
+		for _, key := range obj.Keys() {
+			if fn, err := obj.Get(key); err == nil && fn.IsFunction() == true {
+				names = append(names, objectName+"."+key)
+			}
+		}
 	}
-	fmt.Printf(" repl version %s\n\n", Version)
+	sort.Strings(names)
+	return names
+}
+
+// UndocumentedFunctions returns the names from Functions that have no help
+func (js *JavaScriptVM) UndocumentedFunctions() []string {
+	documented := make(map[string]bool)
+	for _, topics := range js.Help {
+		for _, msg := range topics {
+			documented[msg.Object+"."+msg.Function] = true
+		}
+	}
+	names := []string{}
+	for _, name := range js.Functions() {
+		if documented[name] == false {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 // New create a new JavaScriptVM structure extending the functionality of *otto.Otto
@@ -482,11 +1043,36 @@ func New(vm *otto.Otto) *JavaScriptVM {
 	js := new(JavaScriptVM)
 	js.VM = vm
 	js.Help = make(map[string][]*HelpMsg)
+	js.Stdout = os.Stdout
+	js.Stderr = os.Stderr
+	js.ExitFunc = os.Exit
+	js.OutputIndent = "  "
+	js.events = make(chan func())
+	js.ops = make(map[int]*backgroundOp)
+	js.opsDone = make(chan struct{}, 1)
+	js.pending = make(map[string]func())
+	js.installs = make(map[string]int)
 
 	js.AutoCompleter = readline.NewPrefixCompleter()
 	return js
 }
 
+// stdout returns js.Stdout or os.Stdout if it has not been set
+func (js *JavaScriptVM) stdout() io.Writer {
+	if js.Stdout == nil {
+		return os.Stdout
+	}
+	return js.Stdout
+}
+
+// stderr returns js.Stderr or os.Stderr if it has not been set
+func (js *JavaScriptVM) stderr() io.Writer {
+	if js.Stderr == nil {
+		return os.Stderr
+	}
+	return js.Stderr
+}
+
 // SetHelp adds help documentation by object and function
 func (js *JavaScriptVM) SetHelp(objectName string, functionName string, params []string, text string) {
 	if objectName == "" {
@@ -516,605 +1102,5563 @@ func (js *JavaScriptVM) SetHelp(objectName string, functionName string, params [
 	js.Help[objectName] = data
 }
 
-// GetHelp retrieves help text by object and function names
+// replCommands describes the repl's dot commands, AddHelp adds them to Help under the object name repl
+var replCommands = []*HelpMsg{
+	{Object: "repl", Function: "help", Params: []string{"[TOPIC]"}, Msg: "show help, TOPIC is an object (e.g. os), a function (e.g. os.exit) or a dot command (e.g. .save)"},
+	{Object: "repl", Function: "apropos", Params: []string{"TERM"}, Msg: "list the functions whose help mentions TERM"},
+	{Object: "repl", Function: "break", Params: []string{}, Msg: "break out multi-line entry without saving command"},
+	{Object: "repl", Function: "exit", Params: []string{}, Msg: "exit repl"},
+	{Object: "repl", Function: "export", Params: []string{"FILENAME"}, Msg: "save the commands that ran without error to FILENAME as a script"},
+	{Object: "repl", Function: "list", Params: []string{}, Msg: "list history"},
+	{Object: "repl", Function: "load", Params: []string{"FILENAME"}, Msg: "load history from FILENAME"},
+	{Object: "repl", Function: "ops", Params: []string{"[cancel ID]"}, Msg: "list the active background operations (e.g. http.stream) or cancel the one with ID"},
+	{Object: "repl", Function: "reset", Params: []string{"[history|vars|all]"}, Msg: "trunctate history (default), clear variables or both"},
+	{Object: "repl", Function: "save", Params: []string{"FILENAME"}, Msg: "save history to FILENAME"},
+}
+
+// helpStyle returns a function coloring text with attrs, text is returned unchanged without attrs
+func helpStyle(attrs []color.Attribute) func(...interface{}) string {
+	if len(attrs) == 0 {
+		return fmt.Sprint
+	}
+	return color.New(attrs...).SprintFunc()
+}
+
+// helpSignature formats a function's signature, e.g. os.exit(exitCode int), using js.HelpTheme
+func (js *JavaScriptVM) helpSignature(msg *HelpMsg) string {
+	theme := js.HelpTheme
+	if theme == nil {
+		theme = new(HelpTheme)
+	}
+	signature := helpStyle(theme.Signature)
+	params := ""
+	if len(msg.Params) > 0 {
+		params = helpStyle(theme.Params)(strings.Join(msg.Params, ", "))
+	}
+	if msg.Object == "repl" {
+		// dot commands take space separated arguments, e.g. .save FILENAME
+		if len(msg.Params) > 0 {
+			params = " " + helpStyle(theme.Params)(strings.Join(msg.Params, " "))
+		}
+		return signature("."+msg.Function) + params
+	}
+	return signature(msg.Object+"."+msg.Function+"(") + params + signature(")")
+}
+
+// GetHelp writes the help FormatHelp returns for object and function names to js.Stdout, the
+// overview (both names empty) is shown through the pager
 func (js *JavaScriptVM) GetHelp(objectName, functionName string) {
+	text := js.FormatHelp(objectName, functionName)
+	if objectName == "" && functionName == "" {
+		js.page([]byte(text))
+		return
+	}
+	fmt.Fprint(js.stdout(), text)
+}
+
+// FormatHelp returns the help text for object and function names. Without names it is an overview
+// of the objects and dot commands, with only an object name it lists the object's functions. The
+// dot commands are under the object name repl, a function name without an object (e.g. from
+// ".help .save") is taken to be a dot command.
+func (js *JavaScriptVM) FormatHelp(objectName, functionName string) string {
 	bold := color.New(color.Bold).SprintFunc()
-	if objectName == "" {
+	theme := js.HelpTheme
+	if theme == nil {
+		theme = new(HelpTheme)
+	}
+	object, doc := helpStyle(theme.Object), helpStyle(theme.Doc)
+	if objectName == "" && functionName == "" {
+		buf := new(bytes.Buffer)
 		s := []string{"help provides information about objects and functions"}
-		for ky := range js.Help {
-			s = append(s, ky)
-		}
-		fmt.Printf("%s\n", strings.Join(s, "\n   "))
-		fmt.Println("Additionally the repl provide the following dot commands")
-		fmt.Printf(" %s\tshow help\n", bold(".help"))
-		fmt.Printf(" %s\tbreak out multi-line entry without saving command\n", bold(".break"))
-		fmt.Printf(" %s\texit repl\n", bold(".exit"))
-		fmt.Printf(" %s\tlist history\n", bold(".list"))
-		fmt.Printf(" %s FILENAME\tload history from FILENAME\n", bold(".load"))
-		fmt.Printf(" %s\ttrunctate history\n", bold(".reset"))
-		fmt.Printf(" %s FILENAME\tsave history to FILENAME\n", bold(".save"))
-		return
+		for _, name := range js.helpObjects() {
+			s = append(s, object(name))
+		}
+		fmt.Fprintf(buf, "%s\n", strings.Join(s, "\n   "))
+		fmt.Fprintln(buf, "Additionally the repl provide the following dot commands")
+		for _, cmd := range replCommands {
+			name := bold("." + cmd.Function)
+			if len(cmd.Params) > 0 {
+				name += " " + strings.Join(cmd.Params, " ")
+			}
+			fmt.Fprintf(buf, " %s\t%s\n", name, cmd.Msg)
+		}
+		return buf.String()
+	}
+	if objectName == "" {
+		objectName = "repl"
 	}
-	s := []string{fmt.Sprintf("%s", objectName)}
+	s := []string{object(objectName)}
 	if topics, ok := js.Help[objectName]; ok == true {
 		for _, msg := range topics {
 			if functionName == "" {
-				t := fmt.Sprintf(`%s.%s(%s)`, msg.Object, msg.Function, strings.Join(msg.Params, ", "))
-				s = append(s, t)
+				s = append(s, js.helpSignature(msg))
 			} else if functionName == msg.Function {
-				t := fmt.Sprintf("%s.%s(%s)\n    %s", msg.Object, msg.Function, strings.Join(msg.Params, ", "), msg.Msg)
+				t := fmt.Sprintf("%s\n    %s", js.helpSignature(msg), doc(msg.Msg))
 				s = append(s, t)
 			}
 		}
 	}
-	fmt.Printf("%s\n", strings.Join(s, "\n  "))
-	return
+	return fmt.Sprintf("%s\n", strings.Join(s, "\n  "))
+}
+
+// apropos returns the signatures of the functions whose object, function name, parameters or help
+// text contain term, ignoring case
+func (js *JavaScriptVM) apropos(term string) []string {
+	var matches []string
+	term = strings.ToLower(term)
+	for _, objectName := range js.helpObjects() {
+		for _, msg := range js.Help[objectName] {
+			text := strings.Join([]string{msg.Object, msg.Function, strings.Join(msg.Params, " "), msg.Msg}, " ")
+			if strings.Contains(strings.ToLower(text), term) == true {
+				matches = append(matches, fmt.Sprintf(`%s.%s(%s)`, msg.Object, msg.Function, strings.Join(msg.Params, ", ")))
+			}
+		}
+	}
+	return matches
+}
+
+// page writes text to js.Stdout through $PAGER (less if unset) when Stdout is a terminal
+// and DisablePager is false, otherwise (or if the pager can't be started) text is written directly
+func (js *JavaScriptVM) page(text []byte) {
+	out := js.stdout()
+	if js.DisablePager == false && isTerminal(out) {
+		pager := strings.Fields(os.Getenv("PAGER"))
+		if len(pager) == 0 {
+			pager = []string{"less"}
+		}
+		if js.pageWith(pager, out, text) == true {
+			return
+		}
+	}
+	out.Write(text)
+}
+
+// pageWith runs the pager command with text as its input and out as its output, it returns false
+// only if the pager couldn't be started. A pager exiting with an error (e.g. less quit early) may
+// already have written text so it isn't written again.
+func (js *JavaScriptVM) pageWith(pager []string, out io.Writer, text []byte) bool {
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdin = bytes.NewReader(text)
+	cmd.Stdout = out
+	cmd.Stderr = js.stderr()
+	if err := cmd.Start(); err != nil {
+		return false
+	}
+	cmd.Wait()
+	return true
+}
+
+// isTerminal reports if w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	fp, ok := w.(*os.File)
+	if ok == false {
+		return false
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		return false
+	}
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// completePath lists the files and directories (ending in a slash) matching the path typed
+// after a dot command, e.g. ".load src/" lists the contents of src
+func completePath(line string) []string {
+	prefix := ""
+	if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+		prefix = parts[1]
+	}
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil
+	}
+	for i, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() == true {
+			matches[i] = match + string(filepath.Separator)
+		}
+	}
+	return matches
 }
 
 // AddAutoComplete populates the auto completion based on the help data structure
 func (js *JavaScriptVM) AddAutoComplete() {
 	completer := readline.NewPrefixCompleter()
-	children := completer.GetChildren()
+	completer.SetChildren(js.autoCompleteItems(completer.GetChildren()))
+	js.AutoCompleter = completer
+	js.rankedCompleter = completer
+}
+
+// autoCompleteItems returns children with the dot commands and the ranked AutoCompleteTerms added
+func (js *JavaScriptVM) autoCompleteItems(children []readline.PrefixCompleterInterface) []readline.PrefixCompleterInterface {
 	children = append(children, readline.PcItem(".help"))
+	children = append(children, readline.PcItem(".apropos"))
 	children = append(children, readline.PcItem(".break"))
 	children = append(children, readline.PcItem(".exit"))
+	children = append(children, readline.PcItem(".export", readline.PcItemDynamic(completePath)))
 	children = append(children, readline.PcItem(".list"))
-	children = append(children, readline.PcItem(".load"))
+	children = append(children, readline.PcItem(".ops"))
+	children = append(children, readline.PcItem(".load", readline.PcItemDynamic(completePath)))
 	children = append(children, readline.PcItem(".reset"))
-	children = append(children, readline.PcItem(".save"))
-	for _, text := range js.AutoCompleteTerms {
+	children = append(children, readline.PcItem(".save", readline.PcItemDynamic(completePath)))
+	for _, text := range js.rankedTerms() {
 		children = append(children, readline.PcItem(text))
 	}
-	completer.SetChildren(children)
-	js.AutoCompleter = completer
+	return children
+}
+
+// termName returns the function an autocomplete term is for, e.g. os.exit for "os.exit(exitCode int)"
+func termName(term string) string {
+	if p := strings.Index(term, "("); p > -1 {
+		return term[0:p]
+	}
+	return term
+}
+
+// rankedTerms returns AutoCompleteTerms ordered by how often the repl has used them, most used
+// first and otherwise in their original order, keeping at most AutoCompleteLimit when it is positive
+func (js *JavaScriptVM) rankedTerms() []string {
+	terms := append([]string{}, js.AutoCompleteTerms...)
+	sort.SliceStable(terms, func(i, j int) bool {
+		return js.termUsage[termName(terms[i])] > js.termUsage[termName(terms[j])]
+	})
+	if js.AutoCompleteLimit > 0 && len(terms) > js.AutoCompleteLimit {
+		terms = terms[0:js.AutoCompleteLimit]
+	}
+	return terms
+}
+
+// countTermUsage counts a use of each autocomplete term whose function is called in src, it
+// returns true if any were
+func (js *JavaScriptVM) countTermUsage(src string) bool {
+	counted := false
+	for _, term := range js.AutoCompleteTerms {
+		name := termName(term)
+		if strings.Contains(src, name+"(") == true {
+			if js.termUsage == nil {
+				js.termUsage = make(map[string]int)
+			}
+			js.termUsage[name]++
+			counted = true
+		}
+	}
+	return counted
+}
+
+// termUsageFile returns the file the usage counts are kept in, "" when history is disabled
+func (js *JavaScriptVM) termUsageFile() string {
+	if js.DisableHistory == true || js.HistoryFile == "" {
+		return ""
+	}
+	return js.HistoryFile + ".usage"
+}
+
+// loadTermUsage reads the usage counts saved with the history, if any, and reorders the completer
+func (js *JavaScriptVM) loadTermUsage() {
+	fname := js.termUsageFile()
+	if fname == "" {
+		return
+	}
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(src, &js.termUsage); err != nil {
+		log.Printf("Can't read %s, %s", fname, err)
+		return
+	}
+	js.rerankAutoComplete()
+}
+
+// saveTermUsage writes the usage counts next to the history file
+func (js *JavaScriptVM) saveTermUsage() {
+	fname := js.termUsageFile()
+	if fname == "" {
+		return
+	}
+	src, _ := json.Marshal(js.termUsage)
+	if err := ioutil.WriteFile(fname, src, 0660); err != nil {
+		log.Printf("Can't write %s, %s", fname, err)
+	}
+}
+
+// rerankAutoComplete rebuilds the terms of the completer made by AddAutoComplete in ranked order,
+// one set directly on js.AutoCompleter is left alone
+func (js *JavaScriptVM) rerankAutoComplete() {
+	if js.AutoCompleter == nil || js.AutoCompleter != js.rankedCompleter {
+		return
+	}
+	js.AutoCompleter.SetChildren(js.autoCompleteItems(nil))
+}
+
+// objectNamed returns the global object objectName, creating it if it is undefined
+func (js *JavaScriptVM) objectNamed(objectName string) (*otto.Object, error) {
+	val, err := js.VM.Get(objectName)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case val.IsObject() == true:
+		return val.Object(), nil
+	case val.IsUndefined() == true:
+		return js.VM.Object(fmt.Sprintf(`%s = {}`, objectName))
+	}
+	return nil, fmt.Errorf("%s is not an object", objectName)
+}
+
+// Register installs fn as objectName.funcName in the VM, creating the object if needed, and records
+// its help and autocomplete term in one call. The completer made by AddAutoComplete is updated
+// to offer the new term.
+func (js *JavaScriptVM) Register(objectName, funcName string, fn func(otto.FunctionCall) otto.Value, params []string, doc string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	obj, err := js.objectNamed(objectName)
+	if err != nil {
+		return fmt.Errorf("Can't register %s.%s, %s", objectName, funcName, err)
+	}
+	if err := obj.Set(funcName, fn); err != nil {
+		return fmt.Errorf("Can't register %s.%s, %s", objectName, funcName, err)
+	}
+	js.SetHelp(objectName, funcName, params, doc)
+	js.registered = append(js.registered, registration{objectName: objectName, funcName: funcName, fn: fn})
+	js.rerankAutoComplete()
+	return nil
+}
+
+// Reset clears all variables by replacing js.VM with a fresh *otto.Otto. Extensions and functions
+// added with Register are installed again, anything else set directly on the old VM is lost.
+func (js *JavaScriptVM) Reset() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.VM = otto.New()
+	js.reinstall()
+}
+
+// Clone returns a new JavaScriptVM with a copy of js.VM, extensions and functions added with Register
+// are installed again so they are bound to the clone. Use a clone per goroutine to run scripts in parallel.
+func (js *JavaScriptVM) Clone() *JavaScriptVM {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	clone := New(js.VM.Copy())
+	for k, v := range js.Help {
+		clone.Help[k] = v
+	}
+	clone.AutoCompleteTerms = append(clone.AutoCompleteTerms, js.AutoCompleteTerms...)
+	clone.AutoCompleter = js.AutoCompleter
+	clone.Stdout = js.Stdout
+	clone.Stderr = js.Stderr
+	clone.HistoryFile = js.HistoryFile
+	clone.DisableHistory = js.DisableHistory
+	clone.ReadlineConfig = js.ReadlineConfig
+	clone.HTTPLogger = js.HTTPLogger
+	clone.HelpTheme = js.HelpTheme
+	clone.ContinueOnError = js.ContinueOnError
+	clone.ExitFunc = js.ExitFunc
+	clone.OnError = js.OnError
+	clone.EagerExtensions = js.EagerExtensions
+	clone.DisablePager = js.DisablePager
+	clone.JSONErrors = js.JSONErrors
+	clone.DefaultTimeout = js.DefaultTimeout
+	clone.AllowedHosts = js.AllowedHosts
+	clone.OutputIndent = js.OutputIndent
+	clone.clock = js.clock
+	clone.extensions = js.extensions
+	clone.registered = js.registered
+	clone.reinstall()
+	return clone
+}
+
+// AtExit adds a function to be run when a script calls os.exit(), e.g. to flush buffered output.
+// Functions are run in reverse order of being added.
+func (js *JavaScriptVM) AtExit(fn func()) {
+	js.exitHooks = append(js.exitHooks, fn)
+}
+
+// exit runs the exit hooks then exits with exitCode. The hooks are cleared first so they run
+// once even when ExitFunc returns (e.g. in an embedder or test).
+func (js *JavaScriptVM) exit(exitCode int) {
+	hooks := js.exitHooks
+	js.exitHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+	if js.ExitFunc == nil {
+		os.Exit(exitCode)
+	}
+	js.ExitFunc(exitCode)
+}
+
+// reinstall adds the extensions (if previously added) and registered functions to js.VM
+func (js *JavaScriptVM) reinstall() {
+	registered := js.registered
+	js.registered = nil
+	if js.extensions == true {
+		js.AddExtensions()
+	}
+	for _, r := range registered {
+		obj, err := js.objectNamed(r.objectName)
+		if err != nil {
+			log.Printf("Can't restore %s.%s, %s", r.objectName, r.funcName, err)
+			continue
+		}
+		obj.Set(r.funcName, r.fn)
+		js.registered = append(js.registered, r)
+	}
 }
 
 // AddHelp adds the interactive help based on the extensions defined in ostdlib
 func (js *JavaScriptVM) AddHelp() {
 	js.SetHelp("os", "args", []string{}, "Exposes any command line arguments left after flag.Parse() has run.")
-	js.SetHelp("os", "exit", []string{"exitCode int, log_msg string"}, "Stops the program existing with the numeric value given(e.g. zero if everything is OK), an optional log message can be included.")
+	js.SetHelp("os", "parseArgs", []string{"spec array", "args array"}, "Parses the flags declared in spec, an array of {name, type, default, alias} where type is string (the default), number or bool, from args (os.args() when not given). Returns {flags, positional} with the flag values by name, flags not given have their default or \"\", 0 or false. Flags are written --name value, --name=value or -alias value, bool flags take no value, \"--\" ends the flags. An unknown flag, missing value or bad number returns an error object")
+	js.SetHelp("os", "exit", []string{"exitCode int, log_msg string"}, "Stops the program existing with the numeric value given(e.g. zero if everything is OK), an optional log message can be included. A non-numeric exitCode is logged and replaced with 1. Any functions added with os.atExit() are run first.")
+	js.SetHelp("os", "atExit", []string{"callback function"}, "Adds a callback to be run before os.exit() stops the program, e.g. to flush buffered output")
+	js.SetHelp("os", "getpid", []string{}, "Returns the process id")
+	js.SetHelp("os", "hostInfo", []string{}, "Returns an object with the hostname, pid, numCPU and goVersion")
 	js.SetHelp("os", "getEnv", []string{"envvar string"}, `Gets the environment variable matching the structing. (e.g. os.getEnv(\"HOME\")`)
-	js.SetHelp("os", "setEnv", []string{"envvar string"}, `Sets the environment variable. (e.g. os.setEnv(\"Welcome\", \"Hi there\")`)
+	js.SetHelp("os", "setEnv", []string{"envvar string"}, `Sets the environment variable for this process and the commands it runs with os.exec. (e.g. os.setEnv(\"Welcome\", \"Hi there\")`)
+	js.SetHelp("os", "lockFile", []string{"filepath string"}, "Takes an advisory lock on filepath by creating filepath.lock (holding the process id), returns a handle for os.unlock or an error object if the lock is already held. Locks still held are released by the JavaScriptVM's Close, a lock file left by a crashed process must be removed by hand")
+	js.SetHelp("os", "unlock", []string{"handle number"}, "Releases a lock taken with os.lockFile, removing its lock file")
+	js.SetHelp("os", "snapshotEnv", []string{}, "Saves the process environment returning a handle for os.restoreEnv, e.g. so a script can undo its os.setEnv changes before the next one runs")
+	js.SetHelp("os", "restoreEnv", []string{"handle number"}, "Resets the process environment to the one saved by os.snapshotEnv, variables set since are removed and changed or removed ones are put back. The snapshot is released once restored, take a new one to restore again")
+	js.SetHelp("os", "pipeline", []string{"stages array"}, "Runs an array of {cmd, args} objects like a shell pipeline, each command's stdout is the next one's stdin. Returns {code, stdout, stderr} of the final command, an error object naming the stage if one can't be started or waited on")
+	js.SetHelp("os", "exec", []string{"command string", "args array", "options object"}, "Runs command with args returning {stdout, stderr, exitCode}, an error object if it can't be run. The command inherits the environment, including variables set with os.setEnv, unless options.inheritEnv is false. options.env is an object of extra variables for the command")
+	js.SetHelp("os", "loadEnv", []string{"filepath string"}, "Sets the environment variables defined as KEY=value lines in a .env file, comments and blank lines are ignored and values may be quoted. Returns the count of variables set")
 	js.SetHelp("os", "readFile", []string{"filepath"}, "Reads the filename provided and returns the results as a JavaScript string")
+	js.SetHelp("os", "readFileRange", []string{"filepath string", "offset number", "length number"}, "Reads length bytes starting at offset without loading the rest of the file, returns them as an array of numbers (0-255), fewer are returned when the range runs past the end of the file")
 	js.SetHelp("os", "writeFile", []string{"filepath string", "content string"}, "Writes a file, parameters are filepath and contents which are both strings")
-	js.SetHelp("os", "rename", []string{"oldpath string", "newpath string"}, "Renames oldpath to newpath")
+	js.SetHelp("os", "readJSON", []string{"filepath string"}, "Reads a JSON file returning the parsed value or an error object")
+	js.SetHelp("os", "writeJSON", []string{"filepath string", "value any", "pretty boolean"}, "Writes value to filepath as JSON, indented when pretty is true, returns true or an error object")
+	js.SetHelp("os", "rename", []string{"oldpath string", "newpath string", "overwrite boolean"}, "Renames oldpath to newpath, an existing newpath is only replaced when overwrite is true (e.g. os.rename(\"a.txt\", \"b.txt\", {overwrite: true}))")
+	js.SetHelp("os", "hardlink", []string{"oldname string", "newname string"}, "Creates newname as a hard link to oldname, fails if they are on different filesystems")
+	js.SetHelp("os", "copyFile", []string{"src string", "dst string", "options object"}, "Copies src to dst preserving the file mode, an existing dst is only replaced when overwrite is true (e.g. os.copyFile(\"a.txt\", \"b.txt\", {overwrite: true})). options may be the overwrite boolean or {overwrite, preserveTimes}, with preserveTimes true dst is given src's modification time")
+	js.SetHelp("os", "copyGlob", []string{"pattern string", "destDir string", "options object"}, "Copies the files matching pattern into destDir, creating it if needed, keeping their names and modes. Directories and matches already in destDir are skipped, existing files are only replaced when overwrite is true. options may be the overwrite boolean or {overwrite, preserveTimes}, with preserveTimes true the copies keep the modification times. Returns an array of the copied paths (e.g. os.copyGlob(\"*.txt\", \"dist\"))")
+	js.SetHelp("os", "copyDir", []string{"src string", "dst string", "options object"}, "Copies the directory tree src to dst, creating dst if needed and keeping the modes, existing files are only replaced when overwrite is true. options may be the overwrite boolean or {overwrite, preserveTimes}, with preserveTimes true the copied files and directories keep their modification times. Returns an array of the copied file paths (e.g. os.copyDir(\"site\", \"dist/site\", {preserveTimes: true}))")
+	js.SetHelp("os", "newerThan", []string{"pathA string", "pathB string"}, "Returns true if pathA has a more recent modification time than pathB, an error object if either is missing")
+	js.SetHelp("os", "splitPath", []string{"pathname string"}, "Returns {dir, file} splitting pathname after its last separator using the rules of the operating system (e.g. drive letters on Windows)")
+	js.SetHelp("os", "volumeName", []string{"pathname string"}, "Returns the leading volume name of pathname, e.g. \"C:\" or \"\\\\host\\share\" on Windows, an empty string on other operating systems")
+	js.SetHelp("os", "isExecutable", []string{"filepath string"}, "Returns true if any of the file's execute bits are set, an error object if it can't be read")
+	js.SetHelp("os", "makeExecutable", []string{"filepath string"}, "Adds the execute bits (0111) to the file's mode, returns true or an error object")
+	js.SetHelp("os", "sameFile", []string{"pathA string", "pathB string"}, "Returns true if the two files have identical contents, an error object if either can't be read")
+	js.SetHelp("os", "hashFile", []string{"pathname string", "algo string"}, "Returns the hex digest of a file's contents, algo is \"md5\", \"sha1\" or \"sha256\" (the default)")
+	js.SetHelp("os", "realpath", []string{"pathname string"}, "Returns the absolute path with symbolic links resolved, an error object if a component of the path is missing")
+	js.SetHelp("os", "removeGlob", []string{"pattern string", "confirmFn function"}, "Removes the files (not directories) matching pattern for which confirmFn(path) returns true, pass {force: true} instead of confirmFn to remove every match. Returns an array of the removed paths or an error object whose removed property lists the paths removed before the error. Symbolic links are removed, not what they point at")
 	js.SetHelp("os", "remove", []string{"filepath string"}, "Removes the file indicated by filepath")
 	js.SetHelp("os", "chmod", []string{"filepath string", "perms numeric"}, "Sets the permissions for a file (e.g. 0775, 0664)")
-	js.SetHelp("os", "find", []string{"startpath string"}, "Looks for a files in startpath")
+	js.SetHelp("os", "find", []string{"startpath string", "options object"}, "Looks for a files in startpath, inaccessible paths are logged and skipped. With {withErrors: true} returns {paths, errors} where errors lists the {path, error} of inaccessible paths")
+	js.SetHelp("os", "sizeByExt", []string{"root string"}, "Walks the tree under root returning an object of file extensions, lowercased and including the dot (\"\" for none), pointing at {bytes, files}, the total size and count of the regular files with the extension. Inaccessible paths are logged and skipped")
+	js.SetHelp("os", "walk", []string{"startpath string", "visitor function", "onError function"}, "Calls visitor({path, isDir, size}) for each entry as startpath is walked, return \"skip\" from visitor to skip a directory or pass over a file. Inaccessible paths are passed to onError({path, error}) (or logged) and the walk continues")
+	js.SetHelp("os", "tail", []string{"filepath string", "n int"}, "Returns the last n lines (default 10) of filepath as an array, only the end of the file is read")
+	js.SetHelp("os", "grep", []string{"filepath string", "pattern string", "options object"}, "Returns the lines of filepath matching the Go regular expression pattern, with {count: true} returns the number of matching lines instead")
 	js.SetHelp("os", "mkdir", []string{"pathname string", "perms numeric"}, "Makes a directory with the permissions (e.g. 0775)")
 	js.SetHelp("os", "mkdirAll", []string{"pathname string", "perms numeric"}, "Makes a directory including missing ones in the path. E.g mkdir -p in Unix shell")
+	js.SetHelp("os", "withTempFile", []string{"callback function"}, "Creates an empty temp file and calls callback(path), the file is removed when callback returns or throws. Returns callback's result or an error object")
+	js.SetHelp("os", "withTempDir", []string{"callback function"}, "Creates a temp directory and calls callback(path), the directory and its contents are removed when callback returns or throws. Returns callback's result or an error object")
+	js.SetHelp("os", "mktree", []string{"baseDir string", "spec object"}, "Creates a tree of directories and files under baseDir, keys of spec are names, string values are file contents and objects are subdirectories (e.g. os.mktree(\"site\", {\"index.html\": \"\", css: {\"site.css\": \"\"}}))")
 	js.SetHelp("os", "rmdir", []string{"pathname string"}, "Removes the directory specified with pathname")
 	js.SetHelp("os", "rmdirAll", []string{"pathname string"}, "Removes a directory and any included in pathname")
-	js.SetHelp("http", "get", []string{"uri string", "headers []object"}, "performs a synchronous http GET operation")
-	js.SetHelp("http", "post", []string{"uri string", "headers []object", "payload string"}, "Performs a synchronous http POST operation")
-	js.SetHelp("xlsx", "read", []string{"filename string"}, "Reads in an Excel xlsx workbook file and returns an object contains the sheets found or error object")
-	js.SetHelp("xlsx", "write", []string{"filename string, sheetObject object"}, "Write an Excel xlsx workbook file and returns true on success or error object")
+	js.SetHelp("http", "get", []string{"uri string", "headers object", "full boolean"}, "performs a synchronous http GET operation returning the response body, headers may be a plain object or an array of single key objects. When full is true the response is returned as {status, statusText, headers, body} where headers holds an array of values for each name, redirects are not followed so their status and Location header can be read")
+	js.SetHelp("http", "post", []string{"uri string", "mimeType string", "payload string", "headers object", "full boolean"}, "Performs a synchronous http POST operation returning the response body, headers may be a plain object or an array of single key objects. When full is true the response is returned as {status, statusText, headers, body} where headers holds an array of values for each name, redirects are not followed so their status and Location header can be read")
+	js.SetHelp("http", "put", []string{"uri string", "mimeType string", "payload string", "headers object"}, "Performs a synchronous http PUT operation returning the response body, headers may be a plain object or an array of single key objects")
+	js.SetHelp("http", "delete", []string{"uri string", "headers object"}, "Performs a synchronous http DELETE operation returning the response body, an empty string when there is none. headers may be a plain object or an array of single key objects")
+	js.SetHelp("http", "buildURL", []string{"base string", "params object"}, "Returns base with the properties of params added as URL encoded query parameters (spaces become +), an array value adds the key once per element, null adds an empty value. Any existing query is kept and parameters are sorted by key")
+	js.SetHelp("http", "setDebug", []string{"on boolean"}, "Logs the method, URL and headers of each request and the status of each response when on is true, Authorization and Cookie values are redacted")
+	js.SetHelp("http", "download", []string{"uri string", "filepath string", "options object"}, "Saves the response body to filepath returning {bytes, total}. Options may include headers, onProgress({bytes, total}) called as the body is copied (total is null without a Content-Length) and resume, when true a partial filepath is continued with a Range request")
+	js.SetHelp("http", "stream", []string{"uri string", "onEvent function", "options object"}, "Reads a Server-Sent-Events stream calling onEvent({event, data, id}) per event, returns a handle with a stop() method. Options may include headers. Callbacks run while the event loop is pumped, e.g. after a script run by the Runner, and in the repl before each prompt")
+	js.SetHelp("http", "setMock", []string{"table object"}, "Answers requests from table without using the network, keys are \"METHOD URL\" (e.g. \"GET https://example.org/\") pointing at a body string or a {status, headers, body} object. http.setMock(null) restores network access")
+	js.SetHelp("http", "record", []string{"filepath string"}, "Makes real requests saving the responses to filepath, replay them with http.setMock(JSON.parse(os.readFile(filepath)))")
+	js.SetHelp("time", "now", []string{}, "Returns the current time as a Date, use it rather than new Date() so the time can be fixed when testing a script (see SetClock)")
+	js.SetHelp("util", "debounce", []string{"fn function", "ms number"}, "Returns a function which calls fn, with the latest arguments, once it hasn't been called for ms milliseconds. The timer runs on the event loop so fn is only called while it is pumped, e.g. after a script run by the Runner or in the repl before each prompt")
+	js.SetHelp("util", "sortBy", []string{"array array", "keys array"}, "Returns a sorted copy of an array of objects, comparing the fields named in keys in turn, a leading - (e.g. \"-salary\") sorts that field descending. The sort is stable. Numbers compare numerically and strings by character code (\"10\" before \"9\", \"B\" before \"a\"), false before true. A missing or null field sorts before booleans, then numbers, then strings, then arrays and objects")
+	js.SetHelp("util", "throttle", []string{"fn function", "ms number"}, "Returns a function which calls fn straight away then at most once every ms milliseconds, a call made in between is delayed to the end of the interval with the latest arguments. The timer runs on the event loop so delayed calls are only made while it is pumped, e.g. after a script run by the Runner or in the repl before each prompt")
+	js.SetHelp("version", "string", []string{}, "Returns the ostdlib version, e.g. \""+Version+"\"")
+	js.SetHelp("version", "major", []string{}, "Returns the major number of the ostdlib version")
+	js.SetHelp("version", "minor", []string{}, "Returns the minor number of the ostdlib version")
+	js.SetHelp("version", "patch", []string{}, "Returns the patch number of the ostdlib version")
+	js.SetHelp("console", "log", []string{"values ...any"}, "Writes the values separated by spaces to the VM's Stdout")
+	js.SetHelp("console", "info", []string{"values ...any"}, "Same as console.log")
+	js.SetHelp("console", "warn", []string{"values ...any"}, "Writes the values separated by spaces to the VM's Stderr")
+	js.SetHelp("console", "error", []string{"values ...any"}, "Writes the values separated by spaces to the VM's Stderr")
+	js.SetHelp("console", "debug", []string{"values ...any"}, "Same as console.log")
+	js.SetHelp("console", "trace", []string{"values ...any"}, "Writes the values separated by spaces to the VM's Stderr")
+	js.SetHelp("strings", "shellQuote", []string{"s string"}, "Returns s single quoted so a POSIX shell (e.g. sh, bash) treats it as one word, other shells are not supported")
+	js.SetHelp("strings", "urlEncode", []string{"s string"}, "Returns s escaped for use in a URL query, spaces become +")
+	js.SetHelp("strings", "urlDecode", []string{"s string"}, "Returns s with URL query escapes decoded, an error object for malformed escapes")
+	js.SetHelp("strings", "isValidUTF8", []string{"s string"}, "Returns true if s is valid UTF-8, files read with os.readFile may not be")
+	js.SetHelp("strings", "toValidUTF8", []string{"s string", "replacement string"}, "Returns s with each run of invalid UTF-8 bytes replaced by replacement, \"\\uFFFD\" if not given")
+	js.SetHelp("csv", "read", []string{"filepath string", "options object"}, "Reads a CSV file returning an array of rows, each an array of cells. With options {infer: true} \"true\" and \"false\" (any case) become booleans and cells like 42, -1.5 or 2e3 become numbers. Cells with leading zeros (007), bare decimal points (.5), surrounding spaces or integers beyond 2^53 stay strings")
+	js.SetHelp("csv", "forEach", []string{"filepath string", "callback function", "options object"}, "Calls callback(row, rowNo) for each row of a CSV file, return false from callback to stop. Options are the same as csv.read. Returns the count of rows processed")
+	js.SetHelp("fmtx", "number", []string{"n number", "decimals number"}, "Returns n rounded to decimals places (default 0) with commas separating the thousands, e.g. fmtx.number(1234567.891, 2) is \"1,234,567.89\"")
+	js.SetHelp("fmtx", "bytes", []string{"n number"}, "Returns a byte count in binary units (1 KB is 1024 bytes) with one decimal place, e.g. fmtx.bytes(1572864) is \"1.5 MB\", counts under 1 KB are shown as bytes, e.g. \"512 B\"")
+	js.SetHelp("fmtx", "percent", []string{"n number", "decimals number"}, "Returns the ratio n as a percentage rounded to decimals places (default 0), e.g. fmtx.percent(0.256, 1) is \"25.6%\"")
+	js.SetHelp("json", "writeArray", []string{"filepath string", "sourceFn function"}, "Streams a JSON array to filepath without building it in memory, sourceFn(index) returns each element in turn and undefined when done. Returns the count of elements written")
+	js.SetHelp("jsonl", "read", []string{"filepath string"}, "Reads a newline delimited JSON file returning an array of the values found, blank lines are skipped")
+	js.SetHelp("jsonl", "write", []string{"filepath string", "values array"}, "Writes each element of values as compact JSON one per line")
+	js.SetHelp("jsonl", "forEach", []string{"filepath string", "callback function"}, "Calls callback(value, lineNo) for each line of a newline delimited JSON file, return false from callback to stop. Returns the count of values processed")
+	js.SetHelp("xlsx", "read", []string{"filename string", "options object"}, "Reads in an Excel xlsx workbook file and returns an object contains the sheets found or error object. With options {formulas: true} each cell is an object, {value, formula}, where formula (e.g. \"=SUM(A2:A3)\") is only present for cells with one. With options {fillMerged: true} each cell of a merged range has the value of its top left cell rather than being empty")
+	js.SetHelp("xlsx", "readEncrypted", []string{"filename string", "password string"}, "Reads a password protected Excel xlsx workbook (agile encryption, Excel 2010 and later) returning an object like xlsx.read, an error object if the password is incorrect")
+	js.SetHelp("xlsx", "readRange", []string{"filename string", "sheetName string", "a1Range string"}, "Reads a block of cells (e.g. \"A1:C10\") from the named sheet returning a 2d-array of strings sized to the range, blank cells are empty strings")
+	js.SetHelp("xlsx", "sheetNames", []string{"filename string"}, "Returns an array of the sheet names in an Excel xlsx workbook file without reading the cells")
+	js.SetHelp("xlsx", "diff", []string{"fileA string", "fileB string"}, "Compares two workbooks cell by cell, reading them like xlsx.readTyped, returning an array of {sheet, row, col, a, b} for each cell that differs where row and col count from zero and a and b are the values in each workbook, a missing cell counts as an empty string. A sheet in only one workbook is reported once with row and col null, a and b are true for the workbook with the sheet. An empty array means the workbooks match")
+	js.SetHelp("xlsx", "validateHeader", []string{"filename string", "sheetName string", "expectedColumns array", "options object"}, "Compares the first row of a sheet with expectedColumns returning {valid, missing, extra}, missing are the expected columns not found and extra the columns not expected, empty header cells are ignored. With options {ordered: true} the columns must also be in the expected order to be valid")
+	js.SetHelp("xlsx", "records", []string{"filename string", "sheetName string"}, "Returns the rows of one sheet as an array of objects keyed by the first row. Booleans and numbers keep their type, numbers with a date format become Date objects and everything else is a string. Empty headers become column_N (N counting from 1), repeated headers get a suffix making them unique (name, name_2, name_3), cells past the header are keyed column_N and rows with only empty cells are skipped. Only the named sheet is read, not the whole workbook")
+	js.SetHelp("xlsx", "readTyped", []string{"filename string"}, "Reads an Excel xlsx workbook file like xlsx.read but numeric and boolean cells keep their type and date formatted cells become Date objects")
+	js.SetHelp("xlsx", "readRich", []string{"filename string"}, "Reads an Excel xlsx workbook file returning an object with sheets (as xlsx.read), comments and hyperlinks, the latter two keyed by sheet name then A1 reference")
+	js.SetHelp("xlsx", "write", []string{"filename string, sheetObject object", "options object"}, "Write an Excel xlsx workbook file and returns true on success or error object. Sheets are added in the order of sheetObject's properties, a Workbook object (see xlsx.New) may be given in place of sheetObject. Options may set the activeSheet name and columnWidths, an object of sheet names pointing at an array of widths (e.g. {activeSheet: \"Sheet2\", columnWidths: {Sheet1: [20, 12]}})")
+	js.SetHelp("xlsx", "append", []string{"filename string", "sheetName string", "rows array"}, "Adds rows (an array of arrays of cells) to the end of the named sheet keeping the other sheets, the workbook and sheet are created if missing. Returns true or an error object")
+	js.SetHelp("xlsx", "validate", []string{"sheetObject object"}, "Returns true if each sheet is an array of rows of the same length holding strings, numbers or booleans, otherwise an error object naming the first offending sheet (in property order) and row")
 	js.SetHelp("xlsx", "New", []string{}, "Constructor for Workbook object")
 	// Help for JavaScript native Workbook object that wraps xlsx
 	js.SetHelp("Workbook", "read", []string{"filename string"}, "reads an xlsx file into the workbook")
-	js.SetHelp("Workbook", "write", []string{"filename string"}, "write an xlsx file from the workbook")
+	js.SetHelp("Workbook", "write", []string{"filename string", "options object"}, "write an xlsx file from the workbook, options are the same as for xlsx.write")
+	js.SetHelp("Workbook", "validate", []string{}, "checks the workbook can be written, returns true or an error object naming the first offending sheet and row")
+	js.SetHelp("Workbook", "inferTypes", []string{"sheetName string", "options object"}, "returns the sheet as an array of records keyed by the header row. Numbers, booleans and dates keep their type when the workbook was read from a file. options.coerce maps a column name to \"number\", \"string\", \"boolean\" or \"date\" to force its type, numbers coerced to dates are Excel serial day numbers (1900 date system). Cells changed since the workbook was read are typed from their new value")
+	js.SetHelp("ods", "read", []string{"filename string"}, "Reads an OpenDocument spreadsheet (.ods) returning an object of sheet names pointing at 2d-arrays of strings, like xlsx.read, or an error object. Cells are read as the text shown, trailing empty rows and cells are left out")
+	js.SetHelp("ods", "write", []string{"filename string", "sheetsObject object"}, "Writes an object of sheet names pointing at 2d-arrays of cells, like the one ods.read or xlsx.read returns, as an OpenDocument spreadsheet, cells are written as strings")
 	js.SetHelp("Workbook", "getSheetNames", []string{}, "returns an array of names of the spreadsheets in a workbook")
 	js.SetHelp("Workbook", "getSheet", []string{"name string"}, "get the individual spreadsheet by name from the workbook")
 	js.SetHelp("Workbook", "setSheet", []string{"name string", "sheet is a 2D array of rows and cells"}, "set a spreadsheet by name to the rows and cell defined by sheet")
+	js.SetHelp("Workbook", "mapCells", []string{"sheetName string", "fn function"}, "calls fn(value, row, col) for each cell of the named spreadsheet (row and col count from zero) replacing the cell with the value returned, returns the number of cells changed or null if there is no such spreadsheet")
 	js.SetHelp("Workbook", "getSheetNo", []string{"sheetNo int"}, "get the individual spreadsheet by sheet no. from the workbook")
 	js.SetHelp("Workbook", "setSheetNo", []string{"sheetNo", "sheet is a 2D array of rows and cells"}, "set a spreadsheet by sheet no. to the rows and cell defined by sheet")
 	js.SetHelp("Workbook", "valueOf", []string{}, "returns the __data attribute of the workbook")
 	js.SetHelp("Workbook", "toString", []string{}, "returns a JSON view of __data attribute of the workbook")
+
+	// the dot commands aren't JavaScript functions so are added without autocomplete terms
+	js.Help["repl"] = append([]*HelpMsg{}, replCommands...)
 }
 
-// AddExtensions takes an exisitng *otto.Otto (JavaScript VM) and adds os and http objects wrapping some Go native packages
-func (js *JavaScriptVM) AddExtensions() *otto.Otto {
-	errorObject := func(obj *otto.Object, msg string) otto.Value {
-		if obj == nil {
-			obj, _ = js.VM.Object(`({})`)
+// boolOption returns val when it is a boolean, the named property when val is an object, false otherwise
+func boolOption(val otto.Value, name string) bool {
+	if val.IsBoolean() == true {
+		b, _ := val.ToBoolean()
+		return b
+	}
+	if val.IsObject() == true {
+		prop, err := val.Object().Get(name)
+		if err == nil && prop.IsDefined() == true {
+			b, _ := prop.ToBoolean()
+			return b
 		}
-		log.Println(msg)
-		obj.Set("status", "error")
-		obj.Set("error", msg)
-		return obj.Value()
 	}
+	return false
+}
 
-	responseObject := func(data interface{}) otto.Value {
-		src, _ := json.Marshal(data)
-		obj, _ := js.VM.Object(fmt.Sprintf(`(%s)`, src))
-		return obj.Value()
+// formatNumber returns n rounded to decimals places with commas separating the thousands
+func formatNumber(n float64, decimals int) string {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return strconv.FormatFloat(n, 'f', -1, 64)
 	}
+	s := strconv.FormatFloat(n, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, fraction = s[:i], s[i:]
+	}
+	var parts []string
+	for len(whole) > 3 {
+		parts = append([]string{whole[len(whole)-3:]}, parts...)
+		whole = whole[:len(whole)-3]
+	}
+	parts = append([]string{whole}, parts...)
+	if sign == "-" && strings.Trim(strings.Join(parts, "")+fraction, "0.") == "" {
+		// don't show a rounded away negative as "-0"
+		sign = ""
+	}
+	return sign + strings.Join(parts, ",") + fraction
+}
+
+// formatBytes returns n bytes in the largest binary unit (1 KB is 1024 bytes) with one decimal place,
+// counts under 1 KB are whole bytes, e.g. "512 B", "1.5 MB"
+func formatBytes(n float64) string {
+	units := []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+	if math.Abs(n) < 1024 {
+		return fmt.Sprintf("%s B", formatNumber(n, 0))
+	}
+	unit := ""
+	for _, unit = range units {
+		n = n / 1024
+		if math.Abs(n) < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%s %s", formatNumber(n, 1), unit)
+}
+
+// csvNumber matches the cells csv inference turns into numbers, leading zeros (e.g. "007") and
+// bare decimal points (e.g. ".5", "5.") don't match so identifiers and zip codes stay strings
+var csvNumber = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// inferCSVCell converts a CSV cell to a boolean or number when it unambiguously looks like one:
+// "true" and "false" (any case) become booleans, cells matching csvNumber become numbers unless
+// they are integers beyond 2^53 which JavaScript can't hold exactly. Everything else, including
+// cells with surrounding spaces and empty cells, is returned unchanged.
+func inferCSVCell(cell string) interface{} {
+	switch strings.ToLower(cell) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if csvNumber.MatchString(cell) == false {
+		return cell
+	}
+	f, err := strconv.ParseFloat(cell, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return cell
+	}
+	if strings.ContainsAny(cell, ".eE") == false && math.Abs(f) > 1<<53 {
+		return cell
+	}
+	return f
+}
+
+// csvRow returns the cells of a CSV record, inferring their types when infer is true
+func csvRow(cells []string, infer bool) []interface{} {
+	row := make([]interface{}, len(cells))
+	for i, cell := range cells {
+		if infer == true {
+			row[i] = inferCSVCell(cell)
+		} else {
+			row[i] = cell
+		}
+	}
+	return row
+}
+
+// argSpec declares a flag for os.parseArgs, Type is "string" (the default), "number" or "bool"
+type argSpec struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+	Alias   string      `json:"alias"`
+}
+
+// parseArgs parses the flags declared by specs from args returning the flag values by name and the
+// remaining positional arguments. Flags are given as --name value, --name=value or -alias value, a
+// bool flag takes no value unless written --name=false. Flags not given take their default, or
+// "", 0 or false without one. Parsing stops at "--", the arguments after it are positional.
+func parseArgs(specs []argSpec, args []string) (map[string]interface{}, []string, error) {
+	flags := make(map[string]interface{})
+	byName := make(map[string]argSpec)
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return nil, nil, fmt.Errorf("flag missing a name")
+		}
+		switch spec.Type {
+		case "":
+			spec.Type = "string"
+		case "string", "number", "bool":
+		default:
+			return nil, nil, fmt.Errorf("flag %s has unknown type %q", spec.Name, spec.Type)
+		}
+		byName["--"+spec.Name] = spec
+		if spec.Alias != "" {
+			byName["-"+spec.Alias] = spec
+		}
+		switch {
+		case spec.Default != nil:
+			flags[spec.Name] = spec.Default
+		case spec.Type == "number":
+			flags[spec.Name] = 0
+		case spec.Type == "bool":
+			flags[spec.Name] = false
+		default:
+			flags[spec.Name] = ""
+		}
+	}
+	positional := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "-") == false || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, hasValue := arg, "", false
+		if p := strings.Index(arg, "="); p > 0 {
+			name, value, hasValue = arg[0:p], arg[p+1:], true
+		}
+		spec, ok := byName[name]
+		if ok == false {
+			return nil, nil, fmt.Errorf("unknown flag %s", name)
+		}
+		if spec.Type == "bool" {
+			b := true
+			if hasValue == true {
+				var err error
+				if b, err = strconv.ParseBool(value); err != nil {
+					return nil, nil, fmt.Errorf("flag %s expects true or false, got %q", name, value)
+				}
+			}
+			flags[spec.Name] = b
+			continue
+		}
+		if hasValue == false {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag %s is missing a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if spec.Type == "number" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("flag %s expects a number, got %q", name, value)
+			}
+			flags[spec.Name] = f
+			continue
+		}
+		flags[spec.Name] = value
+	}
+	return flags, positional, nil
+}
+
+// restoreEnv replaces the process environment with environ, KEY=value pairs as returned by os.Environ
+func restoreEnv(environ []string) error {
+	os.Clearenv()
+	for _, kv := range environ {
+		// Windows has variables like "=C:=C:\" so the separator is looked for after the first character
+		if kv == "" {
+			continue
+		}
+		p := strings.Index(kv[1:], "=") + 1
+		if p < 1 {
+			continue
+		}
+		if err := os.Setenv(kv[0:p], kv[p+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exitStatus turns the error from running a command into its exit code, errors other than a
+// non-zero exit are passed back
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if ok == false {
+		return 0, err
+	}
+	if status, ok := exitErr.Sys().(interface {
+		ExitStatus() int
+	}); ok == true {
+		return status.ExitStatus(), nil
+	}
+	return 1, nil
+}
+
+// sortKey is a field to sort records by, see sortRecords
+type sortKey struct {
+	Field      string
+	Descending bool
+}
+
+// parseSortKeys turns keys like "dept" and "-salary" into sortKeys, a leading - sorts descending
+func parseSortKeys(keys []string) ([]sortKey, error) {
+	sortKeys := make([]sortKey, len(keys))
+	for i, key := range keys {
+		if strings.HasPrefix(key, "-") == true {
+			sortKeys[i] = sortKey{Field: key[1:], Descending: true}
+		} else {
+			sortKeys[i] = sortKey{Field: key}
+		}
+		if sortKeys[i].Field == "" {
+			return nil, fmt.Errorf("sort key %d is empty", i)
+		}
+	}
+	return sortKeys, nil
+}
+
+// sortRank orders values of different types, missing and null first then booleans, numbers,
+// strings and last anything else (arrays and objects)
+func sortRank(val interface{}) int {
+	switch val.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// compareSortValues returns -1, 0 or 1 comparing two JSON decoded values. Numbers compare
+// numerically, strings by their bytes (so "10" is before "9" and "B" before "a"), false is before
+// true and values of different types by sortRank. Arrays and objects compare by their JSON.
+func compareSortValues(a, b interface{}) int {
+	rankA, rankB := sortRank(a), sortRank(b)
+	switch {
+	case rankA < rankB:
+		return -1
+	case rankA > rankB:
+		return 1
+	}
+	switch x := a.(type) {
+	case bool:
+		if x == b.(bool) {
+			return 0
+		} else if x == false {
+			return -1
+		}
+		return 1
+	case float64:
+		y := b.(float64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case nil:
+		return 0
+	}
+	srcA, _ := json.Marshal(a)
+	srcB, _ := json.Marshal(b)
+	return bytes.Compare(srcA, srcB)
+}
+
+// sortRecords sorts records (JSON decoded objects) in place by the fields of keys in turn, see
+// compareSortValues. The sort is stable so records which compare equal keep their order. Records
+// that aren't objects are treated as having none of the fields.
+func sortRecords(records []interface{}, keys []sortKey) {
+	field := func(record interface{}, name string) interface{} {
+		if obj, ok := record.(map[string]interface{}); ok == true {
+			return obj[name]
+		}
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range keys {
+			c := compareSortValues(field(records[i], key.Field), field(records[j], key.Field))
+			if key.Descending == true {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// parseEnv parses the KEY=value lines of a .env file, blank lines and lines starting with # are
+// ignored, an "export " prefix is allowed. Values may be single quoted (taken literally) or double
+// quoted (supporting \n, \t, \" and \\ escapes), unquoted values end at a " #" comment.
+func parseEnv(src []byte) ([][2]string, error) {
+	var vars [][2]string
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.Index(line, "=")
+		if eq < 1 {
+			return nil, fmt.Errorf("line %d, expected KEY=value", i+1)
+		}
+		key := strings.TrimSpace(line[0:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d, missing closing quote", i+1)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			var buf bytes.Buffer
+			closed := false
+			for j := 1; j < len(value) && closed == false; j++ {
+				switch {
+				case value[j] == '\\' && j+1 < len(value):
+					j++
+					switch value[j] {
+					case 'n':
+						buf.WriteByte('\n')
+					case 't':
+						buf.WriteByte('\t')
+					default:
+						buf.WriteByte(value[j])
+					}
+				case value[j] == '"':
+					closed = true
+				default:
+					buf.WriteByte(value[j])
+				}
+			}
+			if closed == false {
+				return nil, fmt.Errorf("line %d, missing closing quote", i+1)
+			}
+			value = buf.String()
+		default:
+			if j := strings.Index(value, " #"); j > -1 {
+				value = strings.TrimSpace(value[0:j])
+			}
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, nil
+}
+
+// sameFile reports if the files a and b have identical contents, files of different
+// sizes are not read
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	fA, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fA.Close()
+	fB, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fB.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(fA, bufA)
+		nB, errB := io.ReadFull(fB, bufB)
+		if nA != nB || bytes.Equal(bufA[:nA], bufB[:nB]) == false {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// hashFile returns the hex digest of fname's contents using algo, one of "md5", "sha1" or "sha256"
+func hashFile(fname, algo string) (string, error) {
+	var h hash.Hash
+	switch strings.ToLower(algo) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256", "":
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+	fp, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mktree creates the directories and files described by spec under baseDir. Keys of spec are
+// names, string values become file contents and nested objects become subdirectories.
+func mktree(baseDir string, spec map[string]interface{}) error {
+	if err := os.MkdirAll(baseDir, 0775); err != nil {
+		return err
+	}
+	for name, val := range spec {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("%q is not a valid file or directory name", name)
+		}
+		p := filepath.Join(baseDir, name)
+		switch v := val.(type) {
+		case string:
+			if err := ioutil.WriteFile(p, []byte(v), 0664); err != nil {
+				return err
+			}
+		case map[string]interface{}:
+			if err := mktree(p, v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s should be a string (file contents) or an object (directory), not %T", p, val)
+		}
+	}
+	return nil
+}
+
+// tailLines returns the last n lines of fname reading backwards from the end of the file in blocks
+// so only the end of a large file is read
+func tailLines(fname string, n int) ([]string, error) {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || info.Size() == 0 {
+		return []string{}, nil
+	}
+
+	var buf []byte
+	offset := info.Size()
+	for offset > 0 {
+		blockSize := int64(4096)
+		if offset < blockSize {
+			blockSize = offset
+		}
+		offset -= blockSize
+		block := make([]byte, blockSize)
+		if _, err := fp.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(block, buf...)
+		// more than n newlines means the last n lines are complete
+		if bytes.Count(buf, []byte("\n")) > n {
+			break
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, nil
+}
+
+// renamePath moves oldpath to newpath. An existing newpath is refused unless overwrite is true,
+// it is then removed first so the result is the same on every platform.
+func renamePath(oldpath, newpath string, overwrite bool) error {
+	oldInfo, err := os.Lstat(oldpath)
+	if err != nil {
+		return err
+	}
+	if newInfo, err := os.Lstat(newpath); err == nil {
+		if os.SameFile(oldInfo, newInfo) == true {
+			// e.g. changing the case of a name on a case insensitive file system
+			return os.Rename(oldpath, newpath)
+		}
+		if overwrite == false {
+			return fmt.Errorf("%s exists", newpath)
+		}
+		if err := os.RemoveAll(newpath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// copyFile copies src to dst preserving the file mode of src, it refuses to replace
+// an existing dst unless overwrite is true and always refuses when dst is src
+func copyFile(src, dst string, overwrite, preserveTimes bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() == true {
+		return fmt.Errorf("%s is a directory", src)
+	}
+	if dstInfo, err := os.Stat(dst); err == nil {
+		// opening dst would truncate src before it is read
+		if os.SameFile(info, dstInfo) == true {
+			return fmt.Errorf("%s and %s are the same file", src, dst)
+		}
+		if overwrite == false {
+			return fmt.Errorf("%s exists", dst)
+		}
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if preserveTimes == true {
+		if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(dst, info.Mode())
+}
+
+// copyDir copies the directory tree src to dst, creating dst and its subdirectories with the modes
+// of src's. Files are copied with copyFile and the copied file paths are returned. Symbolic links to
+// files are copied as the files they point at, links to directories are skipped.
+func copyDir(src, dst string, overwrite, preserveTimes bool) ([]string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() == false {
+		return nil, fmt.Errorf("%s is not a directory", src)
+	}
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	// copying into src would keep finding the copies
+	if rel, err := filepath.Rel(absSrc, absDst); err == nil && (rel == "." || strings.HasPrefix(rel, "..") == false) {
+		return nil, fmt.Errorf("%s is inside %s", dst, src)
+	}
+	copied := []string{}
+	dirs := map[string]os.FileInfo{}
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			if linked, err := os.Stat(p); err != nil || linked.IsDir() == true {
+				return nil
+			}
+		}
+		if info.IsDir() == true {
+			dirs[target] = info
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if err := copyFile(p, target, overwrite, preserveTimes); err != nil {
+			return err
+		}
+		copied = append(copied, target)
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+	// modes and times are set once the directories are filled, writing a file changes its directory's time
+	for target, info := range dirs {
+		if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+			return copied, err
+		}
+		if preserveTimes == true {
+			if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+				return copied, err
+			}
+		}
+	}
+	return copied, nil
+}
+
+// walkPaths walks root like filepath.Walk calling visit for each entry. An entry that can't be read is
+// passed to onError and the walk carries on with the rest unless onError returns an error, root
+// itself being unreadable ends the walk with its error.
+func walkPaths(root string, visit func(p string, info os.FileInfo) error, onError func(p string, err error) error) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && info == nil {
+				return err
+			}
+			return onError(p, err)
+		}
+		return visit(p, info)
+	})
+}
+
+// orderedObject is a JSON object keeping its keys in the order they were added
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Set adds (or replaces) key with val
+func (o *orderedObject) Set(key string, val interface{}) {
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[key]; ok == false {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = val
+}
+
+// MarshalJSON renders the object with its keys in order
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// keySet holds the record keys already in use
+type keySet map[string]bool
+
+// add returns name, or name with the first suffix (e.g. name_2, name_3) not already in use, and
+// marks it as used
+func (used keySet) add(name string) string {
+	key := name
+	for n := 2; used[key] == true; n++ {
+		key = fmt.Sprintf("%s_%d", name, n)
+	}
+	used[key] = true
+	return key
+}
+
+// recordKeys turns a header row into record keys, an empty header becomes column_N (N counting
+// from 1) and a repeated header gets a suffix making it unique (e.g. name, name_2)
+func recordKeys(header []string) []string {
+	keys := make([]string, len(header))
+	used := keySet{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		keys[i] = used.add(name)
+	}
+	return keys
+}
+
+// DumpWorkbookJSON reads an xlsx file and renders it as pretty printed JSON, an object with the
+// sheet names (in workbook order) as keys pointing at an array of records. Each record is keyed by
+// the sheet's first row, see recordKeys for how empty and repeated headers are named.
+func DumpWorkbookJSON(fname string) (string, error) {
+	xlWorkbook, err := xlsx.OpenFile(fname)
+	if err != nil {
+		return "", err
+	}
+	sheets := new(orderedObject)
+	for _, sheet := range xlWorkbook.Sheets {
+		records := []*orderedObject{}
+		var rows [][]string
+		width := 0
+		for _, row := range sheet.Rows {
+			var cells []string
+			for _, cell := range row.Cells {
+				s, _ := cell.String()
+				cells = append(cells, s)
+			}
+			rows = append(rows, cells)
+			if len(cells) > width {
+				width = len(cells)
+			}
+		}
+		if len(rows) == 0 {
+			sheets.Set(sheet.Name, records)
+			continue
+		}
+		header := rows[0]
+		// columns past the header are keyed too, padding keeps their keys unique
+		keys := recordKeys(append(header, make([]string, width-len(header))...))
+		for _, cells := range rows[1:] {
+			record := new(orderedObject)
+			for j := 0; j < len(header) || j < len(cells); j++ {
+				key := keys[j]
+				val := ""
+				if j < len(cells) {
+					val = cells[j]
+				}
+				record.Set(key, val)
+			}
+			records = append(records, record)
+		}
+		sheets.Set(sheet.Name, records)
+	}
+	src, err := json.MarshalIndent(sheets, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(src), nil
+}
+
+// workbookMarkup renders the sheets of an xlsx file as JavaScript object source, properties
+// are sheet names (in workbook order) pointing at 2d-arrays of strings
+func workbookMarkup(xlWorkbook *xlsx.File) string {
+	return workbookMarkupWith(xlWorkbook, func(cell *xlsx.Cell) string {
+		s, _ := cell.String()
+		return fmt.Sprintf("%q", s)
+	})
+}
+
+// formulaWorkbookMarkup renders the sheets like workbookMarkup but each cell is an object, {value}, with
+// a formula property (e.g. "=SUM(A2:A3)") for the cells that have one
+func formulaWorkbookMarkup(xlWorkbook *xlsx.File) string {
+	return workbookMarkupWith(xlWorkbook, func(cell *xlsx.Cell) string {
+		s, _ := cell.String()
+		if formula := cell.Formula(); formula != "" {
+			return fmt.Sprintf("{value:%q,formula:%q}", s, "="+formula)
+		}
+		return fmt.Sprintf("{value:%q}", s)
+	})
+}
+
+// typedWorkbookMarkup renders the sheets of an xlsx file like workbookMarkup but keeps the
+// cell types, numbers and booleans as is and date formatted numbers as Date objects
+func typedWorkbookMarkup(xlWorkbook *xlsx.File) string {
+	return workbookMarkupWith(xlWorkbook, func(cell *xlsx.Cell) string {
+		return typedCellMarkup(cell, xlWorkbook.Date1904)
+	})
+}
+
+// typedCellMarkup renders a cell as JavaScript source keeping its type, booleans as true or false,
+// numbers as numbers, date formatted numbers as Date objects and anything else as a string
+func typedCellMarkup(cell *xlsx.Cell, date1904 bool) string {
+	switch cell.Type() {
+	case xlsx.CellTypeBool:
+		return fmt.Sprintf("%t", cell.Bool())
+	case xlsx.CellTypeNumeric:
+		f, err := cell.Float()
+		if err != nil {
+			break
+		}
+		if isDateFormat(cell.GetNumberFormat()) {
+			t := xlsx.TimeFromExcelTime(f, date1904)
+			return fmt.Sprintf("new Date(%d)", t.UnixNano()/int64(time.Millisecond))
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	s, _ := cell.String()
+	return fmt.Sprintf("%q", s)
+}
+
+// workbookDiffMarkup renders the differences between two workbooks as JavaScript source for an array
+// of {sheet, row, col, a, b} objects, row and col count from zero and a and b are the cells' typed
+// values (see typedCellMarkup), a missing cell is taken to be an empty string. A sheet in only one
+// workbook is a single entry with row and col null, a and b are true for the workbook with the sheet.
+func workbookDiffMarkup(wbA, wbB *xlsx.File) string {
+	var diffs []string
+	cellsOf := func(sheet *xlsx.Sheet, date1904 bool) [][]string {
+		var rows [][]string
+		for _, row := range sheet.Rows {
+			var cells []string
+			for _, cell := range row.Cells {
+				cells = append(cells, typedCellMarkup(cell, date1904))
+			}
+			rows = append(rows, cells)
+		}
+		return rows
+	}
+	cellAt := func(rows [][]string, i, j int) string {
+		if i < len(rows) && j < len(rows[i]) {
+			return rows[i][j]
+		}
+		return `""`
+	}
+	for _, sheetA := range wbA.Sheets {
+		sheetB, ok := wbB.Sheet[sheetA.Name]
+		if ok == false {
+			diffs = append(diffs, fmt.Sprintf(`{"sheet":%q,"row":null,"col":null,"a":true,"b":false}`, sheetA.Name))
+			continue
+		}
+		rowsA, rowsB := cellsOf(sheetA, wbA.Date1904), cellsOf(sheetB, wbB.Date1904)
+		for i := 0; i < len(rowsA) || i < len(rowsB); i++ {
+			width := 0
+			if i < len(rowsA) {
+				width = len(rowsA[i])
+			}
+			if i < len(rowsB) && len(rowsB[i]) > width {
+				width = len(rowsB[i])
+			}
+			for j := 0; j < width; j++ {
+				a, b := cellAt(rowsA, i, j), cellAt(rowsB, i, j)
+				if a != b {
+					diffs = append(diffs, fmt.Sprintf(`{"sheet":%q,"row":%d,"col":%d,"a":%s,"b":%s}`, sheetA.Name, i, j, a, b))
+				}
+			}
+		}
+	}
+	for _, sheetB := range wbB.Sheets {
+		if _, ok := wbA.Sheet[sheetB.Name]; ok == false {
+			diffs = append(diffs, fmt.Sprintf(`{"sheet":%q,"row":null,"col":null,"a":false,"b":true}`, sheetB.Name))
+		}
+	}
+	return "[" + strings.Join(diffs, ",") + "]"
+}
+
+// headerCheck is the result of comparing a sheet's header to the expected columns
+type headerCheck struct {
+	Valid   bool     `json:"valid"`
+	Missing []string `json:"missing"`
+	Extra   []string `json:"extra"`
+}
+
+// compareHeader compares header to the expected columns, missing are the expected columns not in
+// header and extra the header columns not expected. Empty header cells are ignored. When ordered is
+// true the header is only valid if its columns are in the expected order too.
+func compareHeader(header, expected []string, ordered bool) headerCheck {
+	check := headerCheck{Missing: []string{}, Extra: []string{}}
+	var columns []string
+	for _, name := range header {
+		if name != "" {
+			columns = append(columns, name)
+		}
+	}
+	inHeader := make(map[string]bool)
+	for _, name := range columns {
+		inHeader[name] = true
+	}
+	isExpected := make(map[string]bool)
+	for _, name := range expected {
+		isExpected[name] = true
+		if inHeader[name] == false {
+			check.Missing = append(check.Missing, name)
+		}
+	}
+	for _, name := range columns {
+		if isExpected[name] == false {
+			check.Extra = append(check.Extra, name)
+		}
+	}
+	check.Valid = len(check.Missing) == 0 && len(check.Extra) == 0
+	if check.Valid == true && ordered == true {
+		check.Valid = strings.Join(columns, "\x00") == strings.Join(expected, "\x00")
+	}
+	return check
+}
+
+// sheetCell is a cell read by readXLSXSheet, Text is its value as a string and Markup its typed
+// value as JavaScript source (see typedCellMarkup)
+type sheetCell struct {
+	Text   string
+	Markup string
+}
+
+// emptySheetCell fills the gaps between the cells and rows of a sheet
+var emptySheetCell = sheetCell{Text: "", Markup: `""`}
+
+// xlsxSheetXML is the cell data of a worksheet part
+type xlsxSheetXML struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string   `xml:"r,attr"`
+			T      string   `xml:"t,attr"`
+			S      int      `xml:"s,attr"`
+			V      string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxText is a string item, either plain text or runs of rich text
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String returns the text of the item
+func (text xlsxText) String() string {
+	s := text.T
+	for _, run := range text.Runs {
+		s += run.T
+	}
+	return s
+}
+
+// xlsxStyleFormats holds the number formats of a styles part needed to spot dates
+type xlsxStyleFormats struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	Xfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// builtInDateFormats are the format codes of the built in number formats showing dates or times
+var builtInDateFormats = map[int]string{
+	14: "mm-dd-yy", 15: "d-mmm-yy", 16: "d-mmm", 17: "mmm-yy", 18: "h:mm AM/PM", 19: "h:mm:ss AM/PM",
+	20: "h:mm", 21: "h:mm:ss", 22: "m/d/yy h:mm", 45: "mm:ss", 46: "[h]:mm:ss", 47: "mmss.0",
+}
+
+// cellColumn returns the column, counting from zero, of a cell reference such as "AB12"
+func cellColumn(ref string) int {
+	col := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// readXLSXSheet reads the cells of one sheet of the xlsx file fname, decoding only the parts it
+// needs (the workbook, shared strings, styles and that worksheet) rather than the whole workbook.
+// Missing cells and rows are filled with empty cells.
+func readXLSXSheet(fname, sheetName string) ([][]sheetCell, error) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	if _, ok := parts["xl/workbook.xml"]; ok == false {
+		return nil, fmt.Errorf("missing xl/workbook.xml, not an xlsx file")
+	}
+	workbook := struct {
+		xlsxWorkbookSheets
+		WorkbookPr struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+	}{}
+	if err := xlsxPart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	targets, err := xlsxRelTargets(parts, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheetPart := ""
+	for _, sheet := range workbook.Sheets {
+		if sheet.Name == sheetName {
+			sheetPart = targets[sheet.RID]
+		}
+	}
+	if sheetPart == "" {
+		return nil, fmt.Errorf("no sheet named %q", sheetName)
+	}
+	sharedStrings := struct {
+		Items []xlsxText `xml:"si"`
+	}{}
+	if err := xlsxPart(parts, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+		return nil, err
+	}
+	styles := xlsxStyleFormats{}
+	if err := xlsxPart(parts, "xl/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	formats := make(map[int]string)
+	for id, code := range builtInDateFormats {
+		formats[id] = code
+	}
+	for _, numFmt := range styles.NumFmts {
+		formats[numFmt.ID] = numFmt.Code
+	}
+	sheetXML := xlsxSheetXML{}
+	if err := xlsxPart(parts, sheetPart, &sheetXML); err != nil {
+		return nil, err
+	}
+
+	var rows [][]sheetCell
+	for _, row := range sheetXML.Rows {
+		for row.R > len(rows)+1 {
+			rows = append(rows, []sheetCell{})
+		}
+		var cells []sheetCell
+		for _, c := range row.Cells {
+			col := len(cells)
+			if c.R != "" {
+				col = cellColumn(c.R)
+			}
+			for col > len(cells) {
+				cells = append(cells, emptySheetCell)
+			}
+			cell := emptySheetCell
+			switch c.T {
+			case "s":
+				if i, err := strconv.Atoi(c.V); err == nil && i >= 0 && i < len(sharedStrings.Items) {
+					cell.Text = sharedStrings.Items[i].String()
+				}
+				cell.Markup = fmt.Sprintf("%q", cell.Text)
+			case "inlineStr":
+				cell.Text = c.Inline.String()
+				cell.Markup = fmt.Sprintf("%q", cell.Text)
+			case "b":
+				cell.Text = "false"
+				if c.V == "1" {
+					cell.Text = "true"
+				}
+				cell.Markup = cell.Text
+			case "str", "e":
+				cell.Text = c.V
+				cell.Markup = fmt.Sprintf("%q", cell.Text)
+			default:
+				cell.Text = c.V
+				cell.Markup = fmt.Sprintf("%q", cell.Text)
+				f, err := strconv.ParseFloat(c.V, 64)
+				if err != nil {
+					break
+				}
+				cell.Markup = strconv.FormatFloat(f, 'g', -1, 64)
+				if c.S >= 0 && c.S < len(styles.Xfs) && isDateFormat(formats[styles.Xfs[c.S].NumFmtID]) {
+					t := xlsx.TimeFromExcelTime(f, workbook.WorkbookPr.Date1904)
+					cell.Markup = fmt.Sprintf("new Date(%d)", t.UnixNano()/int64(time.Millisecond))
+				}
+			}
+			if col < len(cells) {
+				cells[col] = cell
+			} else {
+				cells = append(cells, cell)
+			}
+		}
+		if row.R > 0 && row.R <= len(rows) {
+			rows[row.R-1] = cells
+		} else {
+			rows = append(rows, cells)
+		}
+	}
+	return rows, nil
+}
+
+// recordsMarkup renders the rows of a sheet as JavaScript source for an array of objects keyed by
+// the first row (see recordKeys) with typed values. Rows with only empty cells are skipped and cells
+// past the header are keyed column_N, with a suffix if a header already has that name.
+func recordsMarkup(rows [][]sheetCell) string {
+	var (
+		keys    []string
+		records []string
+	)
+	used := keySet{}
+	for i, row := range rows {
+		if i == 0 {
+			var header []string
+			for _, cell := range row {
+				header = append(header, cell.Text)
+			}
+			keys = recordKeys(header)
+			for _, key := range keys {
+				used[key] = true
+			}
+			continue
+		}
+		var fields []string
+		empty := true
+		for j, cell := range row {
+			if cell.Text != "" {
+				empty = false
+			}
+			for j >= len(keys) {
+				keys = append(keys, used.add(fmt.Sprintf("column_%d", len(keys)+1)))
+			}
+			fields = append(fields, fmt.Sprintf("%q:%s", keys[j], cell.Markup))
+		}
+		if empty == false {
+			records = append(records, "{"+strings.Join(fields, ",")+"}")
+		}
+	}
+	return "[" + strings.Join(records, ",") + "]"
+}
+
+// fillMergedCells copies the top left cell of each merged range (HMerge and VMerge give the
+// extra columns and rows it covers) into the other cells of the range, adding rows and cells as needed
+func fillMergedCells(xlWorkbook *xlsx.File) {
+	for _, sheet := range xlWorkbook.Sheets {
+		for i := 0; i < len(sheet.Rows); i++ {
+			for j, cell := range sheet.Rows[i].Cells {
+				if cell.HMerge == 0 && cell.VMerge == 0 {
+					continue
+				}
+				for r := i; r <= i+cell.VMerge; r++ {
+					for len(sheet.Rows) <= r {
+						sheet.AddRow()
+					}
+					row := sheet.Rows[r]
+					for c := j; c <= j+cell.HMerge; c++ {
+						if r == i && c == j {
+							continue
+						}
+						for len(row.Cells) <= c {
+							row.AddCell()
+						}
+						covered := row.Cells[c]
+						*covered = *cell
+						covered.Row = row
+						covered.HMerge, covered.VMerge = 0, 0
+					}
+				}
+			}
+		}
+	}
+}
+
+// isDateFormat reports if an Excel number format (e.g. "mm-dd-yy") displays a date, quoted
+// text and bracketed sections (colors, locales) are ignored
+func isDateFormat(format string) bool {
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(format) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case inBracket:
+		case r == 'd' || r == 'y':
+			return true
+		}
+	}
+	return false
+}
+
+// workbookMarkupWith renders the sheets of an xlsx file as JavaScript object source using
+// cellMarkup to render each cell
+func workbookMarkupWith(xlWorkbook *xlsx.File, cellMarkup func(*xlsx.Cell) string) string {
+	var markup []string
+
+	// Start Workbook object markup
+	markup = append(markup, fmt.Sprintf("{"))
+	for i, sheet := range xlWorkbook.Sheets {
+		if i > 0 {
+			markup = append(markup, fmt.Sprintf(","))
+		}
+		// Start a sheet with sheetNameString
+		markup = append(markup, fmt.Sprintf("%q:[", sheet.Name))
+		for j, row := range sheet.Rows {
+			if j > 0 {
+				markup = append(markup, fmt.Sprintf(","))
+			}
+			// Start Row of cells
+			markup = append(markup, fmt.Sprintf("["))
+			for k, cell := range row.Cells {
+				if k > 0 {
+					markup = append(markup, fmt.Sprintf(","))
+				}
+				markup = append(markup, cellMarkup(cell))
+			}
+			// Close Row of cells
+			markup = append(markup, fmt.Sprintf("]"))
+		}
+		// Close a sheet
+		markup = append(markup, fmt.Sprintf("]"))
+	}
+	// End Workbook object markup
+	markup = append(markup, fmt.Sprintf("}"))
+	return strings.Join(markup, "")
+}
+
+// parseA1 converts a cell reference like "B3" into a zero based row and column
+func parseA1(ref string) (int, int, error) {
+	ref = strings.ToUpper(strings.TrimSpace(ref))
+	i := 0
+	col := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A') + 1
+	}
+	if i == 0 || i == len(ref) {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	row, err := strconv.Atoi(ref[i:])
+	if err != nil || row < 1 {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return row - 1, col - 1, nil
+}
+
+// parseA1Range converts a range like "A1:C10" (or a single cell "B2") into zero based
+// first and last rows and columns
+func parseA1Range(a1Range string) (int, int, int, int, error) {
+	refs := strings.SplitN(a1Range, ":", 2)
+	row1, col1, err := parseA1(refs[0])
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid range %q, %s", a1Range, err)
+	}
+	row2, col2 := row1, col1
+	if len(refs) == 2 {
+		row2, col2, err = parseA1(refs[1])
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid range %q, %s", a1Range, err)
+		}
+	}
+	if row2 < row1 {
+		row1, row2 = row2, row1
+	}
+	if col2 < col1 {
+		col1, col2 = col2, col1
+	}
+	return row1, col1, row2, col2, nil
+}
+
+// sheetRange returns the cell values of sheet between the given zero based rows and columns,
+// blank or missing cells are empty strings
+func sheetRange(sheet *xlsx.Sheet, row1, col1, row2, col2 int) [][]string {
+	var table [][]string
+	for i := row1; i <= row2; i++ {
+		tr := make([]string, col2-col1+1)
+		if i < len(sheet.Rows) && sheet.Rows[i] != nil {
+			cells := sheet.Rows[i].Cells
+			for j := col1; j <= col2 && j < len(cells); j++ {
+				if cells[j] != nil {
+					tr[j-col1], _ = cells[j].String()
+				}
+			}
+		}
+		table = append(table, tr)
+	}
+	return table
+}
+
+// sheetTable checks a sheet exported from JavaScript is an array of arrays of scalars (strings, numbers,
+// booleans or null) returning the cells as strings, rows and columns in errors are counted from one
+func sheetTable(sheetName string, table interface{}) ([][]string, error) {
+	rows := reflect.ValueOf(table)
+	if table == nil || (rows.Kind() != reflect.Slice && rows.Kind() != reflect.Array) {
+		return nil, fmt.Errorf("sheet %q is not an array of rows", sheetName)
+	}
+	var result [][]string
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for row.Kind() == reflect.Interface && row.IsNil() == false {
+			row = row.Elem()
+		}
+		if row.Kind() != reflect.Slice && row.Kind() != reflect.Array {
+			return nil, fmt.Errorf("sheet %q row %d is not an array of cells", sheetName, i+1)
+		}
+		tr := make([]string, row.Len())
+		for j := 0; j < row.Len(); j++ {
+			cell := row.Index(j)
+			for cell.Kind() == reflect.Interface && cell.IsNil() == false {
+				cell = cell.Elem()
+			}
+			switch cell.Kind() {
+			case reflect.Interface:
+				// null
+			case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+				tr[j] = fmt.Sprintf("%v", cell.Interface())
+			default:
+				return nil, fmt.Errorf("sheet %q row %d column %d is not a string, number or boolean", sheetName, i+1, j+1)
+			}
+		}
+		result = append(result, tr)
+	}
+	return result, nil
+}
+
+// workbookTables checks the data exported from a JavaScript workbook object, returning the
+// tables of cells by sheet name. Sheets are checked in the order of keys (see sheetOrder) so the
+// sheet an error names doesn't change from run to run.
+func workbookTables(data interface{}, keys []string) (map[string][][]string, error) {
+	sheets, ok := data.(map[string]interface{})
+	if ok == false {
+		return nil, fmt.Errorf("workbook is not an object of sheets")
+	}
+	tables := make(map[string][][]string)
+	for sheetName := range sheets {
+		tables[sheetName] = nil
+	}
+	for _, sheetName := range sheetOrder(tables, keys) {
+		rows, err := sheetTable(sheetName, sheets[sheetName])
+		if err != nil {
+			return nil, err
+		}
+		tables[sheetName] = rows
+	}
+	return tables, nil
+}
+
+// raggedRow returns an error naming the first row of table with a different number of cells to
+// the first row, rows and columns in errors are counted from one
+func raggedRow(sheetName string, table [][]string) error {
+	for i, row := range table {
+		if len(row) != len(table[0]) {
+			return fmt.Errorf("sheet %q row %d has %d cells, row 1 has %d", sheetName, i+1, len(row), len(table[0]))
+		}
+	}
+	return nil
+}
+
+// sheetOrder returns the names of tables in the order given by keys (e.g. the property order of the
+// JavaScript object), any names not in keys follow sorted by name
+func sheetOrder(tables map[string][][]string, keys []string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if _, ok := tables[key]; ok == true && seen[key] == false {
+			names = append(names, key)
+			seen[key] = true
+		}
+	}
+	var rest []string
+	for name := range tables {
+		if seen[name] == false {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// xlsxWriteOptions are the sheet settings xlsx.write accepts as an optional third parameter
+type xlsxWriteOptions struct {
+	// ActiveSheet is the name of the sheet selected when the workbook is opened
+	ActiveSheet string `json:"activeSheet"`
+	// ColumnWidths maps a sheet name to the widths of its columns, starting with column A
+	ColumnWidths map[string][]float64 `json:"columnWidths"`
+}
+
+// activeTab returns the position of ActiveSheet in file, -1 when no active sheet is set
+func (options xlsxWriteOptions) activeTab(file *xlsx.File) int {
+	for i, sheet := range file.Sheets {
+		if options.ActiveSheet != "" && sheet.Name == options.ActiveSheet {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply sets the active sheet and column widths on file, the workbook's activeTab can only be set
+// once file is saved (see setActiveTab)
+func (options xlsxWriteOptions) apply(file *xlsx.File) error {
+	if options.ActiveSheet != "" {
+		if _, ok := file.Sheet[options.ActiveSheet]; ok == false {
+			return fmt.Errorf("active sheet %q not found", options.ActiveSheet)
+		}
+		for _, sheet := range file.Sheets {
+			sheet.Selected = (sheet.Name == options.ActiveSheet)
+		}
+	}
+	for sheetName, widths := range options.ColumnWidths {
+		sheet, ok := file.Sheet[sheetName]
+		if ok == false {
+			return fmt.Errorf("can't set column widths, sheet %q not found", sheetName)
+		}
+		for col, width := range widths {
+			if width <= 0 {
+				continue
+			}
+			if err := sheet.SetColWidth(col, col, width); err != nil {
+				return fmt.Errorf("can't set width of column %d in %q, %s", col, sheetName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// xlsxRelationships is a relationships (.rels) part of an xlsx package
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID         string `xml:"Id,attr"`
+		Target     string `xml:"Target,attr"`
+		TargetMode string `xml:"TargetMode,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxWorkbookSheets is the list of sheets found in xl/workbook.xml
+type xlsxWorkbookSheets struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxWorksheetLinks is the hyperlink list of a worksheet
+type xlsxWorksheetLinks struct {
+	Hyperlinks []struct {
+		Ref      string `xml:"ref,attr"`
+		RID      string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		Location string `xml:"location,attr"`
+	} `xml:"hyperlinks>hyperlink"`
+}
+
+// xlsxComments is a comments part of an xlsx package
+type xlsxComments struct {
+	Comments []struct {
+		Ref  string `xml:"ref,attr"`
+		Text struct {
+			T    string `xml:"t"`
+			Runs []struct {
+				T string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"text"`
+	} `xml:"commentList>comment"`
+}
+
+// xlsxPart decodes the XML part name from the xlsx package into v, a missing part is not an error
+func xlsxPart(parts map[string]*zip.File, name string, v interface{}) error {
+	f, ok := parts[name]
+	if ok == false {
+		return nil
+	}
+	rd, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return xml.NewDecoder(rd).Decode(v)
+}
+
+// xlsxRelTargets maps relationship ids to part names for the relationships of part name
+func xlsxRelTargets(parts map[string]*zip.File, name string) (map[string]string, error) {
+	dir, base := path.Split(name)
+	rels := xlsxRelationships{}
+	if err := xlsxPart(parts, path.Join(dir, "_rels", base+".rels"), &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if rel.TargetMode == "External" {
+			targets[rel.ID] = rel.Target
+		} else if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join(dir, rel.Target)
+		}
+	}
+	return targets, nil
+}
+
+// workbookView matches the start of the workbookView element of xl/workbook.xml and activeTabAttr
+// its activeTab attribute
+var (
+	workbookView  = regexp.MustCompile(`<workbookView\b`)
+	activeTabAttr = regexp.MustCompile(`\sactiveTab="[0-9]*"`)
+)
+
+// setActiveTab rewrites the xlsx file fname so the sheet at index is the one shown when it is opened,
+// tabSelected on the sheet only highlights its tab
+func setActiveTab(fname string, index int) error {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, f := range zr.File {
+		rd, err := f.Open()
+		if err != nil {
+			return err
+		}
+		src, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return err
+		}
+		if f.Name == "xl/workbook.xml" {
+			attr := fmt.Sprintf(` activeTab="%d"`, index)
+			loc := workbookView.FindIndex(src)
+			switch {
+			case loc == nil:
+				src = bytes.Replace(src, []byte("<sheets>"), []byte(`<bookViews><workbookView`+attr+`/></bookViews><sheets>`), 1)
+			default:
+				end := loc[1] + bytes.IndexByte(src[loc[1]:], '>')
+				view := new(bytes.Buffer)
+				view.Write(src[0:loc[1]])
+				view.WriteString(attr)
+				view.Write(activeTabAttr.ReplaceAll(src[loc[1]:end], nil))
+				view.Write(src[end:])
+				src = view.Bytes()
+			}
+		}
+		fp, err := w.CreateHeader(&zip.FileHeader{Name: f.Name, Method: f.Method, Modified: f.Modified})
+		if err != nil {
+			return err
+		}
+		if _, err := fp.Write(src); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, buf.Bytes(), 0664)
+}
+
+// readXLSXSheetNames returns the sheet names of an xlsx file in workbook order reading only xl/workbook.xml
+func readXLSXSheetNames(fname string) ([]string, error) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	if _, ok := parts["xl/workbook.xml"]; ok == false {
+		return nil, fmt.Errorf("missing xl/workbook.xml, not an xlsx file")
+	}
+	workbook := xlsxWorkbookSheets{}
+	if err := xlsxPart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, sheet := range workbook.Sheets {
+		names = append(names, sheet.Name)
+	}
+	return names, nil
+}
+
+// readXLSXAnnotations returns the cell comments and hyperlink targets of an xlsx file, each
+// keyed by sheet name then A1 reference. Links to a location within the workbook start with "#".
+func readXLSXAnnotations(fname string) (map[string]map[string]string, map[string]map[string]string, error) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+
+	workbook := xlsxWorkbookSheets{}
+	if err := xlsxPart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, nil, err
+	}
+	sheetParts, err := xlsxRelTargets(parts, "xl/workbook.xml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	comments := make(map[string]map[string]string)
+	links := make(map[string]map[string]string)
+	for _, sheet := range workbook.Sheets {
+		sheetPart, ok := sheetParts[sheet.RID]
+		if ok == false {
+			continue
+		}
+		targets, err := xlsxRelTargets(parts, sheetPart)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Hyperlinks are listed in the sheet, external targets are in the sheet's relationships
+		sheetLinks := xlsxWorksheetLinks{}
+		if err := xlsxPart(parts, sheetPart, &sheetLinks); err != nil {
+			return nil, nil, err
+		}
+		links[sheet.Name] = make(map[string]string)
+		for _, link := range sheetLinks.Hyperlinks {
+			if target, ok := targets[link.RID]; ok == true {
+				links[sheet.Name][link.Ref] = target
+			} else if link.Location != "" {
+				links[sheet.Name][link.Ref] = "#" + link.Location
+			}
+		}
+
+		// Comments live in their own part related to the sheet
+		comments[sheet.Name] = make(map[string]string)
+		for _, target := range targets {
+			if strings.HasPrefix(path.Base(target), "comments") == false || path.Ext(target) != ".xml" {
+				continue
+			}
+			sheetComments := xlsxComments{}
+			if err := xlsxPart(parts, target, &sheetComments); err != nil {
+				return nil, nil, err
+			}
+			for _, comment := range sheetComments.Comments {
+				text := comment.Text.T
+				for _, run := range comment.Text.Runs {
+					text += run.T
+				}
+				comments[sheet.Name][comment.Ref] = text
+			}
+		}
+	}
+	return comments, links, nil
+}
+
+// AddExtensions takes an exisitng *otto.Otto (JavaScript VM) and adds os and http objects wrapping some Go native packages.
+// The os, http, xlsx and ods objects are installed on their first use unless EagerExtensions is true (see LoadExtensions).
+func (js *JavaScriptVM) AddExtensions() *otto.Otto {
+	js.extensions = true
+	errorObject, responseObject := js.errorObject, js.responseObject
+
+	// console writes to js.Stdout, or js.Stderr for warn, error and trace as otto's own console
+	// does, so embedders can capture a script's output
+	consoleObj, _ := js.VM.Object(`console = {}`)
+	consoleWriter := func(w func() io.Writer) func(otto.FunctionCall) otto.Value {
+		return func(call otto.FunctionCall) otto.Value {
+			var s []string
+			for _, arg := range call.ArgumentList {
+				s = append(s, arg.String())
+			}
+			fmt.Fprintln(w(), strings.Join(s, " "))
+			return otto.UndefinedValue()
+		}
+	}
+	for _, name := range []string{"log", "info", "debug"} {
+		consoleObj.Set(name, consoleWriter(js.stdout))
+	}
+	for _, name := range []string{"warn", "error", "trace"} {
+		consoleObj.Set(name, consoleWriter(js.stderr))
+	}
+
+	// os, http, xlsx and ods are installed on first use unless EagerExtensions is set
+	js.lazyGroup([]string{"os"}, js.addOSObject)
+	js.lazyGroup([]string{"http"}, js.addHTTPObject)
+	js.lazyGroup([]string{"xlsx", "Workbook"}, js.addXLSXObject)
+	js.lazyGroup([]string{"ods"}, js.addODSObject)
+	if js.EagerExtensions == true {
+		js.LoadExtensions()
+	}
+
+	stringsObj, _ := js.VM.Object(`strings = {}`)
+
+	// strings.shellQuote(s) returns s single quoted for use as one word in a POSIX shell command
+	stringsObj.Set("shellQuote", func(call otto.FunctionCall) otto.Value {
+		src := call.Argument(0).String()
+		result, _ := js.VM.ToValue("'" + strings.Replace(src, "'", `'"'"'`, -1) + "'")
+		return result
+	})
+
+	// strings.urlEncode(s) returns s escaped for use in a URL query
+	stringsObj.Set("urlEncode", func(call otto.FunctionCall) otto.Value {
+		result, _ := js.VM.ToValue(url.QueryEscape(call.Argument(0).String()))
+		return result
+	})
+
+	// strings.urlDecode(s) returns s with URL query escapes decoded or an error object
+	stringsObj.Set("urlDecode", func(call otto.FunctionCall) otto.Value {
+		src := call.Argument(0).String()
+		s, err := url.QueryUnescape(src)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s strings.urlDecode(%q), %s", call.CallerLocation(), src, err))
+		}
+		result, _ := js.VM.ToValue(s)
+		return result
+	})
+
+	// strings.isValidUTF8(s) returns true if s is valid UTF-8, e.g. text read with os.readFile
+	stringsObj.Set("isValidUTF8", func(call otto.FunctionCall) otto.Value {
+		result, _ := js.VM.ToValue(utf8.ValidString(call.Argument(0).String()))
+		return result
+	})
+
+	// strings.toValidUTF8(s, replacement) returns s with each run of invalid UTF-8 replaced by replacement ("\uFFFD" by default)
+	stringsObj.Set("toValidUTF8", func(call otto.FunctionCall) otto.Value {
+		replacement := "\uFFFD"
+		if len(call.ArgumentList) > 1 {
+			replacement = call.Argument(1).String()
+		}
+		result, _ := js.VM.ToValue(strings.ToValidUTF8(call.Argument(0).String(), replacement))
+		return result
+	})
+
+	fmtxObj, _ := js.VM.Object(`fmtx = {}`)
+
+	// decimalsArg returns the call's argument i as a count of decimal places, 0 if missing
+	decimalsArg := func(call otto.FunctionCall, i int) int {
+		if len(call.ArgumentList) <= i {
+			return 0
+		}
+		n, _ := call.Argument(i).ToInteger()
+		if n < 0 {
+			return 0
+		}
+		return int(n)
+	}
+
+	// fmtx.number(n, decimals) returns n with thousands separators, e.g. fmtx.number(1234.5, 1) is "1,234.5"
+	fmtxObj.Set("number", func(call otto.FunctionCall) otto.Value {
+		n, _ := call.Argument(0).ToFloat()
+		result, _ := js.VM.ToValue(formatNumber(n, decimalsArg(call, 1)))
+		return result
+	})
+
+	// fmtx.bytes(n) returns a byte count in binary units, e.g. fmtx.bytes(1572864) is "1.5 MB"
+	fmtxObj.Set("bytes", func(call otto.FunctionCall) otto.Value {
+		n, _ := call.Argument(0).ToFloat()
+		result, _ := js.VM.ToValue(formatBytes(n))
+		return result
+	})
+
+	// fmtx.percent(n, decimals) returns the ratio n as a percentage, e.g. fmtx.percent(0.256, 1) is "25.6%"
+	fmtxObj.Set("percent", func(call otto.FunctionCall) otto.Value {
+		n, _ := call.Argument(0).ToFloat()
+		result, _ := js.VM.ToValue(formatNumber(n*100, decimalsArg(call, 1)) + "%")
+		return result
+	})
+
+	jsonlObj, _ := js.VM.Object(`jsonl = {}`)
+
+	// jsonl.read(filepath) returns an array of the JSON values found one per line, blank lines are skipped
+	jsonlObj.Set("read", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.read(%q), %s", call.CallerLocation(), filename, err))
+		}
+		defer fp.Close()
+		// each line goes through JSON.parse, raw JSON isn't always valid JavaScript source (e.g. U+2028 in a string)
+		values, _ := js.VM.Object(`([])`)
+		scanner := bufio.NewScanner(fp)
+		scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), maxLineSize)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			val, err := js.VM.Call("JSON.parse", nil, line)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s jsonl.read(%q), line %d, %s", call.CallerLocation(), filename, lineNo, err))
+			}
+			values.Call("push", val)
+		}
+		if err := scanner.Err(); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.read(%q), %s", call.CallerLocation(), filename, err))
+		}
+		return values.Value()
+	})
+
+	// jsonl.write(filepath, array) writes each element of array as compact JSON one per line, returns true on success
+	jsonlObj.Set("write", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		data, err := call.Argument(1).Export()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.write(%q, array), %s", call.CallerLocation(), filename, err))
+		}
+		// Round trip through JSON to get the individual elements whatever slice type otto exported
+		var elements []json.RawMessage
+		src, err := json.Marshal(data)
+		if err == nil {
+			err = json.Unmarshal(src, &elements)
+		}
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.write(%q, array), expected an array, %s", call.CallerLocation(), filename, err))
+		}
+		buf := new(bytes.Buffer)
+		for _, element := range elements {
+			if err := json.Compact(buf, element); err != nil {
+				return errorObject(nil, fmt.Sprintf("%s jsonl.write(%q, array), %s", call.CallerLocation(), filename, err))
+			}
+			buf.WriteString("\n")
+		}
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0660); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.write(%q, array), %s", call.CallerLocation(), filename, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// jsonl.forEach(filepath, callback) calls callback(value, lineNo) for each JSON line, returning false from callback stops the scan.
+	// Returns the number of values processed.
+	jsonlObj.Set("forEach", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		callback := call.Argument(1)
+		if callback.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.forEach(%q, callback), callback is not a function", call.CallerLocation(), filename))
+		}
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.forEach(%q, callback), %s", call.CallerLocation(), filename, err))
+		}
+		defer fp.Close()
+		count := 0
+		scanner := bufio.NewScanner(fp)
+		scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), maxLineSize)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			val, err := js.VM.Call("JSON.parse", nil, line)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s jsonl.forEach(%q, callback), line %d, %s", call.CallerLocation(), filename, lineNo, err))
+			}
+			count++
+			ok, err := callback.Call(otto.UndefinedValue(), val, lineNo)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s jsonl.forEach(%q, callback), line %d, %s", call.CallerLocation(), filename, lineNo, err))
+			}
+			if ok.IsBoolean() == true {
+				if b, _ := ok.ToBoolean(); b == false {
+					break
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s jsonl.forEach(%q, callback), %s", call.CallerLocation(), filename, err))
+		}
+		result, _ := js.VM.ToValue(count)
+		return result
+	})
+
+	jsonObj, _ := js.VM.Object(`json = {}`)
+
+	// json.writeArray(filepath, sourceFn) streams a JSON array to filepath, sourceFn(index) returns each element in turn
+	// and undefined when there are no more. Returns the count of elements written or an error object.
+	jsonObj.Set("writeArray", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		sourceFn := call.Argument(1)
+		if sourceFn.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s json.writeArray(%q, sourceFn), sourceFn is not a function", call.CallerLocation(), filename))
+		}
+		fp, err := os.Create(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s json.writeArray(%q, sourceFn), %s", call.CallerLocation(), filename, err))
+		}
+		defer fp.Close()
+		w := bufio.NewWriter(fp)
+		w.WriteString("[")
+		count := 0
+		for ; true; count++ {
+			val, err := sourceFn.Call(otto.UndefinedValue(), count)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s json.writeArray(%q, sourceFn), element %d, %s", call.CallerLocation(), filename, count, err))
+			}
+			if val.IsUndefined() == true {
+				break
+			}
+			src, err := js.VM.Call("JSON.stringify", nil, val)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s json.writeArray(%q, sourceFn), element %d, %s", call.CallerLocation(), filename, count, err))
+			}
+			if count > 0 {
+				w.WriteString(",\n")
+			}
+			if src.IsUndefined() == true {
+				// as in JSON.stringify, array elements that can't be represented become null
+				w.WriteString("null")
+			} else {
+				w.WriteString(src.String())
+			}
+		}
+		w.WriteString("]\n")
+		if err := w.Flush(); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s json.writeArray(%q, sourceFn), %s", call.CallerLocation(), filename, err))
+		}
+		result, _ := js.VM.ToValue(count)
+		return result
+	})
+
+	csvObj, _ := js.VM.Object(`csv = {}`)
+
+	// csv.read(filepath, options) returns an array of rows, each an array of cells. With options {infer: true}
+	// cells looking like numbers or booleans are converted (see inferCSVCell).
+	csvObj.Set("read", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		infer := boolOption(call.Argument(1), "infer")
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s csv.read(%q), %s", call.CallerLocation(), filename, err))
+		}
+		defer fp.Close()
+		r := csv.NewReader(fp)
+		r.FieldsPerRecord = -1
+		rows := [][]interface{}{}
+		for {
+			cells, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s csv.read(%q), %s", call.CallerLocation(), filename, err))
+			}
+			rows = append(rows, csvRow(cells, infer))
+		}
+		return responseObject(rows)
+	})
+
+	// csv.forEach(filepath, callback, options) calls callback(row, rowNo) for each row, returning false from callback stops
+	// the scan. Options are the same as csv.read. Returns the number of rows processed.
+	csvObj.Set("forEach", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		callback := call.Argument(1)
+		if callback.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s csv.forEach(%q, callback), callback is not a function", call.CallerLocation(), filename))
+		}
+		infer := boolOption(call.Argument(2), "infer")
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s csv.forEach(%q, callback), %s", call.CallerLocation(), filename, err))
+		}
+		defer fp.Close()
+		r := csv.NewReader(fp)
+		r.FieldsPerRecord = -1
+		count := 0
+		for rowNo := 1; ; rowNo++ {
+			cells, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s csv.forEach(%q, callback), %s", call.CallerLocation(), filename, err))
+			}
+			count++
+			ok, err := callback.Call(otto.UndefinedValue(), responseObject(csvRow(cells, infer)), rowNo)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s csv.forEach(%q, callback), row %d, %s", call.CallerLocation(), filename, rowNo, err))
+			}
+			if ok.IsBoolean() == true {
+				if b, _ := ok.ToBoolean(); b == false {
+					break
+				}
+			}
+		}
+		result, _ := js.VM.ToValue(count)
+		return result
+	})
+
+	// version describes the ostdlib release the VM was built with
+	versionObj, _ := js.VM.Object(`version = {}`)
+	major, minor, patch := SemVer()
+	for name, val := range map[string]interface{}{"string": Version, "major": major, "minor": minor, "patch": patch} {
+		val := val
+		versionObj.Set(name, func(call otto.FunctionCall) otto.Value {
+			result, _ := js.VM.ToValue(val)
+			return result
+		})
+	}
+
+	// time reads the clock, which embedders can replace with SetClock
+	timeObj, _ := js.VM.Object(`time = {}`)
+
+	// time.now() returns the current time as a Date
+	timeObj.Set("now", func(call otto.FunctionCall) otto.Value {
+		result, _ := js.VM.Eval(fmt.Sprintf("new Date(%d)", js.now().UnixNano()/int64(time.Millisecond)))
+		return result
+	})
+
+	// util holds general helpers, the timers of the callback ones run on the event loop (see Loop)
+	utilObj, _ := js.VM.Object(`util = {}`)
+
+	// delayArgs returns the function and millisecond delay given to util.debounce and util.throttle
+	delayArgs := func(call otto.FunctionCall, name string) (otto.Value, time.Duration, error) {
+		fn := call.Argument(0)
+		if fn.IsFunction() == false {
+			return fn, 0, fmt.Errorf("%s util.%s(fn, ms), fn is not a function", call.CallerLocation(), name)
+		}
+		ms, err := call.Argument(1).ToInteger()
+		if err != nil || ms < 0 {
+			return fn, 0, fmt.Errorf("%s util.%s(fn, %s), ms must be a non-negative number", call.CallerLocation(), name, call.Argument(1).String())
+		}
+		return fn, time.Duration(ms) * time.Millisecond, nil
+	}
+
+	// callArgs returns the arguments of call for passing on with Value.Call
+	callArgs := func(call otto.FunctionCall) []interface{} {
+		args := make([]interface{}, len(call.ArgumentList))
+		for i, arg := range call.ArgumentList {
+			args[i] = arg
+		}
+		return args
+	}
+
+	// util.debounce(fn, ms) returns a function which calls fn once calls to it have stopped for ms milliseconds
+	utilObj.Set("debounce", func(call otto.FunctionCall) otto.Value {
+		fn, delay, err := delayArgs(call, "debounce")
+		if err != nil {
+			return errorObject(nil, err.Error())
+		}
+		location := call.CallerLocation()
+		var pending *backgroundOp
+		result, _ := js.VM.ToValue(func(call otto.FunctionCall) otto.Value {
+			if pending != nil {
+				js.stopOp(pending)
+			}
+			this, args := call.This, callArgs(call)
+			pending = js.afterOp("util.debounce", delay, func() {
+				pending = nil
+				if _, err := fn.Call(this, args...); err != nil {
+					errorObject(nil, fmt.Sprintf("util.debounce(fn, %d) callback error, %s, %s", delay/time.Millisecond, location, err))
+				}
+			})
+			return otto.UndefinedValue()
+		})
+		return result
+	})
+
+	// util.throttle(fn, ms) returns a function which calls fn at most once every ms milliseconds
+	utilObj.Set("throttle", func(call otto.FunctionCall) otto.Value {
+		fn, delay, err := delayArgs(call, "throttle")
+		if err != nil {
+			return errorObject(nil, err.Error())
+		}
+		location := call.CallerLocation()
+		var (
+			window   *backgroundOp
+			trailing bool
+			this     otto.Value
+			args     []interface{}
+		)
+		var invoke func()
+		invoke = func() {
+			trailing = false
+			window = js.afterOp("util.throttle", delay, func() {
+				window = nil
+				if trailing == true {
+					invoke()
+				}
+			})
+			if _, err := fn.Call(this, args...); err != nil {
+				errorObject(nil, fmt.Sprintf("util.throttle(fn, %d) callback error, %s, %s", delay/time.Millisecond, location, err))
+			}
+		}
+		result, _ := js.VM.ToValue(func(call otto.FunctionCall) otto.Value {
+			this, args = call.This, callArgs(call)
+			if window != nil {
+				trailing = true
+				return otto.UndefinedValue()
+			}
+			invoke()
+			return otto.UndefinedValue()
+		})
+		return result
+	})
+
+	// util.sortBy(array, keys) returns a copy of array sorted by the fields in keys, e.g. ["dept", "-salary"]
+	utilObj.Set("sortBy", func(call otto.FunctionCall) otto.Value {
+		var (
+			records []interface{}
+			keys    []string
+		)
+		for i, target := range []interface{}{&records, &keys} {
+			rawObj, err := call.Argument(i).Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObj)
+				err = json.Unmarshal(src, target)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s util.sortBy(array, keys), %s", call.CallerLocation(), err))
+			}
+		}
+		sortKeys, err := parseSortKeys(keys)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s util.sortBy(array, keys), %s", call.CallerLocation(), err))
+		}
+		if records == nil {
+			records = []interface{}{}
+		}
+		sortRecords(records, sortKeys)
+		return responseObject(records)
+	})
+
+	script, err := js.VM.Compile("polyfill", Polyfill)
+	if err != nil {
+		log.Fatalf("polyfill compile error: %s\n\n%s\n", err, Polyfill)
+	}
+	js.VM.Eval(script)
+	return js.VM
+}
+
+// errorOp and errorLocation find the function (e.g. os.readFile) and the script position (e.g.
+// script.js:3:10) in the messages of the extensions' errors for JSONErrors
+var (
+	errorOp       = regexp.MustCompile(`[A-Za-z_$][A-Za-z0-9_$]*\.[A-Za-z_$][A-Za-z0-9_$]*\(`)
+	errorLocation = regexp.MustCompile(`[^\s,]+:[0-9]+:[0-9]+`)
+)
+
+// errorObject logs msg and returns it as an error object, {status: "error", error: msg}, setting
+// the properties on obj if it isn't nil
+func (js *JavaScriptVM) errorObject(obj *otto.Object, msg string) otto.Value {
+	if obj == nil {
+		obj, _ = js.VM.Object(`({})`)
+	}
+	if js.JSONErrors == true {
+		src, _ := json.Marshal(map[string]string{
+			"level":    "error",
+			"op":       strings.TrimSuffix(errorOp.FindString(msg), "("),
+			"location": errorLocation.FindString(msg),
+			"message":  msg,
+		})
+		log.Println(string(src))
+	} else {
+		log.Println(msg)
+	}
+	obj.Set("status", "error")
+	obj.Set("error", msg)
+	return obj.Value()
+}
+
+// responseObject returns data as a JavaScript value by way of JSON
+func (js *JavaScriptVM) responseObject(data interface{}) otto.Value {
+	src, _ := json.Marshal(data)
+	obj, _ := js.VM.Object(fmt.Sprintf(`(%s)`, src))
+	return obj.Value()
+}
+
+// lazyGroup defines the globals names as accessors, the first use of any of them runs install
+// (which creates them) in their place. LoadExtensions runs any installs still waiting.
+func (js *JavaScriptVM) lazyGroup(names []string, install func()) {
+	installed := false
+	materialize := func() {
+		if installed == true {
+			return
+		}
+		installed = true
+		delete(js.pending, names[0])
+		for _, name := range names {
+			js.VM.Eval(fmt.Sprintf("delete this[%q];", name))
+		}
+		js.installs[names[0]]++
+		install()
+	}
+	js.pending[names[0]] = materialize
+
+	define, err := js.VM.Eval(`(function (name, materialize) {
+		var global = this;
+		Object.defineProperty(global, name, {
+			configurable: true,
+			get: function () {
+				materialize();
+				return global[name];
+			},
+			set: function (val) {
+				materialize();
+				global[name] = val;
+			}
+		});
+	})`)
+	if err != nil {
+		log.Fatalf("Can't define lazy extensions %s, %s", strings.Join(names, ", "), err)
+	}
+	for _, name := range names {
+		if _, err := define.Call(otto.UndefinedValue(), name, func(call otto.FunctionCall) otto.Value {
+			materialize()
+			return otto.UndefinedValue()
+		}); err != nil {
+			log.Fatalf("Can't define lazy extension %s, %s", name, err)
+		}
+	}
+}
+
+// LoadExtensions installs the extension objects (os, http, xlsx and ods) still waiting for their first
+// use, see EagerExtensions
+func (js *JavaScriptVM) LoadExtensions() {
+	var names []string
+	for name := range js.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if materialize, ok := js.pending[name]; ok == true {
+			materialize()
+		}
+	}
+}
+
+// addOSObject installs the os object
+func (js *JavaScriptVM) addOSObject() {
+	errorObject, responseObject := js.errorObject, js.responseObject
+
+	osObj, _ := js.VM.Object(`os = {}`)
+
+	// os.args() returns an array of command line args after flag.Parse() has occurred.
+	osObj.Set("args", func(call otto.FunctionCall) otto.Value {
+		results, _ := js.VM.ToValue(js.args())
+		return results
+	})
+
+	// os.parseArgs(spec, args) parses flags declared by spec from args (os.args() by default) returning {flags, positional}
+	osObj.Set("parseArgs", func(call otto.FunctionCall) otto.Value {
+		var (
+			specs []argSpec
+			args  []string
+		)
+		for i, target := range []interface{}{&specs, &args} {
+			val := call.Argument(i)
+			if val.IsDefined() == false || val.IsNull() == true {
+				continue
+			}
+			rawObj, err := val.Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObj)
+				err = json.Unmarshal(src, target)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.parseArgs(), %s", call.CallerLocation(), err))
+			}
+		}
+		if call.Argument(1).IsDefined() == false {
+			args = js.args()
+		}
+		flags, positional, err := parseArgs(specs, args)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.parseArgs(), %s", call.CallerLocation(), err))
+		}
+		return responseObject(map[string]interface{}{
+			"flags":      flags,
+			"positional": positional,
+		})
+	})
+
+	// os.exit()
+	osObj.Set("exit", func(call otto.FunctionCall) otto.Value {
+		exitCode := 0
+		if len(call.ArgumentList) >= 1 {
+			s := call.Argument(0).String()
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				log.Printf("%s os.exit(%q), exit code is not a number, using 1", call.CallerLocation(), s)
+				i = 1
+			}
+			exitCode = i
+		}
+		if len(call.ArgumentList) == 2 {
+			log.Println(call.Argument(1).String())
+		}
+		js.exit(exitCode)
+		// ExitFunc returned, nothing after os.exit() should run
+		panic(haltError{errExited})
+	})
+
+	// os.atExit(callback) runs callback before os.exit() stops the program
+	osObj.Set("atExit", func(call otto.FunctionCall) otto.Value {
+		callback := call.Argument(0)
+		if callback.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.atExit(), expected a function", call.CallerLocation()))
+		}
+		js.AtExit(func() {
+			if _, err := callback.Call(otto.NullValue()); err != nil {
+				log.Printf("os.atExit() callback failed, %s", err)
+			}
+		})
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.getpid() returns the process id
+	osObj.Set("getpid", func(call otto.FunctionCall) otto.Value {
+		result, _ := js.VM.ToValue(os.Getpid())
+		return result
+	})
+
+	// os.hostInfo() returns an object with hostname, pid, numCPU and goVersion
+	osObj.Set("hostInfo", func(call otto.FunctionCall) otto.Value {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.hostInfo(), %s", call.CallerLocation(), err))
+		}
+		return responseObject(map[string]interface{}{
+			"hostname":  hostname,
+			"pid":       os.Getpid(),
+			"numCPU":    runtime.NumCPU(),
+			"goVersion": runtime.Version(),
+		})
+	})
+
+	// os.getEnv(env_varname) returns empty string or the value found as a string
+	osObj.Set("getEnv", func(call otto.FunctionCall) otto.Value {
+		envvar := call.Argument(0).String()
+		result, err := js.VM.ToValue(os.Getenv(envvar))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.getEnv(%q), %s", call.CallerLocation(), envvar, err))
+		}
+		return result
+	})
+
+	// os.setEnv(env_varname, value) sets the environment variable for the session, returns the value set.
+	osObj.Set("setEnv", func(call otto.FunctionCall) otto.Value {
+		envvar := call.Argument(0).String()
+		val := call.Argument(1).String()
+		err := os.Setenv(envvar, val)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.setEnv(%q, %q), %s", call.CallerLocation(), envvar, val, err))
+		}
+		result, err := js.VM.ToValue(os.Getenv(envvar))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.setEnv(%q, %q), %s", call.CallerLocation(), envvar, val, err))
+		}
+		return result
+	})
+
+	// os.lockFile(filepath) creates filepath.lock returning a handle for os.unlock, an error object if it exists
+	osObj.Set("lockFile", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		lockName := filename + ".lock"
+		fp, err := os.OpenFile(lockName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0664)
+		if err != nil {
+			if os.IsExist(err) == true {
+				err = fmt.Errorf("%s is locked, remove %s if no other process holds it", filename, lockName)
+			}
+			return errorObject(nil, fmt.Sprintf("%s os.lockFile(%q), %s", call.CallerLocation(), filename, err))
+		}
+		fmt.Fprintf(fp, "%d\n", os.Getpid())
+		fp.Close()
+		if js.locks == nil {
+			js.locks = make(map[int]string)
+		}
+		js.nextLockID++
+		js.locks[js.nextLockID] = lockName
+		result, _ := js.VM.ToValue(js.nextLockID)
+		return result
+	})
+
+	// os.unlock(handle) releases a lock taken with os.lockFile
+	osObj.Set("unlock", func(call otto.FunctionCall) otto.Value {
+		handle, err := call.Argument(0).ToInteger()
+		lockName, ok := js.locks[int(handle)]
+		if err != nil || ok == false {
+			return errorObject(nil, fmt.Sprintf("%s os.unlock(%s), not a handle from os.lockFile()", call.CallerLocation(), call.Argument(0).String()))
+		}
+		delete(js.locks, int(handle))
+		if err := os.Remove(lockName); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.unlock(%d), %s", call.CallerLocation(), handle, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.snapshotEnv() saves the process environment returning a handle for os.restoreEnv
+	osObj.Set("snapshotEnv", func(call otto.FunctionCall) otto.Value {
+		if js.envSnapshots == nil {
+			js.envSnapshots = make(map[int][]string)
+		}
+		js.nextEnvID++
+		js.envSnapshots[js.nextEnvID] = os.Environ()
+		result, _ := js.VM.ToValue(js.nextEnvID)
+		return result
+	})
+
+	// os.restoreEnv(handle) resets the process environment to the one saved by os.snapshotEnv, the snapshot is
+	// released once restored so the handle can't be used again
+	osObj.Set("restoreEnv", func(call otto.FunctionCall) otto.Value {
+		handle, err := call.Argument(0).ToInteger()
+		environ, ok := js.envSnapshots[int(handle)]
+		if err != nil || ok == false {
+			return errorObject(nil, fmt.Sprintf("%s os.restoreEnv(%s), not a handle from os.snapshotEnv()", call.CallerLocation(), call.Argument(0).String()))
+		}
+		if err := restoreEnv(environ); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.restoreEnv(%d), %s", call.CallerLocation(), handle, err))
+		}
+		delete(js.envSnapshots, int(handle))
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.exec(command, args, options) runs command with the args array and returns {stdout, stderr, exitCode} or an
+	// error object if it can't be run. The child inherits the environment, including changes made with os.setEnv,
+	// unless options.inheritEnv is false. options.env holds extra variables for the child.
+	osObj.Set("exec", func(call otto.FunctionCall) otto.Value {
+		var (
+			args    []string
+			options struct {
+				InheritEnv *bool             `json:"inheritEnv"`
+				Env        map[string]string `json:"env"`
+			}
+		)
+		command := call.Argument(0).String()
+		for i, target := range []interface{}{&args, &options} {
+			val := call.Argument(i + 1)
+			if val.IsDefined() == false || val.IsNull() == true {
+				continue
+			}
+			rawObj, err := val.Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObj)
+				err = json.Unmarshal(src, target)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.exec(%q), %s", call.CallerLocation(), command, err))
+			}
+		}
+		cmd := exec.Command(command, args...)
+		if options.InheritEnv != nil && *options.InheritEnv == false {
+			cmd.Env = []string{}
+		} else if len(options.Env) > 0 {
+			cmd.Env = os.Environ()
+		}
+		for k, v := range options.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		exitCode, err := exitStatus(cmd.Run())
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.exec(%q), %s", call.CallerLocation(), command, err))
+		}
+		return responseObject(map[string]interface{}{
+			"stdout":   stdout.String(),
+			"stderr":   stderr.String(),
+			"exitCode": exitCode,
+		})
+	})
+
+	// os.pipeline([{cmd, args}, ...]) runs the commands with each one's stdout piped to the next one's stdin,
+	// returns {code, stdout, stderr} of the final command or an error object naming the stage that failed
+	osObj.Set("pipeline", func(call otto.FunctionCall) otto.Value {
+		var stages []struct {
+			Cmd  string   `json:"cmd"`
+			Args []string `json:"args"`
+		}
+		rawObj, err := call.Argument(0).Export()
+		if err == nil {
+			src, _ := json.Marshal(rawObj)
+			err = json.Unmarshal(src, &stages)
+		}
+		if err == nil && len(stages) == 0 {
+			err = fmt.Errorf("expected an array of {cmd, args} objects")
+		}
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.pipeline(), %s", call.CallerLocation(), err))
+		}
+
+		cmds := make([]*exec.Cmd, len(stages))
+		for i, stage := range stages {
+			cmds[i] = exec.Command(stage.Cmd, stage.Args...)
+			if i > 0 {
+				cmds[i].Stdin, err = cmds[i-1].StdoutPipe()
+				if err != nil {
+					return errorObject(nil, fmt.Sprintf("%s os.pipeline(), stage %d %q, %s", call.CallerLocation(), i, stages[i-1].Cmd, err))
+				}
+			}
+		}
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		last := cmds[len(cmds)-1]
+		last.Stdout, last.Stderr = stdout, stderr
+
+		for i, cmd := range cmds {
+			if err := cmd.Start(); err != nil {
+				// Stop the stages already running so they don't block on a pipe nobody reads
+				for _, started := range cmds[:i] {
+					started.Process.Kill()
+					started.Wait()
+				}
+				return errorObject(nil, fmt.Sprintf("%s os.pipeline(), stage %d %q, %s", call.CallerLocation(), i, stages[i].Cmd, err))
+			}
+		}
+		code := 0
+		for i, cmd := range cmds {
+			exitCode, err := exitStatus(cmd.Wait())
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.pipeline(), stage %d %q, %s", call.CallerLocation(), i, stages[i].Cmd, err))
+			}
+			code = exitCode
+		}
+		return responseObject(map[string]interface{}{
+			"code":   code,
+			"stdout": stdout.String(),
+			"stderr": stderr.String(),
+		})
+	})
+
+	// os.loadEnv(filepath) sets the environment variables defined in a .env file, returns the count set or an error object
+	osObj.Set("loadEnv", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.loadEnv(%q), %s", call.CallerLocation(), filename, err))
+		}
+		vars, err := parseEnv(src)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.loadEnv(%q), %s", call.CallerLocation(), filename, err))
+		}
+		for _, kv := range vars {
+			if err := os.Setenv(kv[0], kv[1]); err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.loadEnv(%q), %s", call.CallerLocation(), filename, err))
+			}
+		}
+		result, _ := js.VM.ToValue(len(vars))
+		return result
+	})
+
+	// os.readFile(filepath) returns the content of the filepath or empty string
+	osObj.Set("readFile", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readFile(%q), %s", call.CallerLocation(), filename, err))
+		}
+		result, err := js.VM.ToValue(fmt.Sprintf("%s", buf))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readFile(%q), %s", call.CallerLocation(), filename, err))
+		}
+		return result
+	})
+
+	// os.readFileRange(filepath, offset, length) returns an array of up to length bytes read from offset
+	osObj.Set("readFileRange", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 3 {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(filepath, offset, length), expected three arguments", call.CallerLocation()))
+		}
+		filename := call.Argument(0).String()
+		offset, err := call.Argument(1).ToInteger()
+		if err != nil || offset < 0 {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(%q, %s), offset must be a non-negative integer", call.CallerLocation(), filename, call.Argument(1).String()))
+		}
+		length, err := call.Argument(2).ToInteger()
+		if err != nil || length < 0 {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(%q, %d, %s), length must be a non-negative integer", call.CallerLocation(), filename, offset, call.Argument(2).String()))
+		}
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(%q, %d, %d), %s", call.CallerLocation(), filename, offset, length, err))
+		}
+		defer fp.Close()
+		info, err := fp.Stat()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(%q, %d, %d), %s", call.CallerLocation(), filename, offset, length, err))
+		}
+		// clamp length to the bytes left so a large length can't exhaust memory
+		if offset >= info.Size() {
+			return responseObject([]int{})
+		}
+		if length > info.Size()-offset {
+			length = info.Size() - offset
+		}
+		buf := make([]byte, length)
+		n, err := fp.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return errorObject(nil, fmt.Sprintf("%s os.readFileRange(%q, %d, %d), %s", call.CallerLocation(), filename, offset, length, err))
+		}
+		// the bytes are returned as numbers, json encodes a []byte as a base64 string
+		data := make([]int, n)
+		for i, b := range buf[:n] {
+			data[i] = int(b)
+		}
+		return responseObject(data)
+	})
+
+	// os.writeFile(filepath, contents) returns true on sucess, false on failure
+	osObj.Set("writeFile", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		buf := call.Argument(1).String()
+		err := ioutil.WriteFile(filename, []byte(buf), 0660)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.writeFile(%q, %q), %s", call.CallerLocation(), filename, buf, err))
+		}
+		result, err := js.VM.ToValue(buf)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.writeFile(%q, %q), %s", call.CallerLocation(), filename, buf, err))
+		}
+		return result
+	})
+
+	// os.readJSON(filepath) returns the parsed contents of a JSON file or an error object
+	osObj.Set("readJSON", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readJSON(%q), %s", call.CallerLocation(), filename, err))
+		}
+		result, err := js.VM.Call("JSON.parse", nil, string(buf))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.readJSON(%q), %s", call.CallerLocation(), filename, err))
+		}
+		return result
+	})
+
+	// os.writeJSON(filepath, value, pretty) writes value as JSON (indented when pretty is true), returns true or an error object
+	osObj.Set("writeJSON", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		indent := ""
+		if boolOption(call.Argument(2), "pretty") == true {
+			indent = "    "
+		}
+		src, err := js.VM.Call("JSON.stringify", nil, call.Argument(1), nil, indent)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.writeJSON(%q), %s", call.CallerLocation(), filename, err))
+		}
+		if src.IsUndefined() == true {
+			return errorObject(nil, fmt.Sprintf("%s os.writeJSON(%q), value can't be represented as JSON", call.CallerLocation(), filename))
+		}
+		if err := ioutil.WriteFile(filename, []byte(src.String()+"\n"), 0660); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.writeJSON(%q), %s", call.CallerLocation(), filename, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.rename(oldpath, newpath) renames a path returns an error object or true on success
+	osObj.Set("rename", func(call otto.FunctionCall) otto.Value {
+		oldpath := call.Argument(0).String()
+		newpath := call.Argument(1).String()
+		overwrite := boolOption(call.Argument(2), "overwrite")
+		err := renamePath(oldpath, newpath, overwrite)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.rename(%q, %q), %s", call.CallerLocation(), oldpath, newpath, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.hardlink(oldname, newname) creates newname as a hard link to oldname, returns an error object or true on success
+	osObj.Set("hardlink", func(call otto.FunctionCall) otto.Value {
+		oldname := call.Argument(0).String()
+		newname := call.Argument(1).String()
+		err := os.Link(oldname, newname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.hardlink(%q, %q), %s", call.CallerLocation(), oldname, newname, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.copyFile(src, dst, options) copies src to dst preserving its mode, returns an error object or true on success.
+	// options is overwrite as a boolean or {overwrite, preserveTimes}.
+	osObj.Set("copyFile", func(call otto.FunctionCall) otto.Value {
+		src := call.Argument(0).String()
+		dst := call.Argument(1).String()
+		overwrite := boolOption(call.Argument(2), "overwrite")
+		preserveTimes := call.Argument(2).IsObject() == true && boolOption(call.Argument(2), "preserveTimes")
+		err := copyFile(src, dst, overwrite, preserveTimes)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.copyFile(%q, %q), %s", call.CallerLocation(), src, dst, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.copyGlob(pattern, destDir, options) copies the files matching pattern into destDir (created if needed) keeping
+	// their base names and modes. Returns the destination paths or an error object. options is overwrite as a boolean or
+	// {overwrite, preserveTimes}.
+	osObj.Set("copyGlob", func(call otto.FunctionCall) otto.Value {
+		pattern := call.Argument(0).String()
+		destDir := call.Argument(1).String()
+		overwrite := boolOption(call.Argument(2), "overwrite")
+		preserveTimes := call.Argument(2).IsObject() == true && boolOption(call.Argument(2), "preserveTimes")
+		if info, err := os.Stat(destDir); err == nil && info.IsDir() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.copyGlob(%q, %q), %s is not a directory", call.CallerLocation(), pattern, destDir, destDir))
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.copyGlob(%q, %q), %s", call.CallerLocation(), pattern, destDir, err))
+		}
+		if err := os.MkdirAll(destDir, 0775); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.copyGlob(%q, %q), %s", call.CallerLocation(), pattern, destDir, err))
+		}
+		copied := []string{}
+		for _, src := range matches {
+			info, err := os.Stat(src)
+			if err == nil && info.IsDir() == true {
+				continue
+			}
+			dst := filepath.Join(destDir, filepath.Base(src))
+			// a match already in destDir would be copied onto itself
+			if dstInfo, dstErr := os.Stat(dst); err == nil && dstErr == nil && os.SameFile(info, dstInfo) == true {
+				continue
+			}
+			if err := copyFile(src, dst, overwrite, preserveTimes); err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.copyGlob(%q, %q), %s", call.CallerLocation(), pattern, destDir, err))
+			}
+			copied = append(copied, dst)
+		}
+		return responseObject(copied)
+	})
+
+	// os.copyDir(src, dst, options) copies the directory tree src to dst keeping modes. Returns the copied file paths
+	// or an error object. options is overwrite as a boolean or {overwrite, preserveTimes}.
+	osObj.Set("copyDir", func(call otto.FunctionCall) otto.Value {
+		src := call.Argument(0).String()
+		dst := call.Argument(1).String()
+		overwrite := boolOption(call.Argument(2), "overwrite")
+		preserveTimes := call.Argument(2).IsObject() == true && boolOption(call.Argument(2), "preserveTimes")
+		copied, err := copyDir(src, dst, overwrite, preserveTimes)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.copyDir(%q, %q), %s", call.CallerLocation(), src, dst, err))
+		}
+		return responseObject(copied)
+	})
+
+	// os.newerThan(pathA, pathB) returns true if pathA was modified more recently than pathB, an error object if either is missing
+	osObj.Set("newerThan", func(call otto.FunctionCall) otto.Value {
+		pathA := call.Argument(0).String()
+		pathB := call.Argument(1).String()
+		infoA, err := os.Stat(pathA)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.newerThan(%q, %q), %s", call.CallerLocation(), pathA, pathB, err))
+		}
+		infoB, err := os.Stat(pathB)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.newerThan(%q, %q), %s", call.CallerLocation(), pathA, pathB, err))
+		}
+		result, _ := js.VM.ToValue(infoA.ModTime().After(infoB.ModTime()))
+		return result
+	})
+
+	// os.splitPath(pathname) returns {dir, file} splitting pathname after its last separator
+	osObj.Set("splitPath", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		dir, file := filepath.Split(pathname)
+		return responseObject(map[string]string{"dir": dir, "file": file})
+	})
+
+	// os.volumeName(pathname) returns the leading volume name (e.g. "C:" on Windows), an empty string elsewhere
+	osObj.Set("volumeName", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		result, _ := js.VM.ToValue(filepath.VolumeName(pathname))
+		return result
+	})
+
+	// os.sameFile(pathA, pathB) returns true if both files have identical contents or an error object
+	osObj.Set("sameFile", func(call otto.FunctionCall) otto.Value {
+		pathA := call.Argument(0).String()
+		pathB := call.Argument(1).String()
+		same, err := sameFile(pathA, pathB)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.sameFile(%q, %q), %s", call.CallerLocation(), pathA, pathB, err))
+		}
+		result, _ := js.VM.ToValue(same)
+		return result
+	})
+
+	// os.isExecutable(pathname) returns true if any of the file's execute bits are set or an error object
+	osObj.Set("isExecutable", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		info, err := os.Stat(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.isExecutable(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(info.Mode()&0111 != 0)
+		return result
+	})
+
+	// os.makeExecutable(pathname) adds the execute bits (0111) to the file's mode, returns true or an error object
+	osObj.Set("makeExecutable", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		info, err := os.Stat(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.makeExecutable(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		if err := os.Chmod(pathname, info.Mode()|0111); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.makeExecutable(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.hashFile(pathname, algo) returns the hex digest of a file ("md5", "sha1" or "sha256") or an error object
+	osObj.Set("hashFile", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		algo := "sha256"
+		if len(call.ArgumentList) > 1 {
+			algo = call.Argument(1).String()
+		}
+		digest, err := hashFile(pathname, algo)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.hashFile(%q, %q), %s", call.CallerLocation(), pathname, algo, err))
+		}
+		result, _ := js.VM.ToValue(digest)
+		return result
+	})
+
+	// os.realpath(pathname) returns the absolute path with any symbolic links resolved or an error object
+	osObj.Set("realpath", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		p, err := filepath.Abs(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.realpath(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		p, err = filepath.EvalSymlinks(p)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.realpath(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(p)
+		return result
+	})
+
+	// os.removeGlob(pattern, confirmFn) removes the files matching pattern for which confirmFn(path) returns true,
+	// without a confirmFn the options object {force: true} is required. Returns the removed paths or an error object
+	// with the paths removed before the error as its removed property.
+	osObj.Set("removeGlob", func(call otto.FunctionCall) otto.Value {
+		pattern := call.Argument(0).String()
+		confirmFn := call.Argument(1)
+		if confirmFn.IsFunction() == false && boolOption(confirmFn, "force") == false {
+			return errorObject(nil, fmt.Sprintf("%s os.removeGlob(%q), requires a confirm function or {force: true}", call.CallerLocation(), pattern))
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.removeGlob(%q), %s", call.CallerLocation(), pattern, err))
+		}
+		removed := []string{}
+		// the error object keeps the paths already removed, the caller can't get them back otherwise
+		failed := func(err error) otto.Value {
+			obj := responseObject(map[string][]string{"removed": removed}).Object()
+			return errorObject(obj, fmt.Sprintf("%s os.removeGlob(%q), %s, %d removed", call.CallerLocation(), pattern, err, len(removed)))
+		}
+		for _, pathname := range matches {
+			// a symbolic link is removed itself, even when what it points at is missing
+			stat, err := os.Lstat(pathname)
+			if err != nil {
+				return failed(err)
+			}
+			if stat.IsDir() == true {
+				continue
+			}
+			if confirmFn.IsFunction() == true {
+				ok, err := confirmFn.Call(otto.NullValue(), pathname)
+				if err != nil {
+					return failed(err)
+				}
+				if b, _ := ok.ToBoolean(); b == false {
+					continue
+				}
+			}
+			if err := os.Remove(pathname); err != nil {
+				return failed(err)
+			}
+			removed = append(removed, pathname)
+		}
+		return responseObject(removed)
+	})
+
+	// os.remove(filepath) returns an error object or true if successful
+	osObj.Set("remove", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		fp, err := os.Open(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		defer fp.Close()
+		stat, err := fp.Stat()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(false)
+		if stat.IsDir() == false {
+			err := os.Remove(pathname)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+			}
+			result, _ = js.VM.ToValue(true)
+		}
+		return result
+	})
+
+	// os.chmod(filepath, perms) returns an error object or true if successful
+	osObj.Set("chmod", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		perms := call.Argument(1).String()
+
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+		}
+		defer fp.Close()
+
+		perm, err := strconv.ParseUint(perms, 10, 32)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+		}
+		err = fp.Chmod(os.FileMode(perm))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.find(startpath) returns an array of path names
+	osObj.Set("find", func(call otto.FunctionCall) otto.Value {
+		var (
+			dirs     []string
+			walkErrs []map[string]string
+		)
+		startpath := call.Argument(0).String()
+		withErrors := boolOption(call.Argument(1), "withErrors")
+		err := walkPaths(startpath, func(p string, info os.FileInfo) error {
+			dirs = append(dirs, p)
+			return nil
+		}, func(p string, err error) error {
+			log.Printf("%s os.find(%q), %s", call.CallerLocation(), startpath, err)
+			walkErrs = append(walkErrs, map[string]string{"path": p, "error": err.Error()})
+			return nil
+		})
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.find(%q), %s", call.CallerLocation(), startpath, err))
+		}
+		if withErrors == true {
+			if walkErrs == nil {
+				walkErrs = []map[string]string{}
+			}
+			return responseObject(map[string]interface{}{"paths": dirs, "errors": walkErrs})
+		}
+		result, err := js.VM.ToValue(dirs)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.find(%q), %s", call.CallerLocation(), startpath, err))
+		}
+		return result
+	})
+
+	// os.sizeByExt(root) returns an object of lowercased file extensions (e.g. ".xlsx", "" for none) pointing at {bytes, files}
+	osObj.Set("sizeByExt", func(call otto.FunctionCall) otto.Value {
+		type extSize struct {
+			Bytes int64 `json:"bytes"`
+			Files int   `json:"files"`
+		}
+		root := call.Argument(0).String()
+		sizes := make(map[string]*extSize)
+		err := walkPaths(root, func(p string, info os.FileInfo) error {
+			if info.Mode().IsRegular() == false {
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(p))
+			if sizes[ext] == nil {
+				sizes[ext] = new(extSize)
+			}
+			sizes[ext].Bytes += info.Size()
+			sizes[ext].Files++
+			return nil
+		}, func(p string, err error) error {
+			log.Printf("%s os.sizeByExt(%q), %s", call.CallerLocation(), root, err)
+			return nil
+		})
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.sizeByExt(%q), %s", call.CallerLocation(), root, err))
+		}
+		return responseObject(sizes)
+	})
+
+	// os.walk(startpath, visitor) calls visitor({path, isDir, size}) for each entry found, the visitor may return "skip" to skip a directory or file
+	osObj.Set("walk", func(call otto.FunctionCall) otto.Value {
+		startpath := call.Argument(0).String()
+		visitor := call.Argument(1)
+		onError := call.Argument(2)
+		if visitor.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.walk(%q, visitor), visitor is not a function", call.CallerLocation(), startpath))
+		}
+		err := walkPaths(startpath, func(p string, info os.FileInfo) error {
+			entry, _ := js.VM.Object(`({})`)
+			entry.Set("path", p)
+			entry.Set("isDir", info.IsDir())
+			entry.Set("size", info.Size())
+			val, err := visitor.Call(otto.UndefinedValue(), entry)
+			if err != nil {
+				return err
+			}
+			// SkipDir for a file would skip the rest of its directory, a skipped file is simply passed over
+			if val.IsString() == true && val.String() == "skip" && info.IsDir() == true {
+				return filepath.SkipDir
+			}
+			return nil
+		}, func(p string, err error) error {
+			if onError.IsFunction() == false {
+				log.Printf("%s os.walk(%q, visitor), %s", call.CallerLocation(), startpath, err)
+				return nil
+			}
+			failure, _ := js.VM.Object(`({})`)
+			failure.Set("path", p)
+			failure.Set("error", err.Error())
+			_, err = onError.Call(otto.UndefinedValue(), failure)
+			return err
+		})
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.walk(%q, visitor), %s", call.CallerLocation(), startpath, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.grep(filepath, pattern, options) returns the lines of filepath matching the regular expression pattern,
+	// or the number of matching lines when options.count is true
+	osObj.Set("grep", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		pattern := call.Argument(1).String()
+		countOnly := boolOption(call.Argument(2), "count")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.grep(%q, %q), %s", call.CallerLocation(), filename, pattern, err))
+		}
+		fp, err := os.Open(filename)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.grep(%q, %q), %s", call.CallerLocation(), filename, pattern, err))
+		}
+		defer fp.Close()
+		lines := []string{}
+		count := 0
+		scanner := bufio.NewScanner(fp)
+		scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), maxLineSize)
+		for scanner.Scan() {
+			if re.MatchString(scanner.Text()) == true {
+				count++
+				if countOnly == false {
+					lines = append(lines, scanner.Text())
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.grep(%q, %q), %s", call.CallerLocation(), filename, pattern, err))
+		}
+		if countOnly == true {
+			result, _ := js.VM.ToValue(count)
+			return result
+		}
+		result, _ := js.VM.ToValue(lines)
+		return result
+	})
+
+	// os.tail(filepath, n) returns the last n lines of filepath as an array or an error object
+	osObj.Set("tail", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		n := 10
+		if len(call.ArgumentList) > 1 {
+			i, err := call.Argument(1).ToInteger()
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.tail(%q, %s), %s", call.CallerLocation(), filename, call.Argument(1).String(), err))
+			}
+			n = int(i)
+		}
+		lines, err := tailLines(filename, n)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.tail(%q, %d), %s", call.CallerLocation(), filename, n, err))
+		}
+		result, _ := js.VM.ToValue(lines)
+		return result
+	})
+
+	// os.mkdir(pathname, perms) return an error object or true
+	osObj.Set("mkdir", func(call otto.FunctionCall) otto.Value {
+		newpath := call.Argument(0).String()
+		perms := call.Argument(1).String()
+
+		perm, err := strconv.ParseUint(perms, 10, 32)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+		}
+		err = os.Mkdir(newpath, os.FileMode(perm))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+		}
+
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.mkdir(pathname, perms) return an error object or true
+	osObj.Set("mkdirAll", func(call otto.FunctionCall) otto.Value {
+		newpath := call.Argument(0).String()
+		perms := call.Argument(1).String()
+
+		perm, err := strconv.ParseUint(perms, 10, 32)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+		}
+		err = os.MkdirAll(newpath, os.FileMode(perm))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.withTempFile(callback) creates an empty temp file, calls callback(path) and removes the file however
+	// callback returns. Returns callback's result or an error object.
+	osObj.Set("withTempFile", func(call otto.FunctionCall) otto.Value {
+		callback := call.Argument(0)
+		if callback.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempFile(callback), callback is not a function", call.CallerLocation()))
+		}
+		fp, err := ioutil.TempFile("", "ostdlib")
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempFile(callback), %s", call.CallerLocation(), err))
+		}
+		fname := fp.Name()
+		fp.Close()
+		defer os.Remove(fname)
+		result, err := callback.Call(otto.UndefinedValue(), fname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempFile(callback), %s", call.CallerLocation(), err))
+		}
+		return result
+	})
+
+	// os.withTempDir(callback) creates a temp directory, calls callback(path) and removes the directory and its
+	// contents however callback returns. Returns callback's result or an error object.
+	osObj.Set("withTempDir", func(call otto.FunctionCall) otto.Value {
+		callback := call.Argument(0)
+		if callback.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempDir(callback), callback is not a function", call.CallerLocation()))
+		}
+		dname, err := ioutil.TempDir("", "ostdlib")
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempDir(callback), %s", call.CallerLocation(), err))
+		}
+		defer os.RemoveAll(dname)
+		result, err := callback.Call(otto.UndefinedValue(), dname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.withTempDir(callback), %s", call.CallerLocation(), err))
+		}
+		return result
+	})
+
+	// os.mktree(baseDir, spec) creates the directories and files described by spec, returns true or an error object
+	osObj.Set("mktree", func(call otto.FunctionCall) otto.Value {
+		baseDir := call.Argument(0).String()
+		if call.Argument(1).IsObject() == false {
+			return errorObject(nil, fmt.Sprintf("%s os.mktree(%q, spec), spec should be an object", call.CallerLocation(), baseDir))
+		}
+		raw, err := call.Argument(1).Export()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mktree(%q, spec), %s", call.CallerLocation(), baseDir, err))
+		}
+		spec, ok := raw.(map[string]interface{})
+		if ok == false {
+			return errorObject(nil, fmt.Sprintf("%s os.mktree(%q, spec), spec should be an object", call.CallerLocation(), baseDir))
+		}
+		if err := mktree(baseDir, spec); err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.mktree(%q, spec), %s", call.CallerLocation(), baseDir, err))
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// os.rmdir(pathname) returns an error object or true if successful
+	osObj.Set("rmdir", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		// NOTE: make sure this is a directory and not a file
+		fp, err := os.Open(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		defer fp.Close()
+		stat, err := fp.Stat()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(false)
+		if stat.IsDir() == true {
+			err := os.Remove(pathname)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
+			}
+			result, _ = js.VM.ToValue(true)
+		}
+		return result
+	})
+
+	// os.rmdirAll(pathname) returns an error object or true if successful
+	osObj.Set("rmdirAll", func(call otto.FunctionCall) otto.Value {
+		pathname := call.Argument(0).String()
+		// NOTE: make sure this is a directory and not a file
+		fp, err := os.Open(pathname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		defer fp.Close()
+		stat, err := fp.Stat()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
+		}
+		result, _ := js.VM.ToValue(false)
+		if stat.IsDir() == true {
+			err := os.RemoveAll(pathname)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
+			}
+			result, _ = js.VM.ToValue(true)
+		}
+		return result
+	})
+}
+
+// addHTTPObject installs the http object
+func (js *JavaScriptVM) addHTTPObject() {
+	errorObject, responseObject := js.errorObject, js.responseObject
+
+	httpObj, _ := js.VM.Object(`http = {}`)
+
+	// wantsResponse is true when the optional final argument of http.get or http.post asks for the
+	// whole response rather than just its body
+	wantsResponse := func(val otto.Value) bool {
+		if val.IsBoolean() == false {
+			return false
+		}
+		full, _ := val.ToBoolean()
+		return full
+	}
+
+	// responseValue returns {status, statusText, headers, body} for resp and its content
+	responseValue := func(resp *http.Response, content []byte) otto.Value {
+		headers := map[string][]string(resp.Header)
+		if headers == nil {
+			headers = map[string][]string{}
+		}
+		return responseObject(map[string]interface{}{
+			"status":     resp.StatusCode,
+			"statusText": http.StatusText(resp.StatusCode),
+			"headers":    headers,
+			"body":       fmt.Sprintf("%s", content),
+		})
+	}
+
+	// http.Get(uri, headers, full) returns contents recieved (if any), or when full is true the
+	// response as {status, statusText, headers, body} without following redirects
+	httpObj.Set("get", func(call otto.FunctionCall) otto.Value {
+		var headers headerList
+
+		uri := call.Argument(0).String()
+		full := wantsResponse(call.Argument(2))
+		if len(call.ArgumentList) > 1 {
+			rawObjs, err := call.Argument(1).Export()
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to process headers, %s, %s, %s", call.CallerLocation(), uri, err))
+			}
+			src, _ := json.Marshal(rawObjs)
+			err = json.Unmarshal(src, &headers)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to translate headers, %s, %s, %s", call.CallerLocation(), uri, err))
+			}
+		}
+
+		client := js.httpClient()
+		if full == true {
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't create a GET request for %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		for _, header := range headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		if full == true {
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Can't read response %s, %s, %s", uri, call.CallerLocation(), err))
+			}
+			return responseValue(resp, content)
+		}
+
+		result, err := js.VM.ToValue(fmt.Sprintf("%s", content))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("http.get(%q, headers) error, %s, %s", uri, call.CallerLocation(), err))
+		}
+		return result
+	})
+
+	// HttpPost(uri, mimeType, payload, headers, full) returns contents recieved (if any), or when full
+	// is true the response as {status, statusText, headers, body} without following redirects
+	httpObj.Set("post", func(call otto.FunctionCall) otto.Value {
+		var headers headerList
+
+		uri := call.Argument(0).String()
+		mimeType := call.Argument(1).String()
+		payload := call.Argument(2).String()
+		full := wantsResponse(call.Argument(4))
+		buf := strings.NewReader(payload)
+		// Process any additional headers past to http.Post()
+		if len(call.ArgumentList) > 2 {
+			rawObjs, err := call.Argument(3).Export()
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to process headers for %s, %s, %s", uri, call.CallerLocation(), err))
+			}
+			src, _ := json.Marshal(rawObjs)
+			err = json.Unmarshal(src, &headers)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to translate header for %s, %s, %s", uri, call.CallerLocation(), err))
+			}
+		}
+
+		client := js.httpClient()
+		if full == true {
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
+		req, err := http.NewRequest("POST", uri, buf)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't create a POST request for %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		req.Header.Set("Content-Type", mimeType)
+		for _, header := range headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't read response %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		if full == true {
+			return responseValue(resp, content)
+		}
+		result, err := js.VM.ToValue(fmt.Sprintf("%s", content))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("http.post(%q, headers, payload) error, %s, %s", uri, call.CallerLocation(), err))
+		}
+		return result
+	})
+
+	// sendRequest makes a request for http.put and http.delete returning the response body or an error object,
+	// headersVal is the optional headers argument and mimeType is only set when not empty
+	sendRequest := func(call otto.FunctionCall, method, uri, mimeType string, body io.Reader, headersVal otto.Value) otto.Value {
+		var headers headerList
+
+		name := "http." + strings.ToLower(method)
+		if headersVal.IsDefined() == true && headersVal.IsNull() == false {
+			rawObjs, err := headersVal.Export()
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to process headers for %s, %s, %s", uri, call.CallerLocation(), err))
+			}
+			src, _ := json.Marshal(rawObjs)
+			err = json.Unmarshal(src, &headers)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to translate header for %s, %s, %s", uri, call.CallerLocation(), err))
+			}
+		}
+
+		client := js.httpClient()
+		req, err := http.NewRequest(method, uri, body)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't create a %s request for %s, %s, %s", method, uri, call.CallerLocation(), err))
+		}
+		if mimeType != "" {
+			req.Header.Set("Content-Type", mimeType)
+		}
+		for _, header := range headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't read response %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		result, err := js.VM.ToValue(fmt.Sprintf("%s", content))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("%s(%q) error, %s, %s", name, uri, call.CallerLocation(), err))
+		}
+		return result
+	}
+
+	// http.put(uri, mimeType, payload, headers) returns contents recieved (if any)
+	httpObj.Set("put", func(call otto.FunctionCall) otto.Value {
+		uri := call.Argument(0).String()
+		mimeType := call.Argument(1).String()
+		payload := call.Argument(2).String()
+		return sendRequest(call, "PUT", uri, mimeType, strings.NewReader(payload), call.Argument(3))
+	})
+
+	// http.delete(uri, headers) returns contents recieved (if any), often an empty string
+	httpObj.Set("delete", func(call otto.FunctionCall) otto.Value {
+		uri := call.Argument(0).String()
+		return sendRequest(call, "DELETE", uri, "", nil, call.Argument(1))
+	})
+
+	// http.buildURL(base, params) returns base with the params object added as URL encoded query parameters
+	httpObj.Set("buildURL", func(call otto.FunctionCall) otto.Value {
+		base := call.Argument(0).String()
+		var params map[string]interface{}
+		if val := call.Argument(1); val.IsDefined() == true && val.IsNull() == false {
+			rawObj, err := val.Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObj)
+				err = json.Unmarshal(src, &params)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("http.buildURL(%q, params) error, %s, %s", base, call.CallerLocation(), err))
+			}
+		}
+		uri, err := buildURL(base, params)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("http.buildURL(%q, params) error, %s, %s", base, call.CallerLocation(), err))
+		}
+		result, _ := js.VM.ToValue(uri)
+		return result
+	})
+
+	// http.setDebug(on) turns logging of each request and response on or off
+	httpObj.Set("setDebug", func(call otto.FunctionCall) otto.Value {
+		on, _ := call.Argument(0).ToBoolean()
+		js.httpDebug = on
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+
+	// http.download(uri, filepath, options) saves the response body to filepath returning {bytes, total} or an error object.
+	// options may include headers, onProgress({bytes, total}) called as the body is copied and resume to continue a partial file.
+	httpObj.Set("download", func(call otto.FunctionCall) otto.Value {
+		var headers headerList
+
+		uri := call.Argument(0).String()
+		fname := call.Argument(1).String()
+		onProgress := otto.UndefinedValue()
+		resume := false
+		if options := call.Argument(2); options.IsObject() == true {
+			if val, err := options.Object().Get("headers"); err == nil && val.IsDefined() == true {
+				rawObjs, err := val.Export()
+				if err != nil {
+					return errorObject(nil, fmt.Sprintf("Failed to process headers, %s, %s, %s", call.CallerLocation(), uri, err))
+				}
+				src, _ := json.Marshal(rawObjs)
+				if err := json.Unmarshal(src, &headers); err != nil {
+					return errorObject(nil, fmt.Sprintf("Failed to translate headers, %s, %s, %s", call.CallerLocation(), uri, err))
+				}
+			}
+			onProgress, _ = options.Object().Get("onProgress")
+			resume = boolOption(options, "resume")
+		}
+
+		// A partial file is continued by asking for the rest of it
+		var offset int64
+		if resume == true {
+			if info, err := os.Stat(fname); err == nil && info.Mode().IsRegular() == true {
+				offset = info.Size()
+			}
+		}
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't create a GET request for %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		for _, header := range headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err := js.httpClient().Do(req)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		defer resp.Body.Close()
+
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		switch {
+		case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			// nothing left to fetch
+			return responseObject(map[string]int64{"bytes": offset, "total": offset})
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			return errorObject(nil, fmt.Sprintf("http.download(%q, %q), %s, %s", uri, fname, resp.Status, call.CallerLocation()))
+		case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+			flags = os.O_WRONLY | os.O_APPEND
+		default:
+			// the server sent the whole file
+			offset = 0
+		}
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+
+		fp, err := os.OpenFile(fname, flags, 0664)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("http.download(%q, %q), %s, %s", uri, fname, call.CallerLocation(), err))
+		}
+		defer fp.Close()
+		written := offset
+		buf := make([]byte, 32*1024)
+		for {
+			n, rerr := resp.Body.Read(buf)
+			if n > 0 {
+				if _, err := fp.Write(buf[:n]); err != nil {
+					return errorObject(nil, fmt.Sprintf("http.download(%q, %q), %s, %s", uri, fname, call.CallerLocation(), err))
+				}
+				written += int64(n)
+				if onProgress.IsFunction() == true {
+					progress, _ := js.VM.Object(`({})`)
+					progress.Set("bytes", written)
+					if total >= 0 {
+						progress.Set("total", total)
+					} else {
+						progress.Set("total", otto.NullValue())
+					}
+					if _, err := onProgress.Call(otto.UndefinedValue(), progress); err != nil {
+						return errorObject(nil, fmt.Sprintf("http.download(%q, %q), onProgress failed, %s, %s", uri, fname, call.CallerLocation(), err))
+					}
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return errorObject(nil, fmt.Sprintf("http.download(%q, %q), %s, %s", uri, fname, call.CallerLocation(), rerr))
+			}
+		}
+		result := map[string]interface{}{"bytes": written, "total": total}
+		if total < 0 {
+			// the size isn't known without a Content-Length, the help and onProgress say null
+			result["total"] = nil
+		}
+		return responseObject(result)
+	})
 
-	osObj, _ := js.VM.Object(`os = {}`)
-
-	// os.args() returns an array of command line args after flag.Parse() has occurred.
-	osObj.Set("args", func(call otto.FunctionCall) otto.Value {
-		var args []string
-		if flag.Parsed() == true {
-			args = flag.Args()
-		} else {
-			args = os.Args
+	// http.stream(uri, onEvent, options) reads a Server-Sent-Events response calling onEvent({event, data, id}) for each
+	// event. It returns a handle with a stop() method, callbacks run when the VM's event loop is pumped (see Loop).
+	httpObj.Set("stream", func(call otto.FunctionCall) otto.Value {
+		var options struct {
+			Headers headerList `json:"headers"`
 		}
-		results, _ := js.VM.ToValue(args)
-		return results
+
+		uri := call.Argument(0).String()
+		onEvent := call.Argument(1)
+		if onEvent.IsFunction() == false {
+			return errorObject(nil, fmt.Sprintf("http.stream(%q, onEvent), onEvent is not a function, %s", uri, call.CallerLocation()))
+		}
+		if len(call.ArgumentList) > 2 {
+			rawObjs, err := call.Argument(2).Export()
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to process options, %s, %s, %s", call.CallerLocation(), uri, err))
+			}
+			src, _ := json.Marshal(rawObjs)
+			err = json.Unmarshal(src, &options)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("Failed to translate options, %s, %s, %s", call.CallerLocation(), uri, err))
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			cancel()
+			return errorObject(nil, fmt.Sprintf("Can't create a GET request for %s, %s, %s", uri, call.CallerLocation(), err))
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", "text/event-stream")
+		for _, header := range options.Headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+
+		location := call.CallerLocation()
+		op := js.startOp("http.stream", uri, cancel)
+		// the client is made here, http.setMock and friends change its settings on the VM's goroutine
+		client := js.httpClient()
+		go func() {
+			resp, err := client.Do(req)
+			if err != nil {
+				js.post(op, func() {
+					errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, location, err))
+				})
+			} else {
+				defer resp.Body.Close()
+				event := map[string]string{"event": "message", "data": "", "id": ""}
+				var data []string
+				scanner := bufio.NewScanner(resp.Body)
+				scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), maxLineSize)
+				for scanner.Scan() {
+					line := scanner.Text()
+					if line == "" {
+						// A blank line dispatches the event
+						if len(data) > 0 {
+							event["data"] = strings.Join(data, "\n")
+							src, _ := json.Marshal(event)
+							if js.post(op, func() {
+								obj, _ := js.VM.Object(fmt.Sprintf(`(%s)`, src))
+								if _, err := onEvent.Call(otto.UndefinedValue(), obj); err != nil {
+									errorObject(nil, fmt.Sprintf("http.stream(%q, onEvent) callback error, %s, %s", uri, location, err))
+								}
+							}) == false {
+								return
+							}
+						}
+						event = map[string]string{"event": "message", "data": "", "id": event["id"]}
+						data = []string{}
+						continue
+					}
+					if strings.HasPrefix(line, ":") {
+						// comment line
+						continue
+					}
+					field, value := line, ""
+					if i := strings.Index(line, ":"); i > -1 {
+						field, value = line[0:i], strings.TrimPrefix(line[i+1:], " ")
+					}
+					switch field {
+					case "data":
+						data = append(data, value)
+					case "event", "id":
+						event[field] = value
+					}
+				}
+			}
+			js.post(op, func() {
+				js.finishOp(op)
+			})
+		}()
+
+		handle, _ := js.VM.Object(`({})`)
+		handle.Set("stop", func(call otto.FunctionCall) otto.Value {
+			js.stopOp(op)
+			result, _ := js.VM.ToValue(true)
+			return result
+		})
+		return handle.Value()
 	})
 
-	// os.exit()
-	osObj.Set("exit", func(call otto.FunctionCall) otto.Value {
-		exitCode := 0
-		if len(call.ArgumentList) >= 1 {
-			s := call.Argument(0).String()
-			exitCode, _ = strconv.Atoi(s)
+	// http.setMock(table) answers requests from table, an object whose keys are "METHOD URL" pointing at a body string
+	// or a {status, headers, body} object. No network requests are made while a mock is set, http.setMock(null) removes it.
+	httpObj.Set("setMock", func(call otto.FunctionCall) otto.Value {
+		table := call.Argument(0)
+		if table.IsNull() == true || table.IsUndefined() == true {
+			js.httpMock = nil
+			result, _ := js.VM.ToValue(true)
+			return result
 		}
-		if len(call.ArgumentList) == 2 {
-			log.Println(call.Argument(1).String())
+		if table.IsObject() == false {
+			return errorObject(nil, fmt.Sprintf("http.setMock(table), table is not an object, %s", call.CallerLocation()))
 		}
-		os.Exit(exitCode)
-		return responseObject(exitCode)
+		responses := make(map[string]httpMockResponse)
+		for _, key := range table.Object().Keys() {
+			val, _ := table.Object().Get(key)
+			if val.IsString() == true {
+				responses[key] = httpMockResponse{Status: http.StatusOK, Body: val.String()}
+				continue
+			}
+			canned := httpMockResponse{}
+			rawObj, err := val.Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObj)
+				err = json.Unmarshal(src, &canned)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("http.setMock(table), can't process %q, %s, %s", key, call.CallerLocation(), err))
+			}
+			responses[key] = canned
+		}
+		js.httpMock = &httpMock{responses: responses}
+		result, _ := js.VM.ToValue(true)
+		return result
 	})
 
-	// os.getEnv(env_varname) returns empty string or the value found as a string
-	osObj.Set("getEnv", func(call otto.FunctionCall) otto.Value {
-		envvar := call.Argument(0).String()
-		result, err := js.VM.ToValue(os.Getenv(envvar))
+	// http.record(filepath) makes real requests saving each response to filepath in the format http.setMock accepts
+	httpObj.Set("record", func(call otto.FunctionCall) otto.Value {
+		filename := call.Argument(0).String()
+		if len(call.ArgumentList) != 1 || filename == "" {
+			return errorObject(nil, fmt.Sprintf("http.record(filepath), missing filepath, %s", call.CallerLocation()))
+		}
+		next := js.transport()
+		js.httpMock = &httpMock{
+			responses: make(map[string]httpMockResponse),
+			recordTo:  filename,
+			next:      next,
+		}
+		result, _ := js.VM.ToValue(true)
+		return result
+	})
+}
+
+// addXLSXObject installs the xlsx object and the Workbook wrapper
+func (js *JavaScriptVM) addXLSXObject() {
+	errorObject, responseObject := js.errorObject, js.responseObject
+
+	// workbook wraps github.com/tealeg/xlsx library making it easy to read/write Excel xlsx files from Otto
+	workbook, _ := js.VM.Object(`xlsx = {}`)
+	// Workbook.read(filename, options) returns an object with properties of sheet names pointing at 2d-arrays of strings or error object.
+	// With options {formulas: true} each cell is an object, {value, formula}, formula is only present for cells with one.
+	workbook.Set("read", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) < 1 {
+			return errorObject(nil, fmt.Sprintf("xlxs.read(filename), error missing filename, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		xlWorkbook, err := xlsx.OpenFile(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.getEnv(%q), %s", call.CallerLocation(), envvar, err))
+			if src, e := ioutil.ReadFile(fname); e == nil && bytes.HasPrefix(src, cfbSignature) {
+				err = fmt.Errorf("the workbook is encrypted, use xlsx.readEncrypted(filename, password)")
+			}
+			return errorObject(nil, fmt.Sprintf("xlsx.read(%q), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		if boolOption(call.Argument(1), "fillMerged") == true {
+			fillMergedCells(xlWorkbook)
+		}
+		markup := workbookMarkup
+		if boolOption(call.Argument(1), "formulas") == true {
+			markup = formulaWorkbookMarkup
+		}
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", markup(xlWorkbook)))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.read(%q) error, %s, %s", fname, call.CallerLocation(), err))
 		}
 		return result
 	})
 
-	// os.setEnv(env_varname, value) sets the environment variable for the session, returns the value set.
-	osObj.Set("setEnv", func(call otto.FunctionCall) otto.Value {
-		envvar := call.Argument(0).String()
-		val := call.Argument(1).String()
-		err := os.Setenv(envvar, val)
+	// xlsx.readEncrypted(filename, password) returns an object like xlsx.read for a password protected workbook
+	workbook.Set("readEncrypted", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 2 {
+			return errorObject(nil, fmt.Sprintf("xlsx.readEncrypted(filename, password), error missing filename or password, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		src, err := ioutil.ReadFile(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.setEnv(%q, %q), %s", call.CallerLocation(), envvar, val, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readEncrypted(%q), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		result, err := js.VM.ToValue(os.Getenv(envvar))
+		src, err = decryptXLSX(src, call.Argument(1).String())
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.setEnv(%q, %q), %s", call.CallerLocation(), envvar, val, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readEncrypted(%q), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		xlWorkbook, err := xlsx.OpenBinary(src)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.readEncrypted(%q), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", workbookMarkup(xlWorkbook)))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.readEncrypted(%q) error, %s, %s", fname, call.CallerLocation(), err))
 		}
 		return result
 	})
 
-	// os.readFile(filepath) returns the content of the filepath or empty string
-	osObj.Set("readFile", func(call otto.FunctionCall) otto.Value {
-		filename := call.Argument(0).String()
-		buf, err := ioutil.ReadFile(filename)
+	// xlsx.readTyped(filename) returns an object like xlsx.read but cells keep their types, numbers, booleans and dates
+	workbook.Set("readTyped", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 1 {
+			return errorObject(nil, fmt.Sprintf("xlsx.readTyped(filename), error missing filename, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		xlWorkbook, err := xlsx.OpenFile(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.readFile(%q), %s", call.CallerLocation(), filename, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readTyped(%q), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		result, err := js.VM.ToValue(fmt.Sprintf("%s", buf))
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", typedWorkbookMarkup(xlWorkbook)))
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.readFile(%q), %s", call.CallerLocation(), filename, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readTyped(%q) error, %s, %s", fname, call.CallerLocation(), err))
 		}
 		return result
 	})
 
-	// os.writeFile(filepath, contents) returns true on sucess, false on failure
-	osObj.Set("writeFile", func(call otto.FunctionCall) otto.Value {
-		filename := call.Argument(0).String()
-		buf := call.Argument(1).String()
-		err := ioutil.WriteFile(filename, []byte(buf), 0660)
+	// xlsx.diff(fileA, fileB) returns the cells which differ between two workbooks as an array of {sheet, row, col, a, b}
+	workbook.Set("diff", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 2 {
+			return errorObject(nil, fmt.Sprintf("xlsx.diff(fileA, fileB), error missing fileA or fileB, %s", call.CallerLocation()))
+		}
+		fnameA, fnameB := call.Argument(0).String(), call.Argument(1).String()
+		wbA, err := xlsx.OpenFile(fnameA)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.writeFile(%q, %q), %s", call.CallerLocation(), filename, buf, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.diff(%q, %q), error %s, %s", fnameA, fnameB, call.CallerLocation(), err))
 		}
-		result, err := js.VM.ToValue(buf)
+		wbB, err := xlsx.OpenFile(fnameB)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.writeFile(%q, %q), %s", call.CallerLocation(), filename, buf, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.diff(%q, %q), error %s, %s", fnameA, fnameB, call.CallerLocation(), err))
+		}
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", workbookDiffMarkup(wbA, wbB)))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.diff(%q, %q) error, %s, %s", fnameA, fnameB, call.CallerLocation(), err))
 		}
 		return result
 	})
 
-	// os.rename(oldpath, newpath) renames a path returns an error object or true on success
-	osObj.Set("rename", func(call otto.FunctionCall) otto.Value {
-		oldpath := call.Argument(0).String()
-		newpath := call.Argument(1).String()
-		err := os.Rename(oldpath, newpath)
+	// xlsx.records(filename, sheetName) returns the rows of one sheet as an array of typed objects keyed by the first row
+	workbook.Set("records", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 2 {
+			return errorObject(nil, fmt.Sprintf("xlsx.records(filename, sheetName), error missing filename or sheetName, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		sheetName := call.Argument(1).String()
+		rows, err := readXLSXSheet(fname, sheetName)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.rename(%q, %q), %s", call.CallerLocation(), oldpath, newpath, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.records(%q, %q), error %s, %s", fname, sheetName, call.CallerLocation(), err))
+		}
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", recordsMarkup(rows)))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.records(%q, %q) error, %s, %s", fname, sheetName, call.CallerLocation(), err))
 		}
-		result, _ := js.VM.ToValue(true)
 		return result
 	})
 
-	// os.remove(filepath) returns an error object or true if successful
-	osObj.Set("remove", func(call otto.FunctionCall) otto.Value {
-		pathname := call.Argument(0).String()
-		fp, err := os.Open(pathname)
+	// xlsx.validateHeader(filename, sheetName, expectedColumns, options) compares the first row of a sheet to
+	// expectedColumns returning {valid, missing, extra}
+	workbook.Set("validateHeader", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) < 3 {
+			return errorObject(nil, fmt.Sprintf("xlsx.validateHeader(filename, sheetName, expectedColumns), error missing arguments, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		sheetName := call.Argument(1).String()
+		var expected []string
+		rawObj, err := call.Argument(2).Export()
+		if err == nil {
+			src, _ := json.Marshal(rawObj)
+			err = json.Unmarshal(src, &expected)
+		}
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.validateHeader(%q, %q), error %s, expectedColumns must be an array of strings, %s", fname, sheetName, call.CallerLocation(), err))
 		}
-		defer fp.Close()
-		stat, err := fp.Stat()
+		xlWorkbook, err := xlsx.OpenFile(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.validateHeader(%q, %q), error %s, %s", fname, sheetName, call.CallerLocation(), err))
 		}
-		result, _ := js.VM.ToValue(false)
-		if stat.IsDir() == false {
-			err := os.Remove(pathname)
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("%s os.remove(%q), %s", call.CallerLocation(), pathname, err))
+		sheet, ok := xlWorkbook.Sheet[sheetName]
+		if ok == false {
+			return errorObject(nil, fmt.Sprintf("xlsx.validateHeader(%q, %q), error %s, no sheet named %q", fname, sheetName, call.CallerLocation(), sheetName))
+		}
+		var header []string
+		if len(sheet.Rows) > 0 {
+			for _, cell := range sheet.Rows[0].Cells {
+				s, _ := cell.String()
+				header = append(header, strings.TrimSpace(s))
 			}
-			result, _ = js.VM.ToValue(true)
 		}
-		return result
+		return responseObject(compareHeader(header, expected, boolOption(call.Argument(3), "ordered")))
 	})
 
-	// os.chmod(filepath, perms) returns an error object or true if successful
-	osObj.Set("chmod", func(call otto.FunctionCall) otto.Value {
-		filename := call.Argument(0).String()
-		perms := call.Argument(1).String()
+	// xlsx.sheetNames(filename) returns an array of the sheet names in a workbook without reading the cells
+	workbook.Set("sheetNames", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 1 {
+			return errorObject(nil, fmt.Sprintf("xlsx.sheetNames(filename), error missing filename, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		names, err := readXLSXSheetNames(fname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.sheetNames(%q), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		return responseObject(names)
+	})
 
-		fp, err := os.Open(filename)
+	// xlsx.readRange(filename, sheetName, a1Range) returns a 2d-array of strings sized to the range (e.g. "A1:C10")
+	workbook.Set("readRange", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 3 {
+			return errorObject(nil, fmt.Sprintf("xlsx.readRange(filename, sheetName, a1Range), missing parameters, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		sheetName := call.Argument(1).String()
+		a1Range := call.Argument(2).String()
+		row1, col1, row2, col2, err := parseA1Range(a1Range)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readRange(%q, %q, %q), error %s, %s", fname, sheetName, a1Range, call.CallerLocation(), err))
 		}
-		defer fp.Close()
+		xlWorkbook, err := xlsx.OpenFile(fname)
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.readRange(%q, %q, %q), error %s, %s", fname, sheetName, a1Range, call.CallerLocation(), err))
+		}
+		sheet, ok := xlWorkbook.Sheet[sheetName]
+		if ok == false {
+			return errorObject(nil, fmt.Sprintf("xlsx.readRange(%q, %q, %q), error %s, sheet not found", fname, sheetName, a1Range, call.CallerLocation()))
+		}
+		return responseObject(sheetRange(sheet, row1, col1, row2, col2))
+	})
 
-		perm, err := strconv.ParseUint(perms, 10, 32)
+	// xlsx.readRich(filename) returns an object with the sheets (as xlsx.read), the cell comments and hyperlinks keyed by sheet name and A1 reference
+	workbook.Set("readRich", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 1 {
+			return errorObject(nil, fmt.Sprintf("xlsx.readRich(filename), error missing filename, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		xlWorkbook, err := xlsx.OpenFile(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readRich(%q), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		err = fp.Chmod(os.FileMode(perm))
+		comments, links, err := readXLSXAnnotations(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.chmod(%q, %s), %s", call.CallerLocation(), filename, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.readRich(%q), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		commentsSrc, _ := json.Marshal(comments)
+		linksSrc, _ := json.Marshal(links)
+		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return {sheets: %s, comments: %s, hyperlinks: %s};}());", workbookMarkup(xlWorkbook), commentsSrc, linksSrc))
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.readRich(%q) error, %s, %s", fname, call.CallerLocation(), err))
+		}
+		return result
+	})
+
+	// xlsx.validate(sheetsObject) returns true if each sheet is an array of arrays of scalars with
+	// rows of the same length, an error object naming the first problem otherwise
+	workbook.Set("validate", func(call otto.FunctionCall) otto.Value {
+		data, err := call.Argument(0).Export()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.validate(sheetsObject), error %s, %s", call.CallerLocation(), err))
+		}
+		var keys []string
+		if call.Argument(0).IsObject() == true {
+			keys = call.Argument(0).Object().Keys()
+		}
+		tables, err := workbookTables(data, keys)
+		if err == nil {
+			for _, sheetName := range sheetOrder(tables, keys) {
+				if err = raggedRow(sheetName, tables[sheetName]); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.validate(sheetsObject), %s, %s", call.CallerLocation(), err))
 		}
 		result, _ := js.VM.ToValue(true)
 		return result
 	})
 
-	// os.find(startpath) returns an array of path names
-	osObj.Set("find", func(call otto.FunctionCall) otto.Value {
-		var dirs []string
-		startpath := call.Argument(0).String()
-		err := filepath.Walk(startpath, func(p string, info os.FileInfo, err error) error {
-			dirs = append(dirs, p)
-			return err
-		})
+	// xlsx.append(filename, sheetName, rows) adds rows to the end of a sheet, creating the workbook and sheet as needed
+	workbook.Set("append", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 3 {
+			return errorObject(nil, fmt.Sprintf("xlsx.append(filename, sheetName, rows), missing parameters, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		sheetName := call.Argument(1).String()
+		data, err := call.Argument(2).Export()
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.find(%q), %s", call.CallerLocation(), startpath, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.append(%q, %q, rows), error %s, %s", fname, sheetName, call.CallerLocation(), err))
 		}
-		result, err := js.VM.ToValue(dirs)
+		table, err := sheetTable(sheetName, data)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.find(%q), %s", call.CallerLocation(), startpath, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.append(%q, %q, rows), %s, %s", fname, sheetName, call.CallerLocation(), err))
 		}
+		var file *xlsx.File
+		if _, err := os.Stat(fname); os.IsNotExist(err) == true {
+			file = xlsx.NewFile()
+		} else {
+			file, err = xlsx.OpenFile(fname)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("xlsx.append(%q, %q, rows), error %s, %s", fname, sheetName, call.CallerLocation(), err))
+			}
+		}
+		sheet, ok := file.Sheet[sheetName]
+		if ok == false {
+			sheet, err = file.AddSheet(sheetName)
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("xlsx.append(%q, %q, rows), can't add sheet %s, %s", fname, sheetName, call.CallerLocation(), err))
+			}
+		}
+		for _, tr := range table {
+			row := sheet.AddRow()
+			for _, td := range tr {
+				cell := row.AddCell()
+				cell.Value = td
+			}
+		}
+		if err := file.Save(fname); err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.append(%q, %q, rows), error %s, %s", fname, sheetName, call.CallerLocation(), err))
+		}
+		result, _ := js.VM.ToValue(true)
 		return result
 	})
 
-	// os.mkdir(pathname, perms) return an error object or true
-	osObj.Set("mkdir", func(call otto.FunctionCall) otto.Value {
-		newpath := call.Argument(0).String()
-		perms := call.Argument(1).String()
+	// Workbook.write(filename, sheetObject) returns true on success, false otherwise. sheetObject should have properties of sheet names pointing at a 2d array of strings
+	workbook.Set("write", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) < 2 {
+			return errorObject(nil, fmt.Sprintf("xlsx.write(filename, sheetsObject), missing parameters, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		sheets := call.Argument(1)
+		// a Workbook object (see xlsx.New) is written from the sheets it wraps
+		if sheets.IsObject() == true {
+			if wrapped, err := sheets.Object().Get("__data"); err == nil && wrapped.IsObject() == true {
+				sheets = wrapped
+			}
+		}
+		data, err := sheets.Export()
+		if err != nil {
+			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		var (
+			file *xlsx.File
+			keys []string
+		)
 
-		perm, err := strconv.ParseUint(perms, 10, 32)
+		// Add the sheets in the order of the object's properties so the output is stable
+		if sheets.IsObject() == true {
+			keys = sheets.Object().Keys()
+		}
+		tables, err := workbookTables(data, keys)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
+		}
+		file = xlsx.NewFile()
+		for _, sheetName := range sheetOrder(tables, keys) {
+			table := tables[sheetName]
+			sheet, err := file.AddSheet(sheetName)
+			if err != nil {
+				log.Printf("%s, can't add sheet %s, %s", fname, sheetName, err)
+			} else {
+				for _, tr := range table {
+					row := sheet.AddRow()
+					for _, td := range tr {
+						cell := row.AddCell()
+						cell.Value = td
+					}
+				}
+			}
+		}
+		options := xlsxWriteOptions{}
+		if len(call.ArgumentList) > 2 {
+			rawObjs, err := call.Argument(2).Export()
+			if err == nil {
+				src, _ := json.Marshal(rawObjs)
+				err = json.Unmarshal(src, &options)
+			}
+			if err != nil {
+				return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject, options), can't process options %s, %s", fname, call.CallerLocation(), err))
+			}
+			if err := options.apply(file); err != nil {
+				return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject, options), %s, %s", fname, call.CallerLocation(), err))
+			}
 		}
-		err = os.Mkdir(newpath, os.FileMode(perm))
+		err = file.Save(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
 		}
-
-		result, _ := js.VM.ToValue(true)
-		return result
-	})
-
-	// os.mkdir(pathname, perms) return an error object or true
-	osObj.Set("mkdirAll", func(call otto.FunctionCall) otto.Value {
-		newpath := call.Argument(0).String()
-		perms := call.Argument(1).String()
-
-		perm, err := strconv.ParseUint(perms, 10, 32)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+		if index := options.activeTab(file); index > 0 {
+			if err := setActiveTab(fname, index); err != nil {
+				return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject, options), can't set the active sheet %s, %s", fname, call.CallerLocation(), err))
+			}
 		}
-		err = os.MkdirAll(newpath, os.FileMode(perm))
+		result, err := js.VM.ToValue(true)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.mkdir(%q, %s), %s", call.CallerLocation(), newpath, perms, err))
+			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject) error, %s, %s", fname, call.CallerLocation(), err))
 		}
-		result, _ := js.VM.ToValue(true)
 		return result
 	})
+	script, err := js.VM.Compile("workbookfill", Workbookfill)
+	if err != nil {
+		log.Fatalf("Workbookfill compile error: %s\n\n%s\n", err, Workbookfill)
+	}
+	js.VM.Eval(script)
+}
 
-	// os.rmdir(pathname) returns an error object or true if successful
-	osObj.Set("rmdir", func(call otto.FunctionCall) otto.Value {
-		pathname := call.Argument(0).String()
-		// NOTE: make sure this is a directory and not a file
-		fp, err := os.Open(pathname)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
+// addODSObject installs the ods object
+func (js *JavaScriptVM) addODSObject() {
+	errorObject, responseObject := js.errorObject, js.responseObject
+
+	odsObj, _ := js.VM.Object(`ods = {}`)
+
+	// ods.read(filename) returns an object with properties of sheet names pointing at 2d-arrays of strings or error object
+	odsObj.Set("read", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 1 {
+			return errorObject(nil, fmt.Sprintf("ods.read(filename), error missing filename, %s", call.CallerLocation()))
 		}
-		defer fp.Close()
-		stat, err := fp.Stat()
+		fname := call.Argument(0).String()
+		names, tables, err := readODS(fname)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
+			return errorObject(nil, fmt.Sprintf("ods.read(%q), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		result, _ := js.VM.ToValue(false)
-		if stat.IsDir() == true {
-			err := os.Remove(pathname)
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("%s os.rmdir(%q), %s", call.CallerLocation(), pathname, err))
-			}
-			result, _ = js.VM.ToValue(true)
+		sheets := &orderedObject{}
+		for _, name := range names {
+			sheets.Set(name, tables[name])
 		}
-		return result
+		return responseObject(sheets)
 	})
 
-	// os.rmdirAll(pathname) returns an error object or true if successful
-	osObj.Set("rmdirAll", func(call otto.FunctionCall) otto.Value {
-		pathname := call.Argument(0).String()
-		// NOTE: make sure this is a directory and not a file
-		fp, err := os.Open(pathname)
+	// ods.write(filename, sheetsObject) writes the sheets, 2d-arrays of cells, as an OpenDocument spreadsheet
+	odsObj.Set("write", func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) != 2 {
+			return errorObject(nil, fmt.Sprintf("ods.write(filename, sheetsObject), missing parameters, %s", call.CallerLocation()))
+		}
+		fname := call.Argument(0).String()
+		data, err := call.Argument(1).Export()
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
+			return errorObject(nil, fmt.Sprintf("ods.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		defer fp.Close()
-		stat, err := fp.Stat()
+		var keys []string
+		if call.Argument(1).IsObject() == true {
+			keys = call.Argument(1).Object().Keys()
+		}
+		tables, err := workbookTables(data, keys)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
+			return errorObject(nil, fmt.Sprintf("ods.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
 		}
-		result, _ := js.VM.ToValue(false)
-		if stat.IsDir() == true {
-			err := os.RemoveAll(pathname)
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("%s os.rmdirAll(%q), %s", call.CallerLocation(), pathname, err))
-			}
-			result, _ = js.VM.ToValue(true)
+		if err := writeODS(fname, sheetOrder(tables, keys), tables); err != nil {
+			return errorObject(nil, fmt.Sprintf("ods.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
 		}
+		result, _ := js.VM.ToValue(true)
 		return result
 	})
+}
 
-	httpObj, _ := js.VM.Object(`http = {}`)
+// odsMimeType is the content of the mimetype file which starts an OpenDocument spreadsheet
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
 
-	// http.Get(uri, headers) returns contents recieved (if any)
-	httpObj.Set("get", func(call otto.FunctionCall) otto.Value {
-		var headers []map[string]string
+// readODS reads the sheets of an OpenDocument spreadsheet returning their names in order and their
+// cells' text by name. Repeated rows and cells are expanded but trailing empty ones (which
+// LibreOffice repeats to the edge of the sheet) are dropped, annotations are skipped.
+func readODS(fname string) ([]string, map[string][][]string, error) {
+	r, err := zip.OpenReader(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	var content *zip.File
+	for _, f := range r.File {
+		if f.Name == "content.xml" {
+			content = f
+		}
+	}
+	if content == nil {
+		return nil, nil, fmt.Errorf("content.xml not found, not an OpenDocument spreadsheet")
+	}
+	fp, err := content.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fp.Close()
 
-		uri := call.Argument(0).String()
-		if len(call.ArgumentList) > 1 {
-			rawObjs, err := call.Argument(1).Export()
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("Failed to process headers, %s, %s, %s", call.CallerLocation(), uri, err))
-			}
-			src, _ := json.Marshal(rawObjs)
-			err = json.Unmarshal(src, &headers)
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("Failed to translate headers, %s, %s, %s", call.CallerLocation(), uri, err))
+	var (
+		names                       []string
+		sheetName                   string
+		sheet                       [][]string
+		row                         []string
+		cell                        *bytes.Buffer
+		rowRepeat, cellRepeat       int
+		emptyRows, emptyCells       int
+		paragraphs, annotationDepth int
+	)
+	tables := make(map[string][][]string)
+	// repeat returns the value of the repeat attribute named, 1 if it is missing
+	repeat := func(el xml.StartElement, name string) int {
+		for _, attr := range el.Attr {
+			if attr.Name.Local == name {
+				if n, err := strconv.Atoi(attr.Value); err == nil && n > 0 {
+					return n
+				}
 			}
 		}
-
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", uri, nil)
+		return 1
+	}
+	decoder := xml.NewDecoder(fp)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("Can't create a GET request for %s, %s, %s", uri, call.CallerLocation(), err))
+			return nil, nil, err
 		}
-		for _, header := range headers {
-			for k, v := range header {
-				req.Header.Set(k, v)
+		switch el := token.(type) {
+		case xml.StartElement:
+			if annotationDepth > 0 || el.Name.Local == "annotation" {
+				annotationDepth++
+				continue
+			}
+			switch el.Name.Local {
+			case "table":
+				sheetName, sheet, emptyRows = "", [][]string{}, 0
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "name" {
+						sheetName = attr.Value
+					}
+				}
+			case "table-row":
+				row, emptyCells, rowRepeat = []string{}, 0, repeat(el, "number-rows-repeated")
+			case "table-cell", "covered-table-cell":
+				cell, paragraphs, cellRepeat = new(bytes.Buffer), 0, repeat(el, "number-columns-repeated")
+			case "p":
+				if cell != nil && paragraphs > 0 {
+					cell.WriteString("\n")
+				}
+				paragraphs++
+			case "s":
+				if cell != nil {
+					cell.WriteString(strings.Repeat(" ", repeat(el, "c")))
+				}
+			case "tab":
+				if cell != nil {
+					cell.WriteString("\t")
+				}
+			case "line-break":
+				if cell != nil {
+					cell.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if cell != nil && annotationDepth == 0 && paragraphs > 0 {
+				cell.Write(el)
+			}
+		case xml.EndElement:
+			if annotationDepth > 0 {
+				annotationDepth--
+				continue
+			}
+			switch el.Name.Local {
+			case "table-cell", "covered-table-cell":
+				if cell == nil {
+					continue
+				}
+				if cell.Len() == 0 {
+					emptyCells += cellRepeat
+				} else {
+					for ; emptyCells > 0; emptyCells-- {
+						row = append(row, "")
+					}
+					for i := 0; i < cellRepeat; i++ {
+						row = append(row, cell.String())
+					}
+				}
+				cell = nil
+			case "table-row":
+				if len(row) == 0 {
+					emptyRows += rowRepeat
+				} else {
+					for ; emptyRows > 0; emptyRows-- {
+						sheet = append(sheet, []string{})
+					}
+					for i := 0; i < rowRepeat; i++ {
+						sheet = append(sheet, append([]string{}, row...))
+					}
+				}
+			case "table":
+				if _, ok := tables[sheetName]; ok == false {
+					names = append(names, sheetName)
+				}
+				tables[sheetName] = sheet
 			}
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
-		}
-		defer resp.Body.Close()
-		content, err := ioutil.ReadAll(resp.Body)
+	}
+	return names, tables, nil
+}
 
-		result, err := js.VM.ToValue(fmt.Sprintf("%s", content))
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("http.get(%q, headers) error, %s, %s", uri, call.CallerLocation(), err))
+// odsText returns s as the text:p elements of a cell, runs of spaces, tabs and new lines are
+// written with text:s, text:tab and separate paragraphs so they are kept
+func odsText(s string) string {
+	buf := new(bytes.Buffer)
+	for _, line := range strings.Split(s, "\n") {
+		buf.WriteString("<text:p>")
+		afterSpace := true
+		for _, r := range line {
+			switch {
+			case r == ' ' && afterSpace == true:
+				buf.WriteString("<text:s/>")
+			case r == '\t':
+				buf.WriteString("<text:tab/>")
+			default:
+				xml.EscapeText(buf, []byte(string(r)))
+			}
+			afterSpace = (r == ' ')
 		}
-		return result
-	})
-
-	// HttpPost(uri, headers, payload) returns contents recieved (if any)
-	httpObj.Set("post", func(call otto.FunctionCall) otto.Value {
-		var headers []map[string]string
+		buf.WriteString("</text:p>")
+	}
+	return buf.String()
+}
 
-		uri := call.Argument(0).String()
-		mimeType := call.Argument(1).String()
-		payload := call.Argument(2).String()
-		buf := strings.NewReader(payload)
-		// Process any additional headers past to http.Post()
-		if len(call.ArgumentList) > 2 {
-			rawObjs, err := call.Argument(3).Export()
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("Failed to process headers for %s, %s, %s", uri, call.CallerLocation(), err))
+// writeODS saves tables as an OpenDocument spreadsheet with a sheet for each of names, the cells are strings
+func writeODS(fname string, names []string, tables map[string][][]string) error {
+	content := new(bytes.Buffer)
+	content.WriteString(xml.Header)
+	content.WriteString(`<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" office:version="1.2"><office:body><office:spreadsheet>`)
+	for _, name := range names {
+		content.WriteString(`<table:table table:name="`)
+		xml.EscapeText(content, []byte(name))
+		content.WriteString(`">`)
+		for _, tr := range tables[name] {
+			content.WriteString("<table:table-row>")
+			for _, td := range tr {
+				if td == "" {
+					content.WriteString("<table:table-cell/>")
+					continue
+				}
+				content.WriteString(`<table:table-cell office:value-type="string">` + odsText(td) + "</table:table-cell>")
 			}
-			src, _ := json.Marshal(rawObjs)
-			err = json.Unmarshal(src, &headers)
-			if err != nil {
-				return errorObject(nil, fmt.Sprintf("Failed to translate header for %s, %s, %s", uri, call.CallerLocation(), err))
+			if len(tr) == 0 {
+				// a row needs at least one cell
+				content.WriteString("<table:table-cell/>")
 			}
+			content.WriteString("</table:table-row>")
 		}
+		if len(tables[name]) == 0 {
+			// a table needs at least one row
+			content.WriteString("<table:table-row><table:table-cell/></table:table-row>")
+		}
+		content.WriteString("</table:table>")
+	}
+	content.WriteString("</office:spreadsheet></office:body></office:document-content>")
 
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", uri, buf)
+	manifest := xml.Header + `<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">` +
+		`<manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="` + odsMimeType + `"/>` +
+		`<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>` +
+		`</manifest:manifest>`
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	// the mimetype must come first and be stored uncompressed
+	parts := []struct {
+		name   string
+		method uint16
+		src    []byte
+	}{
+		{"mimetype", zip.Store, []byte(odsMimeType)},
+		{"META-INF/manifest.xml", zip.Deflate, []byte(manifest)},
+		{"content.xml", zip.Deflate, content.Bytes()},
+	}
+	for _, part := range parts {
+		fp, err := w.CreateHeader(&zip.FileHeader{Name: part.name, Method: part.method})
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("Can't create a POST request for %s, %s, %s", uri, call.CallerLocation(), err))
+			return err
 		}
-		req.Header.Set("Content-Type", mimeType)
-		for _, header := range headers {
-			for k, v := range header {
-				req.Header.Set(k, v)
+		if _, err := fp.Write(part.src); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, buf.Bytes(), 0664)
+}
+
+// Eval evaluate some JavaScript source code
+func (js *JavaScriptVM) Eval(script string) (otto.Value, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.eval(script)
+}
+
+// MustEval compiles and evaluates src, named name in error messages, returning its value. It panics
+// with an error naming the script if src fails to compile or run, it is meant for small programs
+// that treat a failed eval as fatal, use Eval to handle the error instead
+func (js *JavaScriptVM) MustEval(name, src string) otto.Value {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	script, err := js.VM.Compile(name, src)
+	if err != nil {
+		panic(fmt.Errorf("%s, %s", name, err))
+	}
+	val, err := js.eval(script)
+	if err != nil {
+		panic(fmt.Errorf("%s, %s", name, err))
+	}
+	return val
+}
+
+// EvalToString evaluates src returning its value as a string
+func (js *JavaScriptVM) EvalToString(src string) (string, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	val, err := js.eval(src)
+	if err != nil {
+		return "", err
+	}
+	return val.ToString()
+}
+
+// EvalToInt evaluates src returning its value as an integer
+func (js *JavaScriptVM) EvalToInt(src string) (int64, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	val, err := js.eval(src)
+	if err != nil {
+		return 0, err
+	}
+	return val.ToInteger()
+}
+
+// EvalToFloat evaluates src returning its value as a float64
+func (js *JavaScriptVM) EvalToFloat(src string) (float64, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	val, err := js.eval(src)
+	if err != nil {
+		return 0, err
+	}
+	return val.ToFloat()
+}
+
+// EvalToBool evaluates src returning its value as a bool (following JavaScript's truthiness)
+func (js *JavaScriptVM) EvalToBool(src string) (bool, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	val, err := js.eval(src)
+	if err != nil {
+		return false, err
+	}
+	return val.ToBoolean()
+}
+
+// MapInputs evaluates scriptSrc once per input using workers clones of the VM in parallel. The input is
+// available to the script as the global variable input, results are the exported values in input order.
+// All inputs are processed, failures are returned as a combined error.
+func (js *JavaScriptVM) MapInputs(inputs []string, scriptSrc string, workers int) ([]interface{}, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	js.mu.Lock()
+	_, err := js.VM.Compile("MapInputs", scriptSrc)
+	js.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(inputs))
+	errs := make([]error, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		clone := js.Clone()
+		wg.Add(1)
+		go func(vm *JavaScriptVM) {
+			defer wg.Done()
+			// Each VM gets its own compiled copy of the script
+			script, _ := vm.VM.Compile("MapInputs", scriptSrc)
+			for i := range jobs {
+				if err := vm.VM.Set("input", inputs[i]); err != nil {
+					errs[i] = err
+					continue
+				}
+				val, err := vm.VM.Eval(script)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = val.Export()
 			}
+		}(clone)
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s, %s", inputs[i], err))
 		}
-		resp, err := client.Do(req)
+	}
+	if len(msgs) > 0 {
+		return results, fmt.Errorf("%d of %d inputs failed, %s", len(msgs), len(inputs), strings.Join(msgs, "; "))
+	}
+	return results, nil
+}
+
+// NamedSource is a piece of JavaScript source code with a name used when reporting errors
+type NamedSource struct {
+	Name   string `xml:"name" json:"name"`
+	Source string `xml:"source" json:"source"`
+}
+
+// Result is the outcome of evaluating a NamedSource, Value is the exported result
+type Result struct {
+	Name  string      `xml:"name" json:"name"`
+	Value interface{} `xml:"value" json:"value"`
+	Error error       `xml:"-" json:"-"`
+}
+
+// EvalBatch evaluates each source in turn collecting a Result for each, it does not stop at the first error
+func (js *JavaScriptVM) EvalBatch(sources []NamedSource) []Result {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var results []Result
+	for _, src := range sources {
+		result := Result{Name: src.Name}
+		script, err := js.VM.Compile(src.Name, src.Source)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("Can't connect to %s, %s, %s", uri, call.CallerLocation(), err))
+			result.Error = fmt.Errorf("%s, %s", src.Name, err)
+			results = append(results, result)
+			continue
+		}
+		val, err := js.evalHalting(script)
+		if err == errExited {
+			// the rest of the batch isn't run once a source calls os.exit()
+			results = append(results, result)
+			break
 		}
-		defer resp.Body.Close()
-		content, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("Can't read response %s, %s, %s", uri, call.CallerLocation(), err))
+			result.Error = fmt.Errorf("%s, %s", src.Name, err)
+			results = append(results, result)
+			continue
 		}
-		result, err := js.VM.ToValue(fmt.Sprintf("%s", content))
+		result.Value, err = val.Export()
 		if err != nil {
-			return errorObject(nil, fmt.Sprintf("http.post(%q, headers, payload) error, %s, %s", uri, call.CallerLocation(), err))
+			result.Error = fmt.Errorf("%s, %s", src.Name, err)
 		}
-		return result
-	})
+		results = append(results, result)
+	}
+	return results
+}
 
-	// workbook wraps github.com/tealeg/xlsx library making it easy to read/write Excel xlsx files from Otto
-	workbook, _ := js.VM.Object(`xlsx = {}`)
-	// Workbook.read(filename) returns an object with properties of sheet names pointing at 2d-arrays of strings or error object
-	workbook.Set("read", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) != 1 {
-			return errorObject(nil, fmt.Sprintf("xlxs.read(filename), error missing filename, %s", call.CallerLocation()))
-		}
-		fname := call.Argument(0).String()
-		xlWorkbook, err := xlsx.OpenFile(fname)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("xlsx.read(%q), error %s, %s", fname, call.CallerLocation(), err))
+// startOp records a new background operation, cancel is called when it is stopped
+func (js *JavaScriptVM) startOp(kind, target string, cancel func()) *backgroundOp {
+	js.nextOpID++
+	op := &backgroundOp{
+		id:     js.nextOpID,
+		kind:   kind,
+		target: target,
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	js.ops[op.id] = op
+	return op
+}
+
+// afterOp starts an operation which runs fn on the VM's goroutine once delay has passed, unless it
+// is stopped first. fn only runs while the event loop is pumped (see Loop).
+func (js *JavaScriptVM) afterOp(kind string, delay time.Duration, fn func()) *backgroundOp {
+	timer := time.NewTimer(delay)
+	op := js.startOp(kind, delay.String(), func() {
+		timer.Stop()
+	})
+	go func() {
+		select {
+		case <-timer.C:
+			js.post(op, func() {
+				js.finishOp(op)
+				fn()
+			})
+		case <-op.done:
 		}
-		var markup []string
+	}()
+	return op
+}
 
-		// Start Workbook object markup
-		markup = append(markup, fmt.Sprintf("{"))
-		for i, sheet := range xlWorkbook.Sheets {
-			if i > 0 {
-				markup = append(markup, fmt.Sprintf(","))
-			}
-			// Start a sheet with sheetNameString
-			markup = append(markup, fmt.Sprintf("%q:[", sheet.Name))
-			for j, row := range sheet.Rows {
-				if j > 0 {
-					markup = append(markup, fmt.Sprintf(","))
-				}
-				// Start Row of cells
-				markup = append(markup, fmt.Sprintf("["))
-				for k, cell := range row.Cells {
-					if k > 0 {
-						markup = append(markup, fmt.Sprintf(","))
-					}
-					//NOTE: could use cell.Type() to convert to JS formatted values instead of forcing to a string
-					s, _ := cell.String()
-					markup = append(markup, fmt.Sprintf("%q", s))
-				}
-				// Close Row of cells
-				markup = append(markup, fmt.Sprintf("]"))
-			}
-			// Close a sheet
-			markup = append(markup, fmt.Sprintf("]"))
+// post is called from an operation's goroutine to queue fn for the VM's goroutine. It returns
+// false without queuing fn once the operation has been stopped.
+func (js *JavaScriptVM) post(op *backgroundOp, fn func()) bool {
+	select {
+	case js.events <- func() {
+		if op.finished == false {
+			fn()
 		}
-		// End Workbook object markup
-		markup = append(markup, fmt.Sprintf("}"))
-		result, err := js.VM.Eval(fmt.Sprintf("(function (){ return %s;}());", strings.Join(markup, "")))
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("xlsx.read(%q) error, %s, %s", fname, call.CallerLocation(), err))
+	}:
+		return true
+	case <-op.done:
+		return false
+	}
+}
+
+// finishOp is called on the VM's goroutine when an operation has completed
+func (js *JavaScriptVM) finishOp(op *backgroundOp) {
+	if op.finished == false {
+		op.finished = true
+		delete(js.ops, op.id)
+		select {
+		case js.opsDone <- struct{}{}:
+		default:
 		}
-		return result
+	}
+}
+
+// stopOp cancels an operation from the VM's goroutine
+func (js *JavaScriptVM) stopOp(op *backgroundOp) {
+	if op.finished == true {
+		return
+	}
+	close(op.done)
+	if op.cancel != nil {
+		op.cancel()
+	}
+	js.finishOp(op)
+}
+
+// ActiveOps returns the background operations (e.g. http.stream or util.debounce timers) still
+// active, in the order they were started
+func (js *JavaScriptVM) ActiveOps() []OpInfo {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	ops := []OpInfo{}
+	for _, op := range js.ops {
+		ops = append(ops, OpInfo{ID: op.id, Kind: op.kind, Target: op.target})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].ID < ops[j].ID
 	})
+	return ops
+}
 
-	// Workbook.write(filename, sheetObject) returns true on success, false otherwise. sheetObject should have properties of sheet names pointing at a 2d array of strings
-	workbook.Set("write", func(call otto.FunctionCall) otto.Value {
-		if len(call.ArgumentList) != 2 {
-			return errorObject(nil, fmt.Sprintf("xlsx.write(filename, sheetsObject), missing parameters, %s", call.CallerLocation()))
+// CancelOp stops the active background operation with id (see ActiveOps)
+func (js *JavaScriptVM) CancelOp(id int) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	op, ok := js.ops[id]
+	if ok == false {
+		return fmt.Errorf("no active operation %d", id)
+	}
+	js.stopOp(op)
+	return nil
+}
+
+// Loop runs the callbacks of background operations (e.g. http.stream) on the calling goroutine
+// until no operations remain active. Run calls Loop after evaluating a script. The VM is only
+// locked while a callback runs so other callers (e.g. Eval) can use it between callbacks.
+func (js *JavaScriptVM) Loop() {
+	js.loop()
+}
+
+// loop runs the callbacks like Loop, it returns true if one was stopped by os.exit()
+func (js *JavaScriptVM) loop() bool {
+	for {
+		js.mu.Lock()
+		active := len(js.ops)
+		js.mu.Unlock()
+		if active == 0 {
+			return false
 		}
-		fname := call.Argument(0).String()
-		data, err := call.Argument(1).Export()
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
+		select {
+		case fn := <-js.events:
+			if js.runEvent(fn) == true {
+				return true
+			}
+		case <-js.opsDone:
+			// an operation was stopped elsewhere, check whether any remain
 		}
-		var file *xlsx.File
+	}
+}
 
-		file = xlsx.NewFile()
-		for sheetName, table := range data.(map[string]interface{}) {
-			sheet, err := file.AddSheet(sheetName)
-			if err != nil {
-				log.Printf("%s, can't add sheet %s, %s", fname, sheetName, err)
-			} else {
-				for _, tr := range table.([][]string) {
-					row := sheet.AddRow()
-					for _, td := range tr {
-						cell := row.AddCell()
-						cell.Value = td
-					}
-				}
+// runEvent runs a background operation's callback holding js.mu, it returns true if the callback
+// was stopped by os.exit()
+func (js *JavaScriptVM) runEvent(fn func()) (exited bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	defer func() {
+		if caught := recover(); caught != nil {
+			if halt, ok := caught.(haltError); ok == true && halt.err == errExited {
+				exited = true
+				return
 			}
+			panic(caught)
 		}
-		err = file.Save(fname)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject), error %s, %s", fname, call.CallerLocation(), err))
-		}
-		result, err := js.VM.ToValue(true)
-		if err != nil {
-			return errorObject(nil, fmt.Sprintf("xlsx.write(%q, sheetsObject) error, %s, %s", fname, call.CallerLocation(), err))
+	}()
+	fn()
+	return false
+}
+
+// runPending runs the callbacks of background operations which are ready without waiting for
+// more, the repl calls it before each prompt so streams and timers started there deliver. It
+// returns true if a callback was stopped by os.exit().
+func (js *JavaScriptVM) runPending() bool {
+	for {
+		select {
+		case fn := <-js.events:
+			if js.runEvent(fn) == true {
+				return true
+			}
+		default:
+			return false
 		}
-		return result
-	})
-	script, err := js.VM.Compile("workbookfill", Workbookfill)
-	if err != nil {
-		log.Fatalf("Workbookfill compile error: %s\n\n%s\n", err, Workbookfill)
 	}
-	js.VM.Eval(script)
+}
 
-	script, err = js.VM.Compile("polyfill", Polyfill)
-	if err != nil {
-		log.Fatalf("polyfill compile error: %s\n\n%s\n", err, Polyfill)
+// Close stops the background operations (e.g. http.stream) still running, releases the locks held
+// with os.lockFile and closes the idle connections of the transport used by the http object. Call it
+// once scripts have finished.
+func (js *JavaScriptVM) Close() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, op := range js.ops {
+		js.stopOp(op)
 	}
-	js.VM.Eval(script)
-	return js.VM
+	var errs []string
+	for handle, fname := range js.locks {
+		if err := os.Remove(fname); err != nil && os.IsNotExist(err) == false {
+			errs = append(errs, err.Error())
+		}
+		delete(js.locks, handle)
+	}
+	// a transport set with SetHTTPTransport is closed too, http.DefaultTransport is left to its other users
+	transport := js.httpTransport
+	if transport == nil {
+		transport = js.ownTransport
+	}
+	if transport == http.DefaultTransport {
+		transport = nil
+	}
+	if closer, ok := transport.(interface {
+		CloseIdleConnections()
+	}); ok == true {
+		closer.CloseIdleConnections()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("can't release locks, %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
-// Eval evaluate some JavaScript source code
-func (js *JavaScriptVM) Eval(script string) (otto.Value, error) {
-	return js.VM.Eval(script)
+// stripShebang turns a leading "#!" line (e.g. #!/usr/bin/env ottomatic) into a comment so the
+// script compiles, the line is kept so error messages have the right line numbers
+func stripShebang(src []byte) []byte {
+	if bytes.HasPrefix(src, []byte("#!")) == false {
+		return src
+	}
+	out := make([]byte, len(src))
+	copy(out, src)
+	out[0], out[1] = '/', '/'
+	return out
 }
 
 // Run executes a specific JavaScirpt file
 func (js *JavaScriptVM) Run(fname string) error {
+	_, err := js.run(fname)
+	return err
+}
+
+// run executes fname like Run, exited is true when the script was stopped by os.exit()
+func (js *JavaScriptVM) run(fname string) (bool, error) {
 	src, err := ioutil.ReadFile(fname)
 	if err != nil {
-		return fmt.Errorf("Can't read file %s, %s", fname, err)
+		return false, fmt.Errorf("Can't read file %s, %s", fname, err)
 	}
-	script, err := js.VM.Compile(fname, src)
-	if err != nil {
-		return fmt.Errorf("%s, %s", fname, err)
+	js.mu.Lock()
+	script, err := js.VM.Compile(fname, stripShebang(src))
+	if err == nil {
+		_, err = js.evalGuarded(script, nil)
+	}
+	js.mu.Unlock()
+	if err == errExited {
+		return true, nil
 	}
-	_, err = js.VM.Eval(script)
 	if err != nil {
-		return fmt.Errorf("%s, %s", fname, err)
+		return false, fmt.Errorf("%s, %s", fname, err)
+	}
+	return js.loop(), nil
+}
+
+// args returns the arguments os.args() gives scripts, the ones set by RunnerWithArgs, otherwise
+// those left after flag.Parse() has run or os.Args when it hasn't
+func (js *JavaScriptVM) args() []string {
+	switch {
+	case js.scriptArgs != nil:
+		return *js.scriptArgs
+	case flag.Parsed() == true:
+		return flag.Args()
+	}
+	return os.Args
+}
+
+// SetClock makes the time object get the current time from fn, e.g. a fixed time so a script's
+// output can be tested, nil restores time.Now
+func (js *JavaScriptVM) SetClock(fn func() time.Time) {
+	js.clock = fn
+}
+
+// now returns the current time from the clock set with SetClock
+func (js *JavaScriptVM) now() time.Time {
+	if js.clock == nil {
+		return time.Now()
+	}
+	return js.clock()
+}
+
+// reportError passes err to js.OnError if set
+func (js *JavaScriptVM) reportError(err error) {
+	if js.OnError != nil {
+		js.OnError(err)
 	}
-	return nil
 }
 
-// Runner given a list of JavaScript filenames run the files
-func (js *JavaScriptVM) Runner(filenames []string) {
+// Runner given a list of JavaScript filenames run the files. It stops the program at the first
+// failure unless js.ContinueOnError is true, in which case failures are logged, the remaining files
+// are run and the failures are returned as a combined error.
+func (js *JavaScriptVM) Runner(filenames []string) error {
+	var errs []string
 	for _, fname := range filenames {
-		if err := js.Run(fname); err != nil {
-			log.Fatalf("%s", err)
+		exited, err := js.run(fname)
+		if exited == true {
+			// os.exit() ends the run like it would the program
+			break
+		}
+		if err != nil {
+			js.reportError(err)
+			if js.ContinueOnError == false {
+				log.Fatalf("%s", err)
+			}
+			log.Printf("%s", err)
+			errs = append(errs, fmt.Sprintf("%s", err))
 		}
 	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d scripts failed, %s", len(errs), len(filenames), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// RunnerWithArgs runs the files like Runner but os.args() returns scriptArgs rather than
+// the program's arguments while they run
+func (js *JavaScriptVM) RunnerWithArgs(filenames []string, scriptArgs []string) error {
+	prevArgs := js.scriptArgs
+	js.scriptArgs = &scriptArgs
+	defer func() {
+		js.scriptArgs = prevArgs
+	}()
+	return js.Runner(filenames)
+}
+
+// RunDir runs the JavaScript files (ending in .js) found in dirname in alphabetical order using Runner
+func (js *JavaScriptVM) RunDir(dirname string) error {
+	filenames, err := filepath.Glob(path.Join(dirname, "*.js"))
+	if err != nil {
+		return fmt.Errorf("Can't read directory %s, %s", dirname, err)
+	}
+	return js.Runner(filenames)
+}
+
+// compileErrorPosition matches the line and column otto reports in a compile error
+var compileErrorPosition = regexp.MustCompile(`Line ([0-9]+):([0-9]+) `)
+
+// formatCompileError renders a compile error with an excerpt of src and a caret
+// under the offending position. If no position can be found the error is returned as is.
+func formatCompileError(src string, err error) string {
+	msg := fmt.Sprintf("%s", err)
+	m := compileErrorPosition.FindStringSubmatch(msg)
+	if len(m) != 3 {
+		return msg
+	}
+	lineNo, _ := strconv.Atoi(m[1])
+	colNo, _ := strconv.Atoi(m[2])
+	lines := strings.Split(src, "\n")
+	if lineNo < 1 || lineNo > len(lines) {
+		return msg
+	}
+	line := lines[lineNo-1]
+	if colNo < 1 {
+		colNo = 1
+	}
+	if colNo > len(line)+1 {
+		colNo = len(line) + 1
+	}
+	prefix := fmt.Sprintf("%4d | ", lineNo)
+	caret := strings.Repeat(" ", len(prefix)+colNo-1) + "^"
+	return fmt.Sprintf("%s\n%s%s\n%s", msg, prefix, line, caret)
+}
+
+// bracketDepth returns how many of the (, [ and { in src are still open, brackets in strings and
+// comments are skipped and an unfinished block comment counts as one level
+func bracketDepth(src string) int {
+	depth := 0
+	quote, escaped := rune(0), false
+	lineComment, blockComment := false, false
+	runes := []rune(src)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case lineComment == true:
+			lineComment = (c != '\n')
+		case blockComment == true:
+			if c == '*' && next == '/' {
+				blockComment = false
+				i++
+			}
+		case quote != 0:
+			switch {
+			case escaped == true:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == quote || c == '\n':
+				quote = 0
+			}
+		case c == '/' && next == '/':
+			lineComment = true
+			i++
+		case c == '/' && next == '*':
+			blockComment = true
+			i++
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	if blockComment == true {
+		depth++
+	}
+	return depth
+}
+
+// continuationPrompt is the repl's prompt while input is incomplete, it shows the bracket depth
+func continuationPrompt(depth int) string {
+	return fmt.Sprintf("...%d> ", depth)
 }
 
 // Repl provides interactive JavaScript shell supporting autocomplete and command history
 func (js *JavaScriptVM) Repl() {
-	bold := color.New(color.Bold).SprintFunc()
-
 	homeDir := os.Getenv("HOME")
 	if homeDir == "" {
 		homeDir, _ = filepath.Abs(".")
 	}
 	historyFileName := fmt.Sprintf(".%s_history", path.Base(os.Args[0]))
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:       "> ",
-		HistoryFile:  path.Join(homeDir, historyFileName),
-		AutoComplete: js.AutoCompleter,
-		// for multi-line support see https://github.com/chzyer/readline/blob/master/example/readline-multiline/readline-multiline.go
-		DisableAutoSaveHistory: true,
-	})
+	config := readline.Config{}
+	if js.ReadlineConfig != nil {
+		config = *js.ReadlineConfig
+	}
+	if config.Prompt == "" {
+		config.Prompt = js.prompt()
+	}
+	if js.DisableHistory == true {
+		config.HistoryFile = ""
+	} else {
+		if config.HistoryFile == "" {
+			config.HistoryFile = path.Join(homeDir, historyFileName)
+		}
+		js.HistoryFile = config.HistoryFile
+	}
+	if config.AutoComplete == nil {
+		config.AutoComplete = js.AutoCompleter
+	}
+	if config.InterruptPrompt == "" {
+		config.InterruptPrompt = "^C"
+	}
+	// for multi-line support see https://github.com/chzyer/readline/blob/master/example/readline-multiline/readline-multiline.go
+	// the repl saves complete commands to the history itself
+	config.DisableAutoSaveHistory = true
+	rl, err := readline.NewEx(&config)
 	if err != nil {
 		panic(err)
 	}
 	defer rl.Close()
+	js.ReplWithReader(rl)
+}
+
+// prompt returns the repl's prompt, ReadlineConfig.Prompt if set otherwise "> "
+func (js *JavaScriptVM) prompt() string {
+	if js.ReadlineConfig != nil && js.ReadlineConfig.Prompt != "" {
+		return js.ReadlineConfig.Prompt
+	}
+	return "> "
+}
 
+// ReplWithReader runs the interactive JavaScript shell reading lines from rl and
+// writing results to js.Stdout. It returns when rl returns an error (e.g. io.EOF), Ctrl-C
+// (readline.ErrInterrupt) only clears the current input.
+func (js *JavaScriptVM) ReplWithReader(rl LineReader) {
+	bold := color.New(color.Bold).SprintFunc()
+	out := js.stdout()
+
+	js.loadTermUsage()
 	var cmds []string
 	for i := 1; true; i++ {
+		if js.runPending() == true {
+			return
+		}
 		line, err := rl.Readline()
-		if err != nil { // io.EOF, readline.ErrInterrupt
+		if err == readline.ErrInterrupt {
+			// Ctrl-C at the prompt clears the current input
+			cmds = []string{}
+			rl.SetPrompt(js.prompt())
+			continue
+		}
+		if err != nil { // io.EOF
 			break
 		}
 		switch {
 		case strings.HasPrefix(line, ".help"):
-			topic := strings.TrimPrefix(line, ".help ")
+			topic := strings.TrimSpace(strings.TrimPrefix(line, ".help"))
 			if topic == "" {
 				js.GetHelp("", "")
 			} else {
@@ -1127,13 +6671,47 @@ func (js *JavaScriptVM) Repl() {
 					js.GetHelp(topic, "")
 				}
 			}
+		case strings.HasPrefix(line, ".apropos"):
+			term := strings.TrimSpace(strings.TrimPrefix(line, ".apropos"))
+			if term == "" {
+				js.GetHelp("", "")
+				break
+			}
+			matches := js.apropos(term)
+			if len(matches) == 0 {
+				fmt.Fprintf(out, "Nothing found for %q\n", term)
+				break
+			}
+			fmt.Fprintf(out, "%s\n", strings.Join(matches, "\n"))
+		case strings.HasPrefix(line, ".ops"):
+			args := strings.Fields(strings.TrimPrefix(line, ".ops"))
+			if len(args) == 2 && args[0] == "cancel" {
+				id, err := strconv.Atoi(args[1])
+				if err == nil {
+					err = js.CancelOp(id)
+				}
+				if err != nil {
+					fmt.Fprintf(out, "Can't cancel %s, %s\n", args[1], err)
+				}
+				break
+			}
+			ops := js.ActiveOps()
+			if len(ops) == 0 {
+				fmt.Fprintln(out, "No active operations")
+				break
+			}
+			for _, op := range ops {
+				fmt.Fprintf(out, " %d\t%s\t%s\n", op.ID, op.Kind, op.Target)
+			}
+		case js.DisableHistory == true && (strings.HasPrefix(line, ".list") || strings.HasPrefix(line, ".load") || strings.HasPrefix(line, ".save")):
+			fmt.Fprintln(out, "History is disabled")
 		case strings.HasPrefix(line, ".list"):
-			buf, err := ioutil.ReadFile(rl.Config.HistoryFile)
+			buf, err := ioutil.ReadFile(js.HistoryFile)
 			if err != nil {
-				fmt.Printf("History is readable, %s\n", err)
+				fmt.Fprintf(out, "History is readable, %s\n", err)
 				break
 			}
-			fmt.Printf("%s", buf)
+			js.page(buf)
 		case strings.HasPrefix(line, ".load"):
 			s := strings.SplitN(line, " ", 2)
 			if len(s) < 2 || s[1] == "" {
@@ -1142,24 +6720,41 @@ func (js *JavaScriptVM) Repl() {
 			}
 			buf, err := ioutil.ReadFile(s[1])
 			if err != nil {
-				fmt.Printf("History is readable, %s\n", err)
+				fmt.Fprintf(out, "History is readable, %s\n", err)
 				break
 			}
 			for _, b := range bytes.Split(buf, []byte("\n")) {
 				rl.SaveHistory(fmt.Sprintf("%s", b))
 			}
-			fmt.Printf("%s loaded\n", s[1])
+			fmt.Fprintf(out, "%s loaded\n", s[1])
 		case strings.HasPrefix(line, ".reset"):
-			err := os.Truncate(rl.Config.HistoryFile, 0)
-			if err != nil {
-				fmt.Printf("Could not truncate history, %s\n", err)
+			target := strings.TrimSpace(strings.TrimPrefix(line, ".reset"))
+			if target != "" && target != "history" && target != "vars" && target != "all" {
+				fmt.Fprintf(out, "Unknown .reset option %q, expected history, vars or all\n", target)
 				break
 			}
-			fmt.Println("history truncated")
+			if target == "vars" || target == "all" {
+				js.Reset()
+				cmds = []string{}
+				js.session = nil
+				fmt.Fprintln(out, "variables cleared")
+			}
+			if target == "" || target == "history" || target == "all" {
+				if js.DisableHistory == true {
+					fmt.Fprintln(out, "History is disabled")
+					break
+				}
+				err := os.Truncate(js.HistoryFile, 0)
+				if err != nil {
+					fmt.Fprintf(out, "Could not truncate history, %s\n", err)
+					break
+				}
+				fmt.Fprintln(out, "history truncated")
+			}
 		case strings.HasPrefix(line, ".save"):
-			buf, err := ioutil.ReadFile(rl.Config.HistoryFile)
+			buf, err := ioutil.ReadFile(js.HistoryFile)
 			if err != nil {
-				fmt.Printf("History is readable, %s\n", err)
+				fmt.Fprintf(out, "History is readable, %s\n", err)
 				break
 			}
 			s := strings.SplitN(line, " ", 2)
@@ -1168,34 +6763,184 @@ func (js *JavaScriptVM) Repl() {
 				break
 			}
 			if err := ioutil.WriteFile(s[1], buf, 0600); err != nil {
-				fmt.Printf("Can't write %s, %s", s[1], err)
+				fmt.Fprintf(out, "Can't write %s, %s", s[1], err)
+				break
+			}
+			fmt.Fprintf(out, ".save %s completed\n", s[1])
+		case strings.HasPrefix(line, ".export"):
+			s := strings.SplitN(line, " ", 2)
+			if len(s) != 2 || s[1] == "" {
+				js.GetHelp("", "")
+				break
+			}
+			buf := new(bytes.Buffer)
+			for _, cmd := range js.session {
+				fmt.Fprintln(buf, cmd)
+			}
+			if err := ioutil.WriteFile(s[1], buf.Bytes(), 0600); err != nil {
+				fmt.Fprintf(out, "Can't write %s, %s\n", s[1], err)
 				break
 			}
-			fmt.Printf(".save %s completed\n", s[1])
+			fmt.Fprintf(out, ".export %s completed\n", s[1])
 		case strings.HasPrefix(line, ".exit"):
-			os.Exit(0)
+			js.exit(0)
+			return
 		case line == ".break":
-			fmt.Printf("Clearing input %q\n", strings.Join(cmds, " "))
+			fmt.Fprintf(out, "Clearing input %q\n", strings.Join(cmds, " "))
 			cmds = []string{}
-			rl.SetPrompt("> ")
+			rl.SetPrompt(js.prompt())
 		default:
+			if strings.HasSuffix(line, "\\") == true {
+				// a trailing backslash continues the input on the next line
+				cmds = append(cmds, strings.TrimSuffix(line, "\\"))
+				rl.SetPrompt(continuationPrompt(bracketDepth(strings.Join(cmds, "\n"))))
+				break
+			}
 			cmds = append(cmds, line)
-			script, err := js.VM.Compile(fmt.Sprintf("command %d", i), strings.Join(cmds, " "))
+			if depth := bracketDepth(strings.Join(cmds, "\n")); depth > 0 {
+				rl.SetPrompt(continuationPrompt(depth))
+				break
+			}
+			// the lines are kept apart so a // comment only runs to the end of its own line
+			src := strings.Join(cmds, "\n")
+			js.mu.Lock()
+			script, err := js.VM.Compile(fmt.Sprintf("command %d", i), src)
+			js.mu.Unlock()
 			if err != nil {
-				fmt.Printf("%s\n", err)
+				fmt.Fprintf(out, "%s\n", formatCompileError(src, err))
 				rl.SetPrompt(fmt.Sprintf("%0.2d: ", len(cmds)))
 			} else {
-				rl.SetPrompt("> ")
-				rl.SaveHistory(strings.Join(cmds, " "))
+				rl.SetPrompt(js.prompt())
+				// the history file holds a line per entry
+				for _, cmd := range cmds {
+					rl.SaveHistory(cmd)
+				}
 				cmds = []string{}
-				val, err := js.VM.Eval(script)
+				js.mu.Lock()
+				val, err := js.evalInterruptible(script)
+				if err == errExited {
+					// like .exit when ExitFunc returns
+					js.mu.Unlock()
+					return
+				}
 				if err != nil {
-					fmt.Printf("js error: %s\n", err)
+					js.reportError(err)
+					fmt.Fprintf(out, "js error: %s\n", err)
+				} else {
+					js.session = append(js.session, src)
+					if js.countTermUsage(src) == true {
+						js.rerankAutoComplete()
+						js.saveTermUsage()
+					}
 				}
-				fmt.Printf("    %s\n", bold(val.String()))
+				fmt.Fprintf(out, "    %s\n", bold(js.formatResult(val)))
+				js.mu.Unlock()
+			}
+		}
+	}
+}
+
+// formatResult returns how the repl shows val, objects and arrays as JSON indented with
+// js.OutputIndent and other values (or ones JSON can't show) as strings
+func (js *JavaScriptVM) formatResult(val otto.Value) string {
+	if val.IsObject() == false || (val.Class() != "Object" && val.Class() != "Array") {
+		return val.String()
+	}
+	src, err := js.VM.Call("JSON.stringify", nil, val, nil, js.OutputIndent)
+	if err != nil || src.IsString() == false {
+		return val.String()
+	}
+	// continuation lines line up with the first under the repl's margin
+	return strings.Replace(src.String(), "\n", "\n    ", -1)
+}
+
+// haltError is the value panicked through otto's Interrupt channel to stop a running script
+type haltError struct {
+	err error
+}
+
+// errExited is a haltError's error when os.exit() stopped the script
+var errExited = fmt.Errorf("os.exit() was called")
+
+// evalHalting evaluates src returning the error of a haltError panicked while it runs (an
+// interrupt or os.exit()) instead of letting the panic through
+func (js *JavaScriptVM) evalHalting(src interface{}) (val otto.Value, err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			halt, ok := caught.(haltError)
+			if ok == false {
+				panic(caught)
+			}
+			val, err = otto.UndefinedValue(), halt.err
+		}
+	}()
+	return js.VM.Eval(src)
+}
+
+// eval evaluates src like evalHalting, a script stopped by os.exit() isn't an error
+func (js *JavaScriptVM) eval(src interface{}) (otto.Value, error) {
+	val, err := js.evalHalting(src)
+	if err == errExited {
+		return val, nil
+	}
+	return val, err
+}
+
+// evalUntil evaluates src, stopping it with the error received from stop if one arrives
+// before it finishes
+func (js *JavaScriptVM) evalUntil(src interface{}, stop <-chan error) (otto.Value, error) {
+	if js.VM.Interrupt == nil {
+		// unbuffered so an interrupt can't be left over for the next eval
+		js.VM.Interrupt = make(chan func())
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case reason := <-stop:
+			select {
+			case js.VM.Interrupt <- func() { panic(haltError{reason}) }:
+			case <-done:
 			}
+		case <-done:
 		}
+	}()
+	return js.evalHalting(src)
+}
+
+// evalGuarded evaluates src, stopping it once js.DefaultTimeout (when non-zero) has passed or
+// a signal arrives on sigs (when not nil)
+func (js *JavaScriptVM) evalGuarded(src interface{}, sigs <-chan os.Signal) (otto.Value, error) {
+	if js.DefaultTimeout <= 0 && sigs == nil {
+		return js.evalHalting(src)
+	}
+	var timeout <-chan time.Time
+	if js.DefaultTimeout > 0 {
+		timer := time.NewTimer(js.DefaultTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
+	stop := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigs:
+			stop <- fmt.Errorf("interrupted")
+		case <-timeout:
+			stop <- fmt.Errorf("timed out after %s", js.DefaultTimeout)
+		case <-done:
+		}
+	}()
+	return js.evalUntil(src, stop)
+}
+
+// evalInterruptible evaluates src like evalGuarded, Ctrl-C stops the script instead of the program
+func (js *JavaScriptVM) evalInterruptible(src interface{}) (otto.Value, error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	return js.evalGuarded(src, sigs)
 }
 
 //
@@ -1212,11 +6957,20 @@ func (js *JavaScriptVM) Repl() {
 // _ := ToSruct(val, &a)
 // fmt.Printf("One: %d, Two: %s\n", a.One, a.Two)
 //
+// Fields with a type given to RegisterConverter are populated by the converter rather than
+// by the JSON round trip.
 func ToStruct(value otto.Value, aStruct interface{}) error {
 	raw, err := value.Export()
 	if err != nil {
 		return fmt.Errorf("failed to export value, %s", err)
 	}
+	var converted map[int]reflect.Value
+	if obj, ok := raw.(map[string]interface{}); ok == true {
+		raw, converted, err = convertFields(obj, aStruct)
+		if err != nil {
+			return err
+		}
+	}
 	src, err := json.Marshal(raw)
 	if err != nil {
 		return fmt.Errorf("failed to marshal value, %s", err)
@@ -1225,5 +6979,165 @@ func ToStruct(value otto.Value, aStruct interface{}) error {
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal value, %s", err)
 	}
+	if len(converted) > 0 {
+		sv := reflect.ValueOf(aStruct).Elem()
+		for i, val := range converted {
+			sv.Field(i).Set(val)
+		}
+	}
+	return nil
+}
+
+var (
+	convertersMu sync.RWMutex
+	converters   = make(map[reflect.Type]func(interface{}) (interface{}, error))
+)
+
+// RegisterConverter sets the function ToStruct uses to populate struct fields of type t (e.g. a
+// Money type or an enum) which the JSON round trip can't handle. fn is given the exported
+// JavaScript value and returns a value assignable (or convertible) to t.
+func RegisterConverter(t reflect.Type, fn func(interface{}) (interface{}, error)) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if fn == nil {
+		delete(converters, t)
+		return
+	}
+	converters[t] = fn
+}
+
+// convertFields runs the registered converters for the fields of the struct aStruct points at. It returns
+// obj without the converted properties and the converted values keyed by field index.
+func convertFields(obj map[string]interface{}, aStruct interface{}) (map[string]interface{}, map[int]reflect.Value, error) {
+	rv := reflect.ValueOf(aStruct)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return obj, nil, nil
+	}
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	if len(converters) == 0 {
+		return obj, nil, nil
+	}
+
+	st := rv.Elem().Type()
+	rest := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		rest[k] = v
+	}
+	converted := make(map[int]reflect.Value)
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		fn, ok := converters[field.Type]
+		if ok == false || field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		// match property names the way encoding/json does, exactly then ignoring case
+		key, found := name, false
+		if _, found = rest[name]; found == false {
+			for k := range rest {
+				if strings.EqualFold(k, name) == true {
+					key, found = k, true
+					break
+				}
+			}
+		}
+		if found == false {
+			continue
+		}
+		result, err := fn(rest[key])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to convert %s, %s", field.Name, err)
+		}
+		val := reflect.ValueOf(result)
+		switch {
+		case val.IsValid() == false:
+			val = reflect.Zero(field.Type)
+		case val.Type().AssignableTo(field.Type) == true:
+		case val.Type().ConvertibleTo(field.Type) == true:
+			val = val.Convert(field.Type)
+		default:
+			return nil, nil, fmt.Errorf("failed to convert %s, %T is not a %s", field.Name, result, field.Type)
+		}
+		converted[i] = val
+		delete(rest, key)
+	}
+	return rest, converted, nil
+}
+
+// toFloat64 returns val as a float64 if it is one of Go's numeric types
+func toFloat64(val interface{}) (float64, bool) {
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// CompareValue compares expected with actual returning an error describing any difference. Strings
+// are compared as text, numbers by value whatever their Go type (e.g. an int and the float64 or int64 a
+// JavaScript number exports as), floats to six decimal places, anything else must be deeply equal. It
+// is meant for embedders' tests checking values returned by their scripts.
+//
+// Example:
+// val, _ := js.VM.Eval(`1 + 1`)
+// n, _ := val.Export()
+// err := ostdlib.CompareValue(2, n)
+//
+func CompareValue(expected, actual interface{}) error {
+	if s1, ok := expected.(string); ok == true {
+		s2 := fmt.Sprintf("%s", actual)
+		if strings.Compare(s1, s2) != 0 {
+			return fmt.Errorf("strings %q != %q", s1, s2)
+		}
+		return nil
+	}
+	if f1, ok := toFloat64(expected); ok == true {
+		f2, ok := toFloat64(actual)
+		if ok == false {
+			return fmt.Errorf("expected a number %v, got %T %v", expected, actual, actual)
+		}
+		s1, s2 := fmt.Sprintf("%f", f1), fmt.Sprintf("%f", f2)
+		if strings.Compare(s1, s2) != 0 {
+			return fmt.Errorf("numbers %s != %s", s1, s2)
+		}
+		return nil
+	}
+	if b1, ok := expected.(bool); ok == true {
+		b2, ok := actual.(bool)
+		if ok == false {
+			return fmt.Errorf("expected a bool %t, got %T %v", b1, actual, actual)
+		}
+		if b1 != b2 {
+			return fmt.Errorf("bool %t != %t", b1, b2)
+		}
+		return nil
+	}
+	if reflect.DeepEqual(expected, actual) == false {
+		return fmt.Errorf("%T %+v != %T %+v", expected, expected, actual, actual)
+	}
 	return nil
 }
+
+// SemVer returns the major, minor and patch numbers of Version, a pre-release or build suffix is ignored
+func SemVer() (int, int, int) {
+	var parts [3]int
+	v := strings.TrimPrefix(Version, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	for i, field := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(field)
+	}
+	return parts[0], parts[1], parts[2]
+}
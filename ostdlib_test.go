@@ -23,11 +23,30 @@ package ostdlib
 // This is extenion to the original otto
 //
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	// 3rd Party packages
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
 	"github.com/robertkrimen/otto"
 )
 
@@ -60,6 +79,37 @@ func isOK(t *testing.T, o1 interface{}, o2 interface{}) {
 	}
 }
 
+// testLineReader feeds the repl a fixed list of lines, recording the prompts it is given
+type testLineReader struct {
+	lines   []string
+	prompts []string
+	history []string
+}
+
+// testInterrupt is a line testLineReader reports as Ctrl-C (readline.ErrInterrupt)
+const testInterrupt = "^C"
+
+func (rl *testLineReader) Readline() (string, error) {
+	if len(rl.lines) == 0 {
+		return "", io.EOF
+	}
+	line := rl.lines[0]
+	rl.lines = rl.lines[1:]
+	if line == testInterrupt {
+		return "", readline.ErrInterrupt
+	}
+	return line, nil
+}
+
+func (rl *testLineReader) SetPrompt(s string) {
+	rl.prompts = append(rl.prompts, s)
+}
+
+func (rl *testLineReader) SaveHistory(s string) error {
+	rl.history = append(rl.history, s)
+	return nil
+}
+
 func TestToStructValue(t *testing.T) {
 	vm := otto.New()
 	jsSrc := `(function () {return {one: 1, two: "Two", three: 3.0, four: [1,2,3,4], five: true};}())`
@@ -214,3 +264,3412 @@ func TestWorkbookWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestReplCompileError(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	rl := &testLineReader{lines: []string{"var x = 1 +;"}}
+	js.ReplWithReader(rl)
+	s := out.String()
+	if strings.Contains(s, "Line 1:") == false {
+		t.Errorf("expected a line and column in %q", s)
+	}
+	if strings.Contains(s, "var x = 1 +;\n") == false || strings.Contains(s, "^") == false {
+		t.Errorf("expected an excerpt with a caret in %q", s)
+	}
+	if len(rl.prompts) != 1 || rl.prompts[0] != "01: " {
+		t.Errorf("expected continuation prompt, got %+v", rl.prompts)
+	}
+}
+
+func TestReplContinuationDepth(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	rl := &testLineReader{lines: []string{
+		"function answer() {",
+		"  if (true) { /* a comment with a { */",
+		"    return \"})\".length * 21;",
+		"  }",
+		"}",
+		"answer() + \\",
+		"0",
+	}}
+	js.ReplWithReader(rl)
+	isOK(t, strings.Join(rl.prompts, "|"), "...1> |...2> |...2> |...1> |> |...0> |> ")
+	if strings.Contains(out.String(), "42") == false {
+		t.Errorf("expected the continued input to be evaluated, %q", out.String())
+	}
+	if strings.Contains(out.String(), "Line ") == true {
+		t.Errorf("expected no compile errors for incomplete input, %q", out.String())
+	}
+}
+
+func TestReplLineComment(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	rl := &testLineReader{lines: []string{
+		"function answer() { // a trailing comment",
+		"  return 42; // another",
+		"}",
+		"answer()",
+	}}
+	js.ReplWithReader(rl)
+	if strings.Contains(out.String(), "Line ") == true {
+		t.Errorf("expected the comments to end with their lines, %q", out.String())
+	}
+	if strings.Contains(out.String(), "42") == false {
+		t.Errorf("expected the function to be defined, %q", out.String())
+	}
+	isOK(t, strings.Join(rl.history, "|"), "function answer() { // a trailing comment|  return 42; // another|}|answer()")
+}
+
+func TestHardlink(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	oldname := path.Join(tmpDir, "original.txt")
+	newname := path.Join(tmpDir, "linked.txt")
+	if err := ioutil.WriteFile(oldname, []byte("before"), 0660); err != nil {
+		t.Errorf("Can't write %s, %s", oldname, err)
+		t.FailNow()
+	}
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`
+		(function () {
+			if (os.hardlink(%q, %q) !== true) {
+				return false;
+			}
+			os.writeFile(%q, "after");
+			return os.readFile(%q) === "after";
+		}());
+	`, oldname, newname, newname, oldname))
+	if err != nil {
+		t.Errorf("os.hardlink() failed, %s", err)
+		t.FailNow()
+	}
+	testResult, _ := val.ToBoolean()
+	if testResult == false {
+		t.Errorf("expected write through %s to be visible via %s", newname, oldname)
+	}
+}
+
+func TestWorkbookReadRich(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`
+		(function () {
+			var wk = xlsx.readRich("testdata/Annotated.xlsx");
+			if (typeof wk.sheets !== "object" || wk.sheets.Sheet1 === undefined) {
+				console.log("Expected sheets in readRich result, ", JSON.stringify(wk));
+				return false;
+			}
+			if (wk.comments.Sheet1.A1 !== "Check these values") {
+				console.log("Expected comment on Sheet1!A1, ", JSON.stringify(wk.comments));
+				return false;
+			}
+			if (wk.hyperlinks.Sheet1.B2 !== "https://library.caltech.edu/") {
+				console.log("Expected hyperlink on Sheet1!B2, ", JSON.stringify(wk.hyperlinks));
+				return false;
+			}
+			return true;
+		}());
+	`)
+	if err != nil {
+		t.Errorf("xlsx.readRich() failed, %s", err)
+	} else {
+		testResult, err := val.ToBoolean()
+		if err != nil {
+			t.Errorf("xlsx.readRich(), can't read result, %s", err)
+		}
+		if testResult == false {
+			t.FailNow()
+		}
+	}
+}
+
+func TestEvalBatch(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	results := js.EvalBatch([]NamedSource{
+		{Name: "one", Source: `1 + 1`},
+		{Name: "two", Source: `throw new Error("two failed")`},
+		{Name: "three", Source: `"three"`},
+	})
+	isOK(t, len(results), 3)
+	isOK(t, results[0].Name, "one")
+	isOK(t, results[0].Error, nil)
+	isOK(t, fmt.Sprintf("%v", results[0].Value), "2")
+	if results[1].Error == nil || strings.Contains(results[1].Error.Error(), "two failed") == false {
+		t.Errorf("expected two to carry its error, %+v", results[1])
+	}
+	isOK(t, results[2].Error, nil)
+	isOK(t, results[2].Value, "three")
+}
+
+func TestRegister(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	err := js.Register("util", "greet", func(call otto.FunctionCall) otto.Value {
+		result, _ := js.VM.ToValue(fmt.Sprintf("Hello %s", call.Argument(0).String()))
+		return result
+	}, []string{"name string"}, "returns a greeting for name")
+	isOK(t, err, nil)
+
+	val, err := js.VM.Eval(`util.greet("World")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "Hello World")
+
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	js.ReplWithReader(&testLineReader{lines: []string{".help util.greet"}})
+	if strings.Contains(out.String(), "returns a greeting for name") == false {
+		t.Errorf("expected help for util.greet, %q", out.String())
+	}
+
+	// Functions registered after AddAutoComplete are offered by the completer
+	js.AddAutoComplete()
+	err = js.Register("util", "wave", func(call otto.FunctionCall) otto.Value {
+		return otto.UndefinedValue()
+	}, []string{}, "waves")
+	isOK(t, err, nil)
+	found := false
+	for _, item := range js.AutoCompleter.GetChildren() {
+		if strings.HasPrefix(string(item.GetName()), "util.wave(") == true {
+			found = true
+		}
+	}
+	isOK(t, found, true)
+}
+
+func TestCopyFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	src := path.Join(tmpDir, "src.sh")
+	dst := path.Join(tmpDir, "dst.sh")
+	ioutil.WriteFile(src, []byte("new content"), 0750)
+	os.Chmod(src, 0750)
+	ioutil.WriteFile(dst, []byte("old content"), 0640)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	// Refuse to overwrite by default
+	val, err := js.VM.Eval(fmt.Sprintf(`os.copyFile(%q, %q)`, src, dst))
+	isOK(t, err, nil)
+	isOK(t, val.IsObject(), true)
+	buf, _ := ioutil.ReadFile(dst)
+	isOK(t, string(buf), "old content")
+
+	// Force the overwrite
+	val, err = js.VM.Eval(fmt.Sprintf(`os.copyFile(%q, %q, {overwrite: true})`, src, dst))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+	buf, _ = ioutil.ReadFile(dst)
+	isOK(t, string(buf), "new content")
+	info, err := os.Stat(dst)
+	isOK(t, err, nil)
+	isOK(t, info.Mode().Perm().String(), os.FileMode(0750).String())
+
+	// Copying a file onto itself is refused rather than truncating it
+	val, err = js.VM.Eval(fmt.Sprintf(`os.copyFile(%q, %q, {overwrite: true}).status`, src, path.Join(tmpDir, ".", "src.sh")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+	buf, _ = ioutil.ReadFile(src)
+	isOK(t, string(buf), "new content")
+}
+
+func TestJSONL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	fname := path.Join(tmpDir, "test.jsonl")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`
+		(function () {
+			var records = [{id: 1, name: "one"}, {id: 2, name: "two"}, {id: 3, name: "three"}];
+			if (jsonl.write(%q, records) !== true) {
+				console.log("jsonl.write() failed");
+				return false;
+			}
+			var data = jsonl.read(%q);
+			if (data.length !== 3 || data[1].name !== "two") {
+				console.log("jsonl.read() unexpected result", JSON.stringify(data));
+				return false;
+			}
+			var total = 0;
+			var count = jsonl.forEach(%q, function (record, lineNo) {
+				total += record.id;
+			});
+			if (count !== 3 || total !== 6) {
+				console.log("jsonl.forEach() unexpected result", count, total);
+				return false;
+			}
+			return true;
+		}());
+	`, fname, fname, fname))
+	if err != nil {
+		t.Errorf("jsonl round trip failed, %s", err)
+		t.FailNow()
+	}
+	testResult, _ := val.ToBoolean()
+	if testResult == false {
+		t.FailNow()
+	}
+
+	// Malformed lines should be reported by line number
+	ioutil.WriteFile(fname, []byte("{\"id\": 1}\n\n{\"id\": \n"), 0660)
+	val, err = js.VM.Eval(fmt.Sprintf(`jsonl.read(%q).error`, fname))
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), "line 3") == false {
+		t.Errorf("expected error to reference line 3, %s", val.String())
+	}
+	// U+2028 is valid in a JSON string but ends a JavaScript string literal
+	ioutil.WriteFile(fname, []byte("{\"text\": \"a\u2028b\"}\n"), 0660)
+	val, err = js.VM.Eval(fmt.Sprintf(`jsonl.read(%q)[0].text === "a\u2028b"`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+}
+
+func TestContinueOnError(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	broken := path.Join(tmpDir, "1-broken.js")
+	good := path.Join(tmpDir, "2-good.js")
+	ioutil.WriteFile(broken, []byte(`throw new Error("broken script");`), 0660)
+	ioutil.WriteFile(good, []byte(`var goodScriptRan = true;`), 0660)
+
+	vm := otto.New()
+	js := New(vm)
+	js.ContinueOnError = true
+	err = js.RunDir(tmpDir)
+	if err == nil || strings.Contains(err.Error(), "1-broken.js") == false {
+		t.Errorf("expected error referencing %s, %s", broken, err)
+	}
+	val, err := js.VM.Eval(`goodScriptRan === true`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+}
+
+func TestNewerThan(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	older := path.Join(tmpDir, "older.txt")
+	newer := path.Join(tmpDir, "newer.txt")
+	ioutil.WriteFile(older, []byte("older"), 0660)
+	ioutil.WriteFile(newer, []byte("newer"), 0660)
+	then := time.Now().Add(-1 * time.Hour)
+	os.Chtimes(older, then, then)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`os.newerThan(%q, %q)`, newer, older))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+	val, err = js.VM.Eval(fmt.Sprintf(`os.newerThan(%q, %q)`, older, newer))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false")
+	val, err = js.VM.Eval(fmt.Sprintf(`os.newerThan(%q, %q).status`, path.Join(tmpDir, "missing.txt"), older))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+// testSheetSettings holds the sheet view and column settings read directly from a worksheet's XML
+type testSheetSettings struct {
+	SheetViews []struct {
+		TabSelected bool `xml:"tabSelected,attr"`
+	} `xml:"sheetViews>sheetView"`
+	Cols []struct {
+		Min   int     `xml:"min,attr"`
+		Max   int     `xml:"max,attr"`
+		Width float64 `xml:"width,attr"`
+	} `xml:"cols>col"`
+}
+
+// readSheetSettings decodes the worksheet XML for sheetName in the xlsx file fname
+func readSheetSettings(fname, sheetName string) (*testSheetSettings, error) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	workbook := xlsxWorkbookSheets{}
+	if err := xlsxPart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	sheetParts, err := xlsxRelTargets(parts, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	for _, sheet := range workbook.Sheets {
+		if sheet.Name == sheetName {
+			settings := new(testSheetSettings)
+			err := xlsxPart(parts, sheetParts[sheet.RID], settings)
+			return settings, err
+		}
+	}
+	return nil, fmt.Errorf("%s not found in %s", sheetName, fname)
+}
+
+func TestWorkbookWriteOptions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	fname := path.Join(tmpDir, "options.xlsx")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`
+		(function () {
+			var wk = xlsx.New({
+				Sheet1: [["a", "b"], ["1", "2"]],
+				Sheet2: [["c", "d"], ["3", "4"]]
+			});
+			return wk.write(%q, {activeSheet: "Sheet2", columnWidths: {Sheet1: [32, 12]}});
+		}());
+	`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+
+	sheet1, err := readSheetSettings(fname, "Sheet1")
+	isOK(t, err, nil)
+	sheet2, err := readSheetSettings(fname, "Sheet2")
+	isOK(t, err, nil)
+	if len(sheet2.SheetViews) == 0 || sheet2.SheetViews[0].TabSelected == false {
+		t.Errorf("expected Sheet2 to be selected, %+v", sheet2.SheetViews)
+	}
+	if len(sheet1.SheetViews) > 0 && sheet1.SheetViews[0].TabSelected == true {
+		t.Errorf("expected Sheet1 not to be selected, %+v", sheet1.SheetViews)
+	}
+	// the workbook opens on the active sheet
+	zr, err := zip.OpenReader(fname)
+	isOK(t, err, nil)
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	views := struct {
+		WorkbookViews []struct {
+			ActiveTab int `xml:"activeTab,attr"`
+		} `xml:"bookViews>workbookView"`
+	}{}
+	err = xlsxPart(parts, "xl/workbook.xml", &views)
+	zr.Close()
+	isOK(t, err, nil)
+	if len(views.WorkbookViews) == 0 || views.WorkbookViews[0].ActiveTab != 1 {
+		t.Errorf("expected the workbook to open on Sheet2, %+v", views.WorkbookViews)
+	}
+	widths := make(map[int]float64)
+	for _, col := range sheet1.Cols {
+		widths[col.Min] = col.Width
+	}
+	isOK(t, widths[1], 32.0)
+	isOK(t, widths[2], 12.0)
+}
+
+func TestReplResetAll(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.HistoryFile = path.Join(tmpDir, "history")
+	ioutil.WriteFile(js.HistoryFile, []byte("var leftOver = 1;\n"), 0600)
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	js.ReplWithReader(&testLineReader{lines: []string{"var leftOver = 1;", ".reset all"}})
+
+	val, err := js.VM.Eval(`typeof leftOver`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "undefined")
+	val, err = js.VM.Eval(`typeof os.readFile`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "function")
+	buf, _ := ioutil.ReadFile(js.HistoryFile)
+	isOK(t, len(buf), 0)
+}
+
+func TestHTTPStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, ": event %d\nid: %d\ndata: {\"n\": %d}\n\n", i, i, i)
+			if f, ok := w.(http.Flusher); ok == true {
+				f.Flush()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`
+		var streamEvents = [];
+		var handle = http.stream(%q, function (e) {
+			streamEvents.push(JSON.parse(e.data).n);
+		});
+		typeof handle.stop;
+	`, ts.URL))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "function")
+	js.Loop()
+	val, err = js.VM.Eval(`streamEvents.join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "1,2,3")
+}
+
+func TestReplRunsPending(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: 1\n\n")
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	// the event arrives while the second line runs, its callback runs before the next prompt
+	js.ReplWithReader(&testLineReader{lines: []string{
+		fmt.Sprintf("var fired = 0; http.stream(%q, function (e) { fired++; });", ts.URL),
+		"var start = Date.now(); while (Date.now() - start < 200) {}",
+		"fired === 1",
+	}})
+	if strings.Contains(out.String(), "true") == false {
+		t.Errorf("expected the stream callback to have run, %q", out.String())
+	}
+	js.Loop()
+}
+
+func TestClose(t *testing.T) {
+	connected := make(chan bool, 1)
+	cancelled := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: waiting\n\n")
+		if f, ok := w.(http.Flusher); ok == true {
+			f.Flush()
+		}
+		connected <- true
+		<-r.Context().Done()
+		cancelled <- true
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	_, err := js.VM.Eval(fmt.Sprintf(`var handle = http.stream(%q, function (e) {});`, ts.URL))
+	isOK(t, err, nil)
+	isOK(t, len(js.ops), 1)
+	<-connected
+	isOK(t, js.Close(), nil)
+	isOK(t, len(js.ops), 0)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected Close to cancel the stream's request")
+	}
+	// Loop returns straight away as nothing is left running
+	js.Loop()
+
+	// each VM has its own transport so Close leaves other clients' connections alone
+	other := New(otto.New())
+	isOK(t, js.transport() != http.DefaultTransport, true)
+	isOK(t, js.transport() != other.transport(), true)
+	isOK(t, js.transport() == js.transport(), true)
+}
+
+func TestRealpath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on Windows")
+	}
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	// The temp dir may itself be behind a symbolic link (e.g. /tmp on macOS)
+	tmpDir, _ = filepath.EvalSymlinks(tmpDir)
+	target := path.Join(tmpDir, "target.txt")
+	link := path.Join(tmpDir, "link.txt")
+	ioutil.WriteFile(target, []byte("target"), 0660)
+	if err := os.Symlink(target, link); err != nil {
+		t.Errorf("Can't create symlink, %s", err)
+		t.FailNow()
+	}
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`os.realpath(%q)`, link))
+	isOK(t, err, nil)
+	isOK(t, val.String(), target)
+	val, err = js.VM.Eval(fmt.Sprintf(`os.realpath(%q).status`, path.Join(tmpDir, "missing", "file.txt")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestRunnerWithArgs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	fname := path.Join(tmpDir, "args.js")
+	ioutil.WriteFile(fname, []byte(`var seenArgs = os.args().join(" ");`), 0660)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	err = js.RunnerWithArgs([]string{fname}, []string{"--count", "3", "file.txt"})
+	isOK(t, err, nil)
+	val, err := js.VM.Eval(`seenArgs`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "--count 3 file.txt")
+
+	// After the run os.args() is back to the program's arguments
+	val, err = js.VM.Eval(`os.args().join(" ")`)
+	isOK(t, err, nil)
+	if val.String() == "--count 3 file.txt" {
+		t.Errorf("expected os.args() to be restored, %s", val.String())
+	}
+}
+
+func TestPrintDefaultWelcomeTo(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	out := new(bytes.Buffer)
+	js.PrintDefaultWelcomeTo(out)
+	s := out.String()
+	for _, name := range []string{"os", "http", "xlsx", "Workbook"} {
+		if strings.Contains(s, name) == false {
+			t.Errorf("expected welcome to name %s, %q", name, s)
+		}
+	}
+	if strings.Contains(s, Version) == false {
+		t.Errorf("expected welcome to include version %s, %q", Version, s)
+	}
+}
+
+func TestWorkbookReadRange(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`JSON.stringify(xlsx.readRange("testdata/Workbook1.xlsx", "Sheet1", "A2:B4"))`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), `[["1","one"],["2","two"],["",""]]`)
+
+	val, err = js.VM.Eval(`xlsx.readRange("testdata/Workbook1.xlsx", "Sheet1", "A:B").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+	val, err = js.VM.Eval(`xlsx.readRange("testdata/Workbook1.xlsx", "NoSuchSheet", "A1:B2").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestMapInputs(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	results, err := js.MapInputs([]string{"one", "two", "three"}, `input.toUpperCase() + ":" + typeof os.readFile`, 2)
+	isOK(t, err, nil)
+	isOK(t, len(results), 3)
+	isOK(t, results[0], "ONE:function")
+	isOK(t, results[1], "TWO:function")
+	isOK(t, results[2], "THREE:function")
+}
+
+func TestHostInfo(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`os.getpid()`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), fmt.Sprintf("%d", os.Getpid()))
+	val, err = js.VM.Eval(`(function () {
+		var info = os.hostInfo();
+		return info.numCPU > 0 && info.pid === os.getpid() && typeof info.hostname === "string";
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+}
+
+func TestLoadEnv(t *testing.T) {
+	expected := map[string]string{
+		"OSTDLIB_PLAIN":    "plain value",
+		"OSTDLIB_EXPORTED": "exported",
+		"OSTDLIB_DOUBLE":   `double "quoted" # not a comment`,
+		"OSTDLIB_SINGLE":   "single $quoted",
+		"OSTDLIB_TRAILING": "value",
+	}
+	defer func() {
+		for k := range expected {
+			os.Unsetenv(k)
+		}
+	}()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`os.loadEnv("testdata/test.env")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "5")
+	for k, v := range expected {
+		val, err := js.VM.Eval(fmt.Sprintf(`os.getEnv(%q)`, k))
+		isOK(t, err, nil)
+		isOK(t, val.String(), v)
+	}
+}
+
+func TestWorkbookValidate(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`xlsx.New({Sheet1: [["a", "b"], [1, true]], Sheet2: []}).validate()`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+
+	val, err = js.VM.Eval(`xlsx.New({Sheet1: [["a", "b"], "not a row"]}).validate().error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), `sheet "Sheet1" row 2`) == false {
+		t.Errorf("expected error naming Sheet1 row 2, %s", val.String())
+	}
+	val, err = js.VM.Eval(`xlsx.validate({Sheet1: [["a", {b: 1}]]}).error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), `sheet "Sheet1" row 1 column 2`) == false {
+		t.Errorf("expected error naming Sheet1 row 1 column 2, %s", val.String())
+	}
+
+	// ragged rows are reported
+	val, err = js.VM.Eval(`xlsx.New({Sheet1: [["a", "b"], ["1", "2"], ["3"]]}).validate().error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), `sheet "Sheet1" row 3 has 1 cells, row 1 has 2`) == false {
+		t.Errorf("expected error naming Sheet1 row 3, %s", val.String())
+	}
+
+	// the first offending sheet is the first in property order, every time
+	for i := 0; i < 20; i++ {
+		val, err = js.VM.Eval(`xlsx.validate({Zeta: [["a"], "bad"], Alpha: [["a"], ["b", "c"]], Mid: "bad"}).error`)
+		isOK(t, err, nil)
+		if strings.Contains(val.String(), `sheet "Zeta" row 2`) == false {
+			t.Errorf("expected error naming Zeta row 2, %s", val.String())
+			break
+		}
+	}
+}
+
+func TestHTTPMock(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`
+		http.setMock({
+			"GET http://mock.invalid/data": "canned body",
+			"POST http://mock.invalid/data": {status: 201, body: "created"}
+		});
+		[http.get("http://mock.invalid/data"), http.post("http://mock.invalid/data", "text/plain", "payload")].join(",");
+	`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "canned body,created")
+
+	// Requests without a canned response fail rather than using the network
+	val, err = js.VM.Eval(`http.get("http://mock.invalid/missing").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestHTTPRecordConcurrent(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		fmt.Fprintf(w, "%s", r.URL.Path)
+	}))
+	defer ts.Close()
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+
+	mock := &httpMock{responses: map[string]httpMockResponse{}, recordTo: path.Join(dname, "record.json"), next: http.DefaultTransport}
+	client := &http.Client{Transport: mock}
+	slow := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(ts.URL + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		slow <- err
+	}()
+	<-started
+
+	// a request still waiting for its response doesn't hold up the others
+	fast := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(ts.URL + "/fast")
+		if err == nil {
+			resp.Body.Close()
+		}
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		isOK(t, err, nil)
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected /fast to be recorded while /slow was waiting")
+	}
+	close(release)
+	isOK(t, <-slow, nil)
+	src, err := ioutil.ReadFile(mock.recordTo)
+	isOK(t, err, nil)
+	isOK(t, strings.Contains(string(src), "/slow") && strings.Contains(string(src), "/fast"), true)
+}
+
+func TestWalk(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	os.MkdirAll(path.Join(tmpDir, "keep"), 0775)
+	os.MkdirAll(path.Join(tmpDir, "skip"), 0775)
+	ioutil.WriteFile(path.Join(tmpDir, "keep", "a.txt"), []byte("abc"), 0660)
+	ioutil.WriteFile(path.Join(tmpDir, "skip", "b.txt"), []byte("abc"), 0660)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`
+		(function () {
+			var seen = [];
+			os.walk(%q, function (entry) {
+				if (entry.isDir && entry.path.match(/skip$/)) {
+					return "skip";
+				}
+				if (entry.isDir === false) {
+					seen.push(entry.path.split("/").pop() + ":" + entry.size);
+				}
+			});
+			return seen.join(",");
+		}());
+	`, tmpDir))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "a.txt:3")
+
+	// "skip" for a file leaves its siblings to be visited
+	ioutil.WriteFile(path.Join(tmpDir, "keep", "b.txt"), []byte("abcd"), 0660)
+	ioutil.WriteFile(path.Join(tmpDir, "keep", "c.txt"), []byte("abcde"), 0660)
+	val, err = js.VM.Eval(fmt.Sprintf(`
+		(function () {
+			var seen = [];
+			os.walk(%q, function (entry) {
+				if (entry.path.match(/(skip|a\.txt)$/)) {
+					return "skip";
+				}
+				if (entry.isDir === false) {
+					seen.push(entry.path.split("/").pop());
+				}
+			});
+			return seen.join(",");
+		}());
+	`, tmpDir))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "b.txt,c.txt")
+}
+
+func TestStringsQuoting(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	testData := map[string]string{
+		`strings.shellQuote("hello world")`:              `'hello world'`,
+		`strings.shellQuote("it's")`:                     `'it'"'"'s'`,
+		`strings.shellQuote("$HOME; rm -rf *")`:          `'$HOME; rm -rf *'`,
+		`strings.urlEncode("a b&c=d/é")`:                 `a+b%26c%3Dd%2F%C3%A9`,
+		`strings.urlDecode("a+b%26c%3Dd%2F%C3%A9")`:      `a b&c=d/é`,
+		`strings.urlDecode(strings.urlEncode("x'y\"z"))`: `x'y"z`,
+		`strings.urlDecode("%zz").status`:                `error`,
+	}
+	for src, expected := range testData {
+		val, err := js.VM.Eval(src)
+		isOK(t, err, nil)
+		isOK(t, val.String(), expected)
+	}
+}
+
+func TestGrep(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`os.grep("testdata/sample.log", "ERROR").join("\n")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2016-10-01 09:01:12 ERROR can't open /data/missing.xlsx\n2016-10-01 09:03:30 ERROR timeout talking to upstream")
+	val, err = js.VM.Eval(`os.grep("testdata/sample.log", "INFO server (started|stopped)", {count: true})`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2")
+	val, err = js.VM.Eval(`os.grep("testdata/sample.log", "(unclosed").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestConsoleOutput(t *testing.T) {
+	// Watch the real stdout to make sure nothing is written there
+	realStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Errorf("Can't create pipe, %s", err)
+		t.FailNow()
+	}
+	os.Stdout = w
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	js.Stdout, js.Stderr = out, errOut
+	_, err = js.VM.Eval(`console.log("Hello", "World", 42); console.error("oops"); console.info("info"); console.warn("careful"); console.trace("here");`)
+
+	os.Stdout = realStdout
+	w.Close()
+	leaked, _ := ioutil.ReadAll(r)
+	isOK(t, err, nil)
+	isOK(t, out.String(), "Hello World 42\ninfo\n")
+	isOK(t, errOut.String(), "oops\ncareful\nhere\n")
+	isOK(t, string(leaked), "")
+}
+
+func TestExitHooks(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	exitCode := -1
+	js.ExitFunc = func(code int) {
+		exitCode = code
+	}
+	goHookRan := false
+	js.AtExit(func() {
+		goHookRan = true
+	})
+
+	_, err := js.Eval(`var flushed = false; os.atExit(function () { flushed = true; }); os.exit(7);`)
+	isOK(t, err, nil)
+	isOK(t, exitCode, 7)
+	isOK(t, goHookRan, true)
+	val, _ := js.VM.Get("flushed")
+	flushed, _ := val.ToBoolean()
+	isOK(t, flushed, true)
+
+	// A non-numeric exit code becomes 1, the hooks have already run so don't run again
+	goHookRan = false
+	_, err = js.Eval(`flushed = false; os.exit("oops");`)
+	isOK(t, err, nil)
+	isOK(t, exitCode, 1)
+	isOK(t, goHookRan, false)
+	val, _ = js.VM.Get("flushed")
+	flushed, _ = val.ToBoolean()
+	isOK(t, flushed, false)
+}
+
+func TestPageWith(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	vm := otto.New()
+	js := New(vm)
+	js.Stderr = new(bytes.Buffer)
+
+	// a pager which writes the text then fails doesn't cause the text to be written twice
+	out := new(bytes.Buffer)
+	isOK(t, js.pageWith([]string{"sh", "-c", "cat; exit 3"}, out, []byte("some help\n")), true)
+	isOK(t, out.String(), "some help\n")
+
+	// a pager which can't be started leaves the writing to page
+	out.Reset()
+	isOK(t, js.pageWith([]string{"ostdlib-no-such-pager"}, out, []byte("some help\n")), false)
+	isOK(t, out.String(), "")
+}
+
+func TestExitFunc(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	exitCode := -1
+	js.ExitFunc = func(code int) {
+		exitCode = code
+	}
+	_, err := js.Eval(`var after = false; os.exit(3); after = true;`)
+	isOK(t, err, nil)
+	isOK(t, exitCode, 3)
+	// the script stops once ExitFunc returns, even inside a try block
+	val, err := js.Eval(`after`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false")
+	_, err = js.Eval(`try { os.exit(4); } catch (e) {} after = true;`)
+	isOK(t, err, nil)
+	isOK(t, exitCode, 4)
+	val, err = js.Eval(`after`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false")
+
+	// Runner stops at the script calling os.exit()
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	first, second := path.Join(dname, "1-exit.js"), path.Join(dname, "2-after.js")
+	isOK(t, ioutil.WriteFile(first, []byte(`os.exit(5); after = true;`), 0664), nil)
+	isOK(t, ioutil.WriteFile(second, []byte(`after = true;`), 0664), nil)
+	isOK(t, js.RunDir(dname), nil)
+	isOK(t, exitCode, 5)
+	val, err = js.Eval(`after`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false")
+
+	// The repl's .exit goes through ExitFunc too
+	exitCode = -1
+	js.Stdout = new(bytes.Buffer)
+	js.ReplWithReader(&testLineReader{lines: []string{".exit"}})
+	isOK(t, exitCode, 0)
+}
+
+func TestWorkbookInferTypes(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var wb = xlsx.New(), records;
+		if (wb.read("testdata/Typed.xlsx") !== true) {
+			return "can't read testdata/Typed.xlsx";
+		}
+		records = wb.inferTypes("Sheet1", {coerce: {note: "string"}});
+		return [
+			records.length,
+			typeof records[0].amount,
+			records[1].amount,
+			records[0].date instanceof Date,
+			records[0].date.getUTCFullYear(),
+			typeof records[0].name,
+			typeof records[0].note
+		].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2,number,2,true,2016,string,string")
+
+	// coerce forces a column's type
+	val, err = js.VM.Eval(`(function () {
+		var wb = xlsx.New();
+		wb.read("testdata/Typed.xlsx");
+		return typeof wb.inferTypes("Sheet1", {coerce: {amount: "string"}})[0].amount;
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "string")
+
+	// Edits made through getSheet are seen
+	val, err = js.VM.Eval(`(function () {
+		var wb = xlsx.New();
+		wb.read("testdata/Typed.xlsx");
+		wb.getSheet("Sheet1")[2][0] = "7";
+		return wb.inferTypes("Sheet1").map(function (r) { return r.amount; }).join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "1,7")
+
+	// Numbers coerced to dates are Excel serial day numbers
+	val, err = js.VM.Eval(`(function () {
+		var wb = xlsx.New({Sheet1: [["when"], ["42370"], ["42370.5"]]}),
+			records = wb.inferTypes("Sheet1", {coerce: {when: "date"}});
+		return records.map(function (r) { return r.when.toISOString(); }).join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2016-01-01T00:00:00.000Z,2016-01-01T12:00:00.000Z")
+}
+
+func TestReplInterrupt(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	// Ctrl-C clears the unfinished line and the session carries on
+	rl := &testLineReader{lines: []string{"var partial = (", testInterrupt, "var answer = 40 + 2;", "answer"}}
+	js.ReplWithReader(rl)
+	isOK(t, len(rl.history), 2)
+	isOK(t, rl.history[0], "var answer = 40 + 2;")
+	if strings.Contains(out.String(), "42") == false {
+		t.Errorf("expected the session to continue after Ctrl-C, %q", out.String())
+	}
+
+	// A running script can be stopped
+	stop := make(chan error, 1)
+	stop <- fmt.Errorf("interrupted")
+	_, err := js.evalUntil(`while (true) {}`, stop)
+	if err == nil {
+		t.Errorf("expected the loop to be interrupted")
+		t.FailNow()
+	}
+	isOK(t, err.Error(), "interrupted")
+	val, err := js.VM.Eval(`1 + 1`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2")
+}
+
+func TestWithTempFileAndDir(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var seen = {};
+		seen.result = os.withTempFile(function (p) {
+			seen.file = p;
+			seen.fileExists = (os.readFile(p) === "");
+			return 42;
+		});
+		os.withTempDir(function (p) {
+			seen.dir = p;
+			os.writeFile(p + "/note.txt", "Hello World");
+			seen.dirExists = (os.readFile(p + "/note.txt") === "Hello World");
+			throw new Error("callback failed");
+		});
+		return JSON.stringify(seen);
+	}())`)
+	isOK(t, err, nil)
+	seen := struct {
+		Result     int    `json:"result"`
+		File       string `json:"file"`
+		FileExists bool   `json:"fileExists"`
+		Dir        string `json:"dir"`
+		DirExists  bool   `json:"dirExists"`
+	}{}
+	isOK(t, json.Unmarshal([]byte(val.String()), &seen), nil)
+	isOK(t, seen.Result, 42)
+	isOK(t, seen.FileExists, true)
+	isOK(t, seen.DirExists, true)
+	for _, p := range []string{seen.File, seen.Dir} {
+		if _, err := os.Stat(p); os.IsNotExist(err) == false {
+			t.Errorf("expected %q to be removed, %s", p, err)
+		}
+	}
+}
+
+func TestMakeExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("execute bits aren't supported on windows")
+	}
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "hello.bash")
+	ioutil.WriteFile(fname, []byte("#!/bin/bash\necho 'Hello World'\n"), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`[os.isExecutable(%q), os.makeExecutable(%q), os.isExecutable(%q)].join(",")`, fname, fname, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false,true,true")
+	info, err := os.Stat(fname)
+	isOK(t, err, nil)
+	isOK(t, info.Mode()&0111, os.FileMode(0111))
+
+	val, err = js.VM.Eval(fmt.Sprintf(`os.makeExecutable(%q).status`, path.Join(dname, "missing.bash")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestCopyGlob(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	ioutil.WriteFile(path.Join(dname, "a.txt"), []byte("A"), 0644)
+	ioutil.WriteFile(path.Join(dname, "b.txt"), []byte("B"), 0600)
+	ioutil.WriteFile(path.Join(dname, "c.md"), []byte("C"), 0644)
+	dist := path.Join(dname, "dist")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`os.copyGlob(%q, %q).length`, path.Join(dname, "*.txt"), dist))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2")
+	for name, contents := range map[string]string{"a.txt": "A", "b.txt": "B"} {
+		src, err := ioutil.ReadFile(path.Join(dist, name))
+		isOK(t, err, nil)
+		isOK(t, string(src), contents)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path.Join(dist, "b.txt"))
+		isOK(t, err, nil)
+		isOK(t, info.Mode().Perm(), os.FileMode(0600))
+	}
+	if _, err := os.Stat(path.Join(dist, "c.md")); os.IsNotExist(err) == false {
+		t.Errorf("expected c.md not to be copied, %s", err)
+	}
+
+	// the destination must be a directory
+	val, err = js.VM.Eval(fmt.Sprintf(`os.copyGlob(%q, %q).status`, path.Join(dname, "*.md"), path.Join(dname, "a.txt")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+
+	// matches already in the destination are skipped, not truncated
+	val, err = js.VM.Eval(fmt.Sprintf(`os.copyGlob(%q, %q, true).length`, path.Join(dname, "*.txt"), dname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "0")
+	for name, contents := range map[string]string{"a.txt": "A", "b.txt": "B"} {
+		src, err := ioutil.ReadFile(path.Join(dname, name))
+		isOK(t, err, nil)
+		isOK(t, string(src), contents)
+	}
+}
+
+func TestRemoveGlob(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	for _, name := range []string{"a.tmp", "b.tmp", "keep.tmp", "c.txt"} {
+		ioutil.WriteFile(path.Join(dname, name), []byte(name), 0644)
+	}
+	pattern := path.Join(dname, "*.tmp")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`os.removeGlob(%q).status`, pattern))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+
+	val, err = js.VM.Eval(fmt.Sprintf(`os.removeGlob(%q, function (p) { return p.indexOf("keep") < 0; }).length`, pattern))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2")
+	for name, exists := range map[string]bool{"a.tmp": false, "b.tmp": false, "keep.tmp": true, "c.txt": true} {
+		_, err := os.Stat(path.Join(dname, name))
+		isOK(t, err == nil, exists)
+	}
+
+	val, err = js.VM.Eval(fmt.Sprintf(`os.removeGlob(%q, {force: true}).join(",")`, pattern))
+	isOK(t, err, nil)
+	isOK(t, val.String(), path.Join(dname, "keep.tmp"))
+
+	if runtime.GOOS != "windows" {
+		// a dangling symbolic link is removed like a file
+		isOK(t, os.Symlink(path.Join(dname, "missing"), path.Join(dname, "dangling.tmp")), nil)
+		ioutil.WriteFile(path.Join(dname, "e.tmp"), []byte("e"), 0644)
+		val, err = js.VM.Eval(fmt.Sprintf(`os.removeGlob(%q, {force: true}).length`, pattern))
+		isOK(t, err, nil)
+		isOK(t, val.String(), "2")
+	}
+
+	// the error object lists what was removed before the failure
+	for _, name := range []string{"f.tmp", "g.tmp"} {
+		ioutil.WriteFile(path.Join(dname, name), []byte(name), 0644)
+	}
+	val, err = js.VM.Eval(fmt.Sprintf(`(function () {
+		var result = os.removeGlob(%q, function (p) {
+			if (p.match(/g\.tmp$/)) {
+				throw new Error("stop");
+			}
+			return true;
+		});
+		return [result.status, result.removed.join(",")].join(",");
+	}())`, pattern))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error,"+path.Join(dname, "f.tmp"))
+}
+
+func TestSameFileAndHashFile(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	a := path.Join(dname, "a.txt")
+	b := path.Join(dname, "b.txt")
+	c := path.Join(dname, "c.txt")
+	d := path.Join(dname, "d.txt")
+	ioutil.WriteFile(a, []byte("Hello World"), 0644)
+	ioutil.WriteFile(b, []byte("Hello World"), 0644)
+	ioutil.WriteFile(c, []byte("Hello Moon!"), 0644)
+	ioutil.WriteFile(d, []byte("Hello"), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`[os.sameFile(%q, %q), os.sameFile(%q, %q), os.sameFile(%q, %q)].join(",")`, a, b, a, c, a, d))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,false,false")
+
+	val, err = js.VM.Eval(fmt.Sprintf(`os.hashFile(%q, "sha256")`, a))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e")
+	val, err = js.VM.Eval(fmt.Sprintf(`os.hashFile(%q, "md5")`, a))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "b10a8db164e0754105b7a99be72e3fe5")
+	val, err = js.VM.Eval(fmt.Sprintf(`os.hashFile(%q, "crc32").status`, a))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestDisablePager(t *testing.T) {
+	fname := path.Join(os.TempDir(), "ostdlib-pager-history.js")
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("var x%d = %d;", i, i))
+	}
+	history := strings.Join(lines, "\n") + "\n"
+	ioutil.WriteFile(fname, []byte(history), 0600)
+	defer os.Remove(fname)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	js.DisablePager = true
+	js.HistoryFile = fname
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	js.ReplWithReader(&testLineReader{lines: []string{".list"}})
+	isOK(t, out.String(), history)
+
+	out.Reset()
+	js.GetHelp("", "")
+	for _, name := range js.helpObjects() {
+		if strings.Contains(out.String(), name) == false {
+			t.Errorf("expected %q in help, %q", name, out.String())
+		}
+	}
+	if strings.Contains(out.String(), ".save") == false {
+		t.Errorf("expected the dot commands in help, %q", out.String())
+	}
+}
+
+func TestWorkbookSheetNames(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`JSON.stringify(xlsx.sheetNames("testdata/Workbook1.xlsx"))`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), `["Sheet1","Sheet2"]`)
+
+	val, err = js.VM.Eval(`xlsx.sheetNames("testdata/missing.xlsx").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "forever.js")
+	ioutil.WriteFile(fname, []byte(`while (true) {}`), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.DefaultTimeout = 50 * time.Millisecond
+	js.ContinueOnError = true
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- js.Runner([]string{fname})
+	}()
+	select {
+	case err = <-finished:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected %s to time out", fname)
+		t.FailNow()
+	}
+	if err == nil || strings.Contains(err.Error(), "timed out") == false {
+		t.Errorf("expected a timeout error, %s", err)
+	}
+
+	// The VM is still usable after a timeout
+	val, err := js.VM.Eval(`1 + 1`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2")
+}
+
+func TestRenameOverwrite(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	src := path.Join(dname, "src.txt")
+	dst := path.Join(dname, "dst.txt")
+	ioutil.WriteFile(src, []byte("new"), 0644)
+	ioutil.WriteFile(dst, []byte("old"), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	// refused by default
+	val, err := js.VM.Eval(fmt.Sprintf(`os.rename(%q, %q).status`, src, dst))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+	buf, _ := ioutil.ReadFile(dst)
+	isOK(t, string(buf), "old")
+
+	// forced
+	val, err = js.VM.Eval(fmt.Sprintf(`os.rename(%q, %q, {overwrite: true})`, src, dst))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+	buf, _ = ioutil.ReadFile(dst)
+	isOK(t, string(buf), "new")
+	if _, err := os.Stat(src); os.IsNotExist(err) == false {
+		t.Errorf("expected %s to be gone, %s", src, err)
+	}
+}
+
+func TestFindInaccessible(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions can't deny access on windows or to root")
+	}
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	locked := path.Join(dname, "locked")
+	os.MkdirAll(locked, 0755)
+	ioutil.WriteFile(path.Join(dname, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(path.Join(locked, "b.txt"), []byte("b"), 0644)
+	os.Chmod(locked, 0000)
+	defer os.Chmod(locked, 0755)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+		var result = os.find(%q, {withErrors: true});
+		return [result.paths.indexOf(%q) >= 0, result.errors.length, result.errors[0].path].join(",");
+	}())`, dname, path.Join(dname, "a.txt")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,1,"+locked)
+
+	val, err = js.VM.Eval(fmt.Sprintf(`(function () {
+		var seen = [], failed = [];
+		os.walk(%q, function (entry) { seen.push(entry.path); }, function (failure) { failed.push(failure.path); });
+		return [seen.indexOf(%q) >= 0, failed.join(",")].join(",");
+	}())`, dname, path.Join(dname, "a.txt")))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,"+locked)
+}
+
+func TestDumpWorkbookJSON(t *testing.T) {
+	src, err := DumpWorkbookJSON("testdata/Workbook1.xlsx")
+	isOK(t, err, nil)
+	data := map[string][]map[string]string{}
+	if err := json.Unmarshal([]byte(src), &data); err != nil {
+		t.Errorf("expected valid JSON, %s, %s", err, src)
+		t.FailNow()
+	}
+	isOK(t, len(data), 2)
+	isOK(t, len(data["Sheet1"]), 2)
+	isOK(t, len(data["Sheet2"]), 2)
+	isOK(t, data["Sheet1"][0]["Column B"], "one")
+	// Sheets are kept in workbook order
+	if strings.Index(src, `"Sheet1"`) > strings.Index(src, `"Sheet2"`) {
+		t.Errorf("expected Sheet1 before Sheet2, %s", src)
+	}
+
+	_, err = DumpWorkbookJSON("testdata/missing.xlsx")
+	if err == nil {
+		t.Errorf("expected an error for a missing workbook")
+	}
+
+	// Repeated headers don't overwrite earlier columns
+	tmpDir, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp dir, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	fname := path.Join(tmpDir, "repeated.xlsx")
+	js := New(otto.New())
+	js.AddExtensions()
+	_, err = js.VM.Eval(fmt.Sprintf(`xlsx.write(%q, {Sheet1: [["name", "name", ""], ["a", "b", "c", "d"]]})`, fname))
+	isOK(t, err, nil)
+	src, err = DumpWorkbookJSON(fname)
+	isOK(t, err, nil)
+	data = map[string][]map[string]string{}
+	isOK(t, json.Unmarshal([]byte(src), &data), nil)
+	record := data["Sheet1"][0]
+	isOK(t, len(record), 4)
+	isOK(t, record["name"]+record["name_2"]+record["column_3"]+record["column_4"], "abcd")
+}
+
+// testMoney is an amount in cents, JavaScript gives it as a string like "$12.34"
+type testMoney int64
+
+func TestRegisterConverter(t *testing.T) {
+	RegisterConverter(reflect.TypeOf(testMoney(0)), func(val interface{}) (interface{}, error) {
+		s, ok := val.(string)
+		if ok == false {
+			return nil, fmt.Errorf("expected a string, got %T", val)
+		}
+		f, err := strconv.ParseFloat(strings.TrimPrefix(s, "$"), 64)
+		if err != nil {
+			return nil, err
+		}
+		return testMoney(math.Round(f * 100)), nil
+	})
+	defer RegisterConverter(reflect.TypeOf(testMoney(0)), nil)
+
+	vm := otto.New()
+	val, err := vm.Run(`({name: "Widget", price: "$12.34"})`)
+	isOK(t, err, nil)
+	item := struct {
+		Name  string    `json:"name"`
+		Price testMoney `json:"price"`
+	}{}
+	err = ToStruct(val, &item)
+	isOK(t, err, nil)
+	isOK(t, item.Name, "Widget")
+	isOK(t, int(item.Price), 1234)
+
+	// Errors from a converter are returned
+	val, _ = vm.Run(`({name: "Gadget", price: "free"})`)
+	err = ToStruct(val, &item)
+	if err == nil {
+		t.Errorf("expected an error converting \"free\"")
+	}
+}
+
+func TestReadWriteJSON(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "config.json")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+		var config = {name: "ostdlib", version: 7, tags: ["otto", "js"], nested: {ok: true}}, copy;
+		if (os.writeJSON(%q, config, true) !== true) {
+			return "write failed";
+		}
+		copy = os.readJSON(%q);
+		return JSON.stringify(copy) === JSON.stringify(config);
+	}())`, fname, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+	buf, _ := ioutil.ReadFile(fname)
+	if strings.Contains(string(buf), "\n    \"name\"") == false {
+		t.Errorf("expected indented JSON, %s", buf)
+	}
+
+	ioutil.WriteFile(fname, []byte("{not json"), 0644)
+	val, err = js.VM.Eval(fmt.Sprintf(`os.readJSON(%q).status`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestCompletePath(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	ioutil.WriteFile(filepath.Join(dname, "history.js"), []byte("var x = 1;"), 0644)
+	os.MkdirAll(filepath.Join(dname, "scripts"), 0755)
+
+	matches := completePath(".load " + dname + string(filepath.Separator))
+	isOK(t, strings.Join(matches, ","), filepath.Join(dname, "history.js")+","+filepath.Join(dname, "scripts")+string(filepath.Separator))
+	matches = completePath(".save " + filepath.Join(dname, "hist"))
+	isOK(t, strings.Join(matches, ","), filepath.Join(dname, "history.js"))
+
+	// .load and .save are wired to complete paths
+	vm := otto.New()
+	js := New(vm)
+	js.AddAutoComplete()
+	wired := 0
+	for _, item := range js.AutoCompleter.GetChildren() {
+		name := strings.TrimSpace(string(item.GetName()))
+		if name != ".load" && name != ".save" {
+			continue
+		}
+		for _, child := range item.GetChildren() {
+			if pc, ok := child.(*readline.PrefixCompleter); ok == true && pc.Dynamic == true {
+				wired++
+			}
+		}
+	}
+	isOK(t, wired, 2)
+}
+
+func TestHTTPDownload(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unsized" {
+			// flushing first sends the body chunked, without a Content-Length
+			w.(http.Flusher).Flush()
+			w.Write(body)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer ts.Close()
+
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "data.bin")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+		var calls = 0, last = {}, result;
+		result = http.download(%q, %q, {onProgress: function (p) { calls++; last = p; }});
+		return [calls > 0, last.bytes, last.total, result.bytes, result.total].join(",");
+	}())`, ts.URL, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,100000,100000,100000,100000")
+	buf, _ := ioutil.ReadFile(fname)
+	isOK(t, bytes.Equal(buf, body), true)
+
+	// Resume a partial download
+	ioutil.WriteFile(fname, body[0:40000], 0644)
+	val, err = js.VM.Eval(fmt.Sprintf(`(function () {
+		var first = null, result;
+		result = http.download(%q, %q, {resume: true, onProgress: function (p) { if (first === null) { first = p.bytes; } }});
+		return [first > 40000, result.bytes, result.total].join(",");
+	}())`, ts.URL, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,100000,100000")
+	mu.Lock()
+	isOK(t, ranges[len(ranges)-1], "bytes=40000-")
+	mu.Unlock()
+	buf, _ = ioutil.ReadFile(fname)
+	isOK(t, bytes.Equal(buf, body), true)
+
+	// Without a Content-Length the total is null
+	val, err = js.VM.Eval(fmt.Sprintf(`(function () {
+		var last = {}, result;
+		result = http.download(%q, %q, {onProgress: function (p) { last = p; }});
+		return [last.total === null, result.bytes, result.total === null].join(",");
+	}())`, ts.URL+"/unsized", fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,100000,true")
+}
+
+func TestMktree(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	base := path.Join(dname, "project")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`os.mktree(%q, {
+		"README.md": "# Project",
+		src: {"main.js": "console.log('hi');", lib: {}},
+		docs: {"index.md": ""}
+	})`, base))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+	for _, dir := range []string{"src", "src/lib", "docs"} {
+		info, err := os.Stat(path.Join(base, dir))
+		if err != nil || info.IsDir() == false {
+			t.Errorf("expected directory %s, %s", dir, err)
+		}
+	}
+	for fname, expected := range map[string]string{"README.md": "# Project", "src/main.js": "console.log('hi');", "docs/index.md": ""} {
+		buf, err := ioutil.ReadFile(path.Join(base, fname))
+		isOK(t, err, nil)
+		isOK(t, string(buf), expected)
+	}
+
+	val, err = js.VM.Eval(fmt.Sprintf(`os.mktree(%q, {"../escape": "oops"}).status`, base))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestDisableHistory(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.DisableHistory = true
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	js.ReplWithReader(&testLineReader{lines: []string{".list"}})
+	isOK(t, out.String(), "History is disabled\n")
+
+	out.Reset()
+	js.ReplWithReader(&testLineReader{lines: []string{".save history.js", ".reset"}})
+	isOK(t, out.String(), "History is disabled\nHistory is disabled\n")
+	if _, err := os.Stat("history.js"); os.IsNotExist(err) == false {
+		t.Errorf("expected .save to write nothing, %s", err)
+	}
+}
+
+func TestApropos(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	js.SetHelp("config", "load", []string{"filepath string"}, "Reads settings, values may refer to the ENVIRONMENT")
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	js.ReplWithReader(&testLineReader{lines: []string{".apropos environment"}})
+	s := out.String()
+	for _, expected := range []string{"os.getEnv(envvar string)", "os.setEnv(envvar string)", "config.load(filepath string)"} {
+		if strings.Contains(s, expected) == false {
+			t.Errorf("expected %q in .apropos output, %q", expected, s)
+		}
+	}
+	if strings.Contains(s, "os.exit") == true {
+		t.Errorf("expected only matching functions, %q", s)
+	}
+
+	out.Reset()
+	js.ReplWithReader(&testLineReader{lines: []string{".apropos nosuchthing"}})
+	isOK(t, out.String(), "Nothing found for \"nosuchthing\"\n")
+}
+
+func TestReplExport(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "session.js")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.DisableHistory = true
+	js.Stdout = new(bytes.Buffer)
+	js.ReplWithReader(&testLineReader{lines: []string{
+		"var greeting = 'Hello';",
+		"noSuchFunction(greeting);",
+		"greeting += ' World';",
+		".export " + fname,
+	}})
+	src, err := ioutil.ReadFile(fname)
+	isOK(t, err, nil)
+	isOK(t, string(src), "var greeting = 'Hello';\ngreeting += ' World';\n")
+
+	// the exported script reproduces the session
+	other := New(otto.New())
+	other.AddExtensions()
+	isOK(t, other.Run(fname), nil)
+	val, err := other.VM.Eval(`greeting`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "Hello World")
+}
+
+func TestCompareValue(t *testing.T) {
+	vm := otto.New()
+	val, err := vm.Run(`({name: "ostdlib", count: 3, ratio: 0.25, ok: true})`)
+	isOK(t, err, nil)
+	obj := val.Object()
+	get := func(name string) interface{} {
+		v, _ := obj.Get(name)
+		raw, _ := v.Export()
+		return raw
+	}
+
+	// matches, numbers compare across Go types
+	for _, test := range []struct {
+		expected, actual interface{}
+	}{
+		{"ostdlib", get("name")},
+		{3, get("count")},
+		{int64(3), 3.0},
+		{0.25, get("ratio")},
+		{true, get("ok")},
+		{[]string{"a"}, []string{"a"}},
+	} {
+		if err := CompareValue(test.expected, test.actual); err != nil {
+			t.Errorf("expected %v to match %v, %s", test.expected, test.actual, err)
+		}
+	}
+
+	// mismatches
+	for _, test := range []struct {
+		expected, actual interface{}
+	}{
+		{"ostdlib", "otto"},
+		{3, 4},
+		{0.25, 0.5},
+		{1, "1"},
+		{true, false},
+		{true, "true"},
+	} {
+		if err := CompareValue(test.expected, test.actual); err == nil {
+			t.Errorf("expected %v not to match %v", test.expected, test.actual)
+		}
+	}
+}
+
+func TestWorkbookWriteSheetOrder(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "ordered.xlsx")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	// repeat to catch a random order that happens to match
+	for i := 0; i < 5; i++ {
+		val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+			var data = {};
+			data["Zeta"] = [["z"]];
+			data["Alpha"] = [["a"]];
+			data["Mid"] = [["m"]];
+			xlsx.write(%q, data);
+			return JSON.stringify(xlsx.sheetNames(%q));
+		}())`, fname, fname))
+		isOK(t, err, nil)
+		isOK(t, val.String(), `["Zeta","Alpha","Mid"]`)
+	}
+}
+
+func TestTail(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`os.tail("testdata/sample.log", 2).join("|")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2016-10-01 09:03:30 ERROR timeout talking to upstream|2016-10-01 09:04:00 INFO server stopped")
+
+	// Asking for more lines than the file has returns them all
+	val, err = js.VM.Eval(`os.tail("testdata/sample.log", 100).length`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "6")
+
+	// Lines longer than a block are returned whole
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "long.log")
+	long := strings.Repeat("x", 10000)
+	ioutil.WriteFile(fname, []byte("first\n"+long+"\nlast"), 0644)
+	lines, err := tailLines(fname, 2)
+	isOK(t, err, nil)
+	isOK(t, len(lines), 2)
+	isOK(t, lines[0], long)
+	isOK(t, lines[1], "last")
+
+	val, err = js.VM.Eval(`os.tail("testdata/missing.log", 2).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestReadlineConfig(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.Stdout = new(bytes.Buffer)
+	js.ReadlineConfig = &readline.Config{Prompt: "js> ", HistoryLimit: 5000}
+
+	rl := &testLineReader{lines: []string{"var x = (", ".break", "1 + 1"}}
+	js.ReplWithReader(rl)
+	isOK(t, strings.Join(rl.prompts, ","), "01: ,js> ,js> ")
+}
+
+func TestHTTPDebug(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "short and stout")
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	buf := new(bytes.Buffer)
+	js.HTTPLogger = log.New(buf, "", 0)
+	_, err := js.VM.Eval(fmt.Sprintf(`http.setDebug(true); http.get(%q, [{"Authorization": "Bearer secret"}]);`, ts.URL))
+	isOK(t, err, nil)
+	s := buf.String()
+	for _, expected := range []string{"GET " + ts.URL, "418", "Authorization: [redacted]", "text/plain"} {
+		if strings.Contains(s, expected) == false {
+			t.Errorf("expected %q in log, %q", expected, s)
+		}
+	}
+	if strings.Contains(s, "secret") == true {
+		t.Errorf("expected Authorization to be redacted, %q", s)
+	}
+
+	buf.Reset()
+	_, err = js.VM.Eval(fmt.Sprintf(`http.setDebug(false); http.get(%q);`, ts.URL))
+	isOK(t, err, nil)
+	isOK(t, buf.String(), "")
+}
+
+func TestHTTPHeaderShapes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s", r.Header.Get("X-Test"), r.Header.Get("X-Other"))
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	for _, headers := range []string{`{"X-Test": "1", "X-Other": "2"}`, `[{"X-Test": "1"}, {"X-Other": "2"}]`} {
+		val, err := js.VM.Eval(fmt.Sprintf(`http.get(%q, %s)`, ts.URL, headers))
+		isOK(t, err, nil)
+		isOK(t, val.String(), "1|2")
+		val, err = js.VM.Eval(fmt.Sprintf(`http.post(%q, "text/plain", "", %s)`, ts.URL, headers))
+		isOK(t, err, nil)
+		isOK(t, val.String(), "1|2")
+	}
+}
+
+// recordingTransport notes the URL of each request before passing it on
+type recordingTransport struct {
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSetHTTPTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World")
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	rt := new(recordingTransport)
+	js.SetHTTPTransport(rt)
+	val, err := js.VM.Eval(fmt.Sprintf(`http.get(%q)`, ts.URL+"/hello"))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "Hello World")
+	isOK(t, strings.Join(rt.urls, ","), ts.URL+"/hello")
+
+	// requests no longer go through rt once the transport is reset
+	js.SetHTTPTransport(nil)
+	_, err = js.VM.Eval(fmt.Sprintf(`http.get(%q)`, ts.URL+"/again"))
+	isOK(t, err, nil)
+	isOK(t, len(rt.urls), 1)
+}
+
+func TestSplitPathAndVolumeName(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	windows := runtime.GOOS == "windows"
+	sep := string(filepath.Separator)
+	for _, test := range []struct {
+		pathname, dir, file, volume string
+	}{
+		{"data" + sep + "report.xlsx", "data" + sep, "report.xlsx", ""},
+		{"report.xlsx", "", "report.xlsx", ""},
+		{sep + "tmp" + sep, sep + "tmp" + sep, "", ""},
+	} {
+		val, err := js.VM.Eval(fmt.Sprintf(`(function () { var p = os.splitPath(%q); return [p.dir, p.file, os.volumeName(%q)].join("|"); }())`, test.pathname, test.pathname))
+		isOK(t, err, nil)
+		isOK(t, val.String(), strings.Join([]string{test.dir, test.file, test.volume}, "|"))
+	}
+
+	// Windows paths only have a volume and backslash separators on Windows
+	winPath := `C:\Users\otto\report.xlsx`
+	dir, file, volume := "", winPath, ""
+	if windows == true {
+		dir, file, volume = `C:\Users\otto\`, "report.xlsx", "C:"
+	}
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () { var p = os.splitPath(%q); return [p.dir, p.file, os.volumeName(%q)].join("|"); }())`, winPath, winPath))
+	isOK(t, err, nil)
+	isOK(t, val.String(), strings.Join([]string{dir, file, volume}, "|"))
+}
+
+func TestLazyExtensions(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	isOK(t, js.installs["xlsx"], 0)
+
+	// strings is eager, touching it doesn't install xlsx
+	_, err := js.VM.Eval(`strings.urlEncode("a b")`)
+	isOK(t, err, nil)
+	isOK(t, js.installs["xlsx"], 0)
+
+	// first use installs it, later uses don't install it again
+	val, err := js.VM.Eval(`typeof xlsx.read`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "function")
+	isOK(t, js.installs["xlsx"], 1)
+	val, err = js.VM.Eval(`typeof Workbook.getSheetNames`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "function")
+	isOK(t, js.installs["xlsx"], 1)
+	isOK(t, js.installs["os"], 0)
+
+	// Workbook installs xlsx too
+	vm = otto.New()
+	js = New(vm)
+	js.AddExtensions()
+	val, err = js.VM.Eval(`typeof Workbook.read`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "function")
+	isOK(t, js.installs["xlsx"], 1)
+
+	// EagerExtensions installs everything up front
+	vm = otto.New()
+	js = New(vm)
+	js.EagerExtensions = true
+	js.AddExtensions()
+	isOK(t, js.installs["os"], 1)
+	isOK(t, js.installs["http"], 1)
+	isOK(t, js.installs["xlsx"], 1)
+}
+
+func TestWorkbookAppend(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "append.xlsx")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+		var data = {}, results = [];
+		data["Log"] = [["when", "what"], ["monday", "started"]];
+		data["Other"] = [["keep me"]];
+		results.push(xlsx.write(%q, data));
+		results.push(xlsx.append(%q, "Log", [["tuesday", "finished"], ["wednesday", 3]]));
+		results.push(xlsx.append(%q, "New", [["fresh"]]));
+		return results.join(",");
+	}())`, fname, fname, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,true,true")
+
+	val, err = js.VM.Eval(fmt.Sprintf(`JSON.stringify(xlsx.read(%q))`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), `{"Log":[["when","what"],["monday","started"],["tuesday","finished"],["wednesday","3"]],"Other":[["keep me"]],"New":[["fresh"]]}`)
+
+	// A missing workbook is created
+	fname = path.Join(dname, "created.xlsx")
+	val, err = js.VM.Eval(fmt.Sprintf(`xlsx.append(%q, "Sheet1", [["a", "b"]]) && JSON.stringify(xlsx.read(%q))`, fname, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), `{"Sheet1":[["a","b"]]}`)
+}
+
+func TestOnError(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	good := path.Join(dname, "good.js")
+	bad := path.Join(dname, "bad.js")
+	ioutil.WriteFile(good, []byte(`var x = 1;`), 0644)
+	ioutil.WriteFile(bad, []byte(`throw new Error("something broke");`), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.ContinueOnError = true
+	var errs []error
+	js.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+	js.Runner([]string{good, bad})
+	isOK(t, len(errs), 1)
+	if len(errs) == 1 && strings.Contains(errs[0].Error(), "something broke") == false {
+		t.Errorf("expected the thrown error, %s", errs[0])
+	}
+
+	// errors in the repl are reported too
+	errs = nil
+	js.Stdout = new(bytes.Buffer)
+	js.ReplWithReader(&testLineReader{lines: []string{"1 + 1", "undefinedFunction()"}})
+	isOK(t, len(errs), 1)
+}
+
+func TestSemVer(t *testing.T) {
+	major, minor, patch := SemVer()
+	isOK(t, fmt.Sprintf("%d.%d.%d", major, minor, patch), Version)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`[version.major(), version.minor(), version.patch()].join(".")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), Version)
+	val, err = js.VM.Eval(`version.string()`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), Version)
+}
+
+func TestCSVInfer(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "people.csv")
+	ioutil.WriteFile(fname, []byte("name,age,score,active,zip\nAlice,42,-1.5,true,02138\nBob,7,2e3,FALSE,91125\n"), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`(function () {
+		var rows = csv.read(%q, {infer: true});
+		return rows[1].map(function (cell) { return typeof cell + ":" + cell; }).join(",");
+	}())`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "string:Alice,number:42,number:-1.5,boolean:true,string:02138")
+
+	val, err = js.VM.Eval(fmt.Sprintf(`(function () {
+		var types = [];
+		csv.forEach(%q, function (row, rowNo) {
+			if (rowNo === 3) {
+				types = row.map(function (cell) { return typeof cell; });
+			}
+		}, {infer: true});
+		return types.join(",");
+	}())`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "string,number,number,boolean,number")
+
+	// without infer every cell is a string
+	val, err = js.VM.Eval(fmt.Sprintf(`csv.read(%q)[1].map(function (cell) { return typeof cell; }).join(",")`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "string,string,string,string,string")
+}
+
+func TestFmtx(t *testing.T) {
+	for _, test := range []struct {
+		n        float64
+		decimals int
+		expected string
+	}{
+		{0, 0, "0"},
+		{0, 2, "0.00"},
+		{999, 0, "999"},
+		{1000, 0, "1,000"},
+		{1234567.891, 2, "1,234,567.89"},
+		{-1234567.891, 0, "-1,234,568"},
+		{0.5, 1, "0.5"},
+		{-0.001, 1, "0.0"},
+		{1e15, 0, "1,000,000,000,000,000"},
+	} {
+		isOK(t, formatNumber(test.n, test.decimals), test.expected)
+	}
+
+	for _, test := range []struct {
+		n        float64
+		expected string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1024, "1.0 KB"},
+		{1572864, "1.5 MB"},
+		{5 * 1024 * 1024 * 1024 * 1024, "5.0 TB"},
+	} {
+		isOK(t, formatBytes(test.n), test.expected)
+	}
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	for src, expected := range map[string]string{
+		`fmtx.number(1234.6)`:      "1,235",
+		`fmtx.number(1234.5, 1)`:   "1,234.5",
+		`fmtx.bytes(1572864)`:      "1.5 MB",
+		`fmtx.percent(0)`:          "0%",
+		`fmtx.percent(0.256, 1)`:   "25.6%",
+		`fmtx.percent(12.5)`:       "1,250%",
+		`fmtx.percent(0.33333, 2)`: "33.33%",
+	} {
+		val, err := js.VM.Eval(src)
+		isOK(t, err, nil)
+		isOK(t, val.String(), expected)
+	}
+}
+
+func TestXLSXReadEncrypted(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`JSON.stringify(xlsx.readEncrypted("testdata/Encrypted.xlsx", "secret")) === JSON.stringify(xlsx.read("testdata/Workbook1.xlsx"))`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+
+	val, err = js.VM.Eval(`xlsx.readEncrypted("testdata/Encrypted.xlsx", "not the password").error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), "incorrect password") == false {
+		t.Errorf("expected an incorrect password error, %s", val.String())
+	}
+
+	// xlsx.read explains why it can't open an encrypted workbook
+	val, err = js.VM.Eval(`xlsx.read("testdata/Encrypted.xlsx").error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), "xlsx.readEncrypted") == false {
+		t.Errorf("expected xlsx.read to suggest xlsx.readEncrypted, %s", val.String())
+	}
+
+	// A negative key size or block size is an error rather than a panic, a spinCount outside
+	// MS-OFFCRYPTO's limit is refused before hashing (edits keep the stream's length)
+	src, err := ioutil.ReadFile("testdata/Encrypted.xlsx")
+	isOK(t, err, nil)
+	for _, edit := range [][]string{
+		{`keyBits="256"`, `keyBits="-25"`},
+		{`blockSize="16"`, `blockSize="-1"`},
+		{`spinCount="100000"`, `spinCount="-10000"`},
+		{`spinCount="100000" saltSize=`, `spinCount="20000000" saltSz=`},
+	} {
+		_, err = decryptXLSX(bytes.Replace(src, []byte(edit[0]), []byte(edit[1]), -1), "secret")
+		if err == nil || strings.Contains(err.Error(), "invalid EncryptionInfo") == false {
+			t.Errorf("expected an invalid EncryptionInfo error for %s, %v", edit[1], err)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "missing.js")
+	ioutil.WriteFile(fname, []byte(`os.readFile("testdata/no-such-file.txt");`), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.JSONErrors = true
+	isOK(t, js.Run(fname), nil)
+	entry := map[string]string{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Errorf("expected a JSON log line, %s, %q", err, buf.String())
+		t.FailNow()
+	}
+	isOK(t, entry["level"], "error")
+	isOK(t, entry["op"], "os.readFile")
+	if strings.HasPrefix(entry["location"], fname+":1:") == false {
+		t.Errorf("expected a location in %s, %+v", fname, entry)
+	}
+	if strings.Contains(entry["message"], "no-such-file.txt") == false {
+		t.Errorf("expected the message to name the file, %+v", entry)
+	}
+
+	// plain text is logged by default
+	buf.Reset()
+	js.JSONErrors = false
+	js.VM.Eval(`os.readFile("testdata/no-such-file.txt");`)
+	if strings.HasPrefix(buf.String(), "{") == true {
+		t.Errorf("expected a plain text log line, %q", buf.String())
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	names := strings.Join(js.Functions(), " ")
+	for _, expected := range []string{"console.log", "os.exit", "http.get", "xlsx.read", "Workbook.getSheet", "strings.shellQuote"} {
+		if strings.Contains(" "+names+" ", " "+expected+" ") == false {
+			t.Errorf("expected %s in Functions(), %s", expected, names)
+		}
+	}
+	isOK(t, strings.Join(js.UndocumentedFunctions(), ","), "")
+
+	// functions added without help are reported
+	js.Register("demo", "hello", func(call otto.FunctionCall) otto.Value {
+		return otto.UndefinedValue()
+	}, []string{}, "says hello")
+	_, err := js.VM.Eval(`demo.goodbye = function () {};`)
+	isOK(t, err, nil)
+	isOK(t, strings.Join(js.UndocumentedFunctions(), ","), "demo.goodbye")
+}
+
+func TestXLSXReadFormulas(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var sheet = xlsx.read("testdata/Formulas.xlsx", {formulas: true})["Sheet1"];
+		return [sheet[1][0].value, String(sheet[1][0].formula), sheet[3][0].formula].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "1|undefined|=SUM(A2:A3)")
+
+	// cells are plain strings without the option
+	val, err = js.VM.Eval(`typeof xlsx.read("testdata/Formulas.xlsx")["Sheet1"][3][0]`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "string")
+}
+
+func TestHelpTheme(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() {
+		color.NoColor = noColor
+	}()
+
+	vm := otto.New()
+	js := New(vm)
+	js.SetHelp("greet", "hello", []string{"name string"}, "Says hello to name")
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	js.DisablePager = true
+
+	// without a theme help is plain text
+	js.GetHelp("greet", "hello")
+	isOK(t, out.String(), "greet\n  greet.hello(name string)\n    Says hello to name\n")
+
+	out.Reset()
+	js.HelpTheme = &HelpTheme{
+		Object:    []color.Attribute{color.FgRed},
+		Signature: []color.Attribute{color.FgGreen},
+		Params:    []color.Attribute{color.FgYellow},
+		Doc:       []color.Attribute{color.FgCyan},
+	}
+	js.GetHelp("greet", "hello")
+	s := out.String()
+	for _, expected := range []string{"\x1b[31mgreet\x1b[0m", "\x1b[32mgreet.hello(\x1b[0m", "\x1b[33mname string\x1b[0m", "\x1b[36mSays hello to name\x1b[0m"} {
+		if strings.Contains(s, expected) == false {
+			t.Errorf("expected %q in %q", expected, s)
+		}
+	}
+
+	// color.NoColor turns the theme off
+	out.Reset()
+	color.NoColor = true
+	js.GetHelp("greet", "hello")
+	isOK(t, out.String(), "greet\n  greet.hello(name string)\n    Says hello to name\n")
+}
+
+func TestJSONWriteArray(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "export.json")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`json.writeArray(%q, function (i) {
+		if (i < 1000) {
+			return {id: i, name: "item " + i};
+		}
+	})`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "1000")
+
+	src, err := ioutil.ReadFile(fname)
+	isOK(t, err, nil)
+	records := []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}{}
+	isOK(t, json.Unmarshal(src, &records), nil)
+	isOK(t, len(records), 1000)
+	if len(records) == 1000 {
+		isOK(t, records[999].ID, 999)
+		isOK(t, records[999].Name, "item 999")
+	}
+
+	// an empty source writes an empty array
+	val, err = js.VM.Eval(fmt.Sprintf(`json.writeArray(%q, function (i) {})`, fname))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "0")
+	src, _ = ioutil.ReadFile(fname)
+	isOK(t, string(src), "[]\n")
+}
+
+func TestExecEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	defer os.Unsetenv("OSTDLIB_GREETING")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		os.setEnv("OSTDLIB_GREETING", "Hello World");
+		var inherited = os.exec("sh", ["-c", "echo \"$OSTDLIB_GREETING\""]),
+			clean = os.exec("sh", ["-c", "echo \"[$OSTDLIB_GREETING]\""], {inheritEnv: false}),
+			extra = os.exec("sh", ["-c", "echo \"$OSTDLIB_GREETING, $OSTDLIB_EXTRA\"; exit 3"], {env: {OSTDLIB_EXTRA: "Hi"}});
+		return [inherited.stdout, clean.stdout, extra.stdout, extra.exitCode].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "Hello World\n|[]\n|Hello World, Hi\n|3")
+}
+
+func TestPipeline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var upper = os.pipeline([
+				{cmd: "echo", args: ["hello"]},
+				{cmd: "tr", args: ["a-z", "A-Z"]}
+			]),
+			grep = os.pipeline([
+				{cmd: "sh", args: ["-c", "echo one; echo two; echo three"]},
+				{cmd: "grep", args: ["t"]}
+			]),
+			none = os.pipeline([
+				{cmd: "echo", args: ["hello"]},
+				{cmd: "grep", args: ["bye"]}
+			]);
+		return [upper.stdout, upper.code, grep.stdout, none.code].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "HELLO\n|0|two\nthree\n|1")
+
+	val, err = js.VM.Eval(`os.pipeline([{cmd: "echo", args: ["hello"]}, {cmd: "ostdlib-no-such-command"}])`)
+	isOK(t, err, nil)
+	obj := val.Object()
+	status, _ := obj.Get("status")
+	isOK(t, status.String(), "error")
+	msg, _ := obj.Get("error")
+	isOK(t, strings.Contains(msg.String(), "stage 1"), true)
+}
+
+func TestValidUTF8(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	if err != nil {
+		t.Errorf("Can't create temp directory, %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dname)
+	bad := path.Join(dname, "bad.txt")
+	good := path.Join(dname, "good.txt")
+	ioutil.WriteFile(bad, []byte("caf\xe9 \xff\xfe ok"), 0644)
+	ioutil.WriteFile(good, []byte("café ok"), 0644)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(fmt.Sprintf(`[strings.isValidUTF8(os.readFile(%q)), strings.isValidUTF8(os.readFile(%q))].join(",")`, bad, good))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false,true")
+
+	val, err = js.VM.Eval(fmt.Sprintf(`strings.toValidUTF8(os.readFile(%q), "?")`, bad))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "caf? ? ok")
+	val, err = js.VM.Eval(fmt.Sprintf(`strings.toValidUTF8(os.readFile(%q))`, bad))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "caf\uFFFD \uFFFD ok")
+	val, err = js.VM.Eval(fmt.Sprintf(`strings.isValidUTF8(strings.toValidUTF8(os.readFile(%q)))`, bad))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+}
+
+func TestXLSXRecords(t *testing.T) {
+	isOK(t, strings.Join(recordKeys([]string{"id", "", "name", "name", " id "}), ","), "id,column_2,name,name_2,id_2")
+	// generated keys never collide with the header's own names
+	isOK(t, strings.Join(recordKeys([]string{"name", "name", "name_2", "", "column_4"}), ","), "name,name_2,name_2_2,column_4,column_4_2")
+	isOK(t, recordsMarkup([][]sheetCell{{{"a", `"a"`}, {"column_3", `"column_3"`}}, {{"1", "1"}, {"2", "2"}, {"3", "3"}}}), `[{"a":1,"column_3":2,"column_3_2":3}]`)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var records = xlsx.records("testdata/Typed.xlsx", "Sheet1");
+		return [
+			records.length,
+			Object.keys(records[0]).join(","),
+			typeof records[0].amount,
+			records[1].amount,
+			records[1].name,
+			records[0].date instanceof Date
+		].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2|amount,name,date,note|number|2|two|true")
+
+	val, err = js.VM.Eval(`xlsx.records("testdata/Typed.xlsx", "Sheet1")[0].date.toISOString()`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2016-01-01T00:00:00.000Z")
+
+	// the empty header of a merged range is keyed column_N
+	val, err = js.VM.Eval(`JSON.stringify(xlsx.records("testdata/Merged.xlsx", "Sheet1"))`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), `[{"name":"alice","scores":1,"column_3":2},{"name":"bob","scores":3,"column_3":4}]`)
+
+	val, err = js.VM.Eval(`xlsx.records("testdata/Typed.xlsx", "NoSuchSheet").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestMustEval(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	val := js.MustEval("sum.js", "1 + 2")
+	i, err := val.ToInteger()
+	isOK(t, err, nil)
+	isOK(t, i, int64(3))
+
+	msg := func() (msg string) {
+		defer func() {
+			if r := recover(); r != nil {
+				msg = fmt.Sprintf("%s", r)
+			}
+		}()
+		js.MustEval("broken.js", "throw new Error('oops');")
+		return ""
+	}()
+	if strings.Contains(msg, "broken.js") == false || strings.Contains(msg, "oops") == false {
+		t.Errorf("expected panic naming broken.js, got %q", msg)
+	}
+}
+
+func TestConcurrentEval(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	if _, err := js.Eval("var count = 0; function double(n) { count++; return n * 2; }"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	results := make([]int64, 50)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			val, err := js.Eval(fmt.Sprintf("double(%d)", i))
+			if err != nil {
+				t.Errorf("double(%d), %s", i, err)
+				return
+			}
+			results[i], _ = val.ToInteger()
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result != int64(i*2) {
+			t.Errorf("double(%d) expected %d, got %d", i, i*2, result)
+		}
+	}
+	val, err := js.Eval("count")
+	isOK(t, err, nil)
+	isOK(t, val.String(), "50")
+}
+
+// TestEvalToConcurrent is meant to be run with go test -race
+func TestEvalToConcurrent(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	if _, err := js.Eval("function square(n) { return n * n; }"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	results := make([]int64, 50)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n, err := js.EvalToInt(fmt.Sprintf("square(%d)", i))
+			if err != nil {
+				t.Errorf("square(%d), %s", i, err)
+				return
+			}
+			results[i] = n
+		}(i)
+	}
+	// the other entry points share the lock
+	for i := 0; i < 5; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			js.Clone()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			js.Register("concurrent", fmt.Sprintf("fn%d", i), func(call otto.FunctionCall) otto.Value {
+				return otto.UndefinedValue()
+			}, []string{}, "does nothing")
+		}(i)
+		go func() {
+			defer wg.Done()
+			js.Functions()
+		}()
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result != int64(i*i) {
+			t.Errorf("square(%d) expected %d, got %d", i, i*i, result)
+		}
+	}
+
+	s, err := js.EvalToString(`"a" + 1`)
+	isOK(t, err, nil)
+	isOK(t, s, "a1")
+	f, err := js.EvalToFloat(`1 / 4`)
+	isOK(t, err, nil)
+	isOK(t, f, 0.25)
+	b, err := js.EvalToBool(`[].length === 0`)
+	isOK(t, err, nil)
+	isOK(t, b, true)
+	if _, err := js.EvalToInt(`undefinedFunction()`); err == nil {
+		t.Errorf("expected an error calling an undefined function")
+	}
+}
+
+// TestLoopUnlocked checks other callers can use the VM while Loop waits for a callback
+func TestLoopUnlocked(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	_, err := js.Eval(`var fired = false; util.debounce(function () { fired = true; }, 200)();`)
+	isOK(t, err, nil)
+
+	done := make(chan struct{})
+	go func() {
+		js.Loop()
+		close(done)
+	}()
+	evaluated := make(chan int64, 1)
+	go func() {
+		n, _ := js.EvalToInt(`40 + 2`)
+		evaluated <- n
+	}()
+	select {
+	case n := <-evaluated:
+		isOK(t, n, int64(42))
+	case <-done:
+		t.Errorf("expected Eval to run before the timer fired")
+	}
+	<-done
+	fired, err := js.EvalToBool(`fired`)
+	isOK(t, err, nil)
+	isOK(t, fired, true)
+}
+
+func TestReadFileRange(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	// an xlsx file is a zip archive which starts with "PK\x03\x04"
+	val, err := js.VM.Eval(`os.readFileRange("testdata/Typed.xlsx", 0, 4).join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "80,75,3,4")
+
+	src, err := ioutil.ReadFile("testdata/Typed.xlsx")
+	isOK(t, err, nil)
+	size := len(src)
+	val, err = js.VM.Eval(fmt.Sprintf(`os.readFileRange("testdata/Typed.xlsx", %d, 10).length`, size-3))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "3")
+	val, err = js.VM.Eval(fmt.Sprintf(`os.readFileRange("testdata/Typed.xlsx", %d, 10).length`, size+5))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "0")
+	// a huge length is clamped to the end of the file before anything is allocated
+	val, err = js.VM.Eval(fmt.Sprintf(`os.readFileRange("testdata/Typed.xlsx", %d, 1e15).length`, size-3))
+	isOK(t, err, nil)
+	isOK(t, val.String(), "3")
+
+	val, err = js.VM.Eval(`os.readFileRange("testdata/no-such-file", 0, 4).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestReplHelpTopic(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddHelp()
+
+	text := js.FormatHelp("repl", "save")
+	if strings.Contains(text, ".save FILENAME") == false || strings.Contains(text, "save history to FILENAME") == false {
+		t.Errorf("expected the .save description, got %q", text)
+	}
+	isOK(t, js.FormatHelp("", "save"), text)
+
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	js.ReplWithReader(&testLineReader{lines: []string{".help .export", ".help repl.load"}})
+	if strings.Contains(out.String(), "as a script") == false || strings.Contains(out.String(), "load history from FILENAME") == false {
+		t.Errorf("expected help for .export and .load, got %q", out.String())
+	}
+
+	src, err := json.Marshal(js.Help["repl"])
+	isOK(t, err, nil)
+	if strings.Contains(string(src), `"function":"save"`) == false {
+		t.Errorf("expected the dot commands in the JSON help, got %s", src)
+	}
+}
+
+func TestXLSXReadFillMerged(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	// B1:C1 is merged in testdata/Merged.xlsx
+	val, err := js.VM.Eval(`xlsx.read("testdata/Merged.xlsx").Sheet1[0].join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "name,scores,")
+
+	val, err = js.VM.Eval(`(function () {
+		var sheet = xlsx.read("testdata/Merged.xlsx", {fillMerged: true}).Sheet1;
+		return [sheet[0].join(","), sheet[1].join(","), sheet.length].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "name,scores,scores|alice,1,2|3")
+}
+
+func TestParseArgs(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	spec := `[{name: "count", type: "number", alias: "c", default: 1}, {name: "verbose", type: "bool", alias: "v"}, {name: "out", default: "-"}]`
+	val, err := js.VM.Eval(`(function () {
+		var args = os.parseArgs(` + spec + `, ["--count", "3", "file.txt"]);
+		return [args.flags.count === 3, args.positional[0] === "file.txt", args.positional.length, args.flags.verbose, args.flags.out].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,true,1,false,-")
+
+	val, err = js.VM.Eval(`(function () {
+		var args = os.parseArgs(` + spec + `, ["-v", "--out=result.json", "-c", "2.5", "a", "--", "--b"]);
+		return [args.flags.verbose, args.flags.out, args.flags.count, args.positional.join(" ")].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,result.json,2.5,a --b")
+
+	val, err = js.VM.Eval(`os.parseArgs(` + spec + `, ["--colour", "red"]).error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), "unknown flag --colour") == false {
+		t.Errorf("expected an unknown flag error, got %q", val.String())
+	}
+	val, err = js.VM.Eval(`os.parseArgs(` + spec + `, ["--count", "many"]).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestHTTPBuildURL(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	val, err := js.VM.Eval(`http.buildURL("https://example.org/search", {q: "fish & chips", tag: ["a/b", "c=d"], page: 2, exact: true})`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "https://example.org/search?exact=true&page=2&q=fish+%26+chips&tag=a%2Fb&tag=c%3Dd")
+
+	val, err = js.VM.Eval(`http.buildURL("https://example.org/search?lang=en", {q: "café"})`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "https://example.org/search?lang=en&q=caf%C3%A9")
+
+	val, err = js.VM.Eval(`http.buildURL("https://example.org/", {q: {nested: true}}).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestSnapshotEnv(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	home := os.Getenv("HOME")
+	os.Unsetenv("OSTDLIB_SNAPSHOT_TEST")
+	val, err := js.VM.Eval(`(function () {
+		var handle = os.snapshotEnv();
+		os.setEnv("OSTDLIB_SNAPSHOT_TEST", "dirty");
+		os.setEnv("HOME", "/nowhere");
+		var before = os.getEnv("OSTDLIB_SNAPSHOT_TEST");
+		return [before, os.restoreEnv(handle)].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "dirty,true")
+	_, ok := os.LookupEnv("OSTDLIB_SNAPSHOT_TEST")
+	isOK(t, ok, false)
+	isOK(t, os.Getenv("HOME"), home)
+
+	val, err = js.VM.Eval(`os.restoreEnv(42).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+
+	// a restored snapshot is released, handles aren't reused
+	val, err = js.VM.Eval(`(function () {
+		var first = os.snapshotEnv(), second = os.snapshotEnv();
+		os.restoreEnv(first);
+		var third = os.snapshotEnv();
+		return [os.restoreEnv(first).status, third !== second, os.restoreEnv(second), os.restoreEnv(third)].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error,true,true,true")
+	isOK(t, len(js.envSnapshots), 0)
+}
+
+func TestXLSXValidateHeader(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	val, err := js.VM.Eval(`(function () {
+		var check = xlsx.validateHeader("testdata/Typed.xlsx", "Sheet1", ["amount", "name", "date", "note"], {ordered: true});
+		return [check.valid, check.missing.length, check.extra.length].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,0,0")
+
+	val, err = js.VM.Eval(`(function () {
+		var check = xlsx.validateHeader("testdata/Typed.xlsx", "Sheet1", ["amount", "title", "date", "note", "price"]);
+		return [check.valid, check.missing.join(" "), check.extra.join(" ")].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false,title price,name")
+
+	// the same columns in another order are only invalid when ordered
+	val, err = js.VM.Eval(`(function () {
+		var columns = ["name", "amount", "date", "note"];
+		return [
+			xlsx.validateHeader("testdata/Typed.xlsx", "Sheet1", columns).valid,
+			xlsx.validateHeader("testdata/Typed.xlsx", "Sheet1", columns, {ordered: true}).valid
+		].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,false")
+}
+
+func TestDebounceThrottle(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "timing.js")
+	err = ioutil.WriteFile(fname, []byte(`
+var debounced = [], throttled = [];
+var d = util.debounce(function (n) { debounced.push(n); }, 30);
+var th = util.throttle(function (n) { throttled.push(n); }, 30);
+for (var i = 0; i < 5; i++) {
+	d(i);
+	th(i);
+}
+`), 0664)
+	isOK(t, err, nil)
+
+	// Run returns once the event loop has no timers left
+	isOK(t, js.Run(fname), nil)
+	val, err := js.VM.Eval(`debounced.join(",") + "|" + throttled.join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "4|0,4")
+	isOK(t, len(js.ops), 0)
+}
+
+func TestRunShebang(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+
+	fname := path.Join(dname, "hello.js")
+	err = ioutil.WriteFile(fname, []byte("#!/usr/bin/env ottomatic\nvar greeting = \"hello\";\n"), 0775)
+	isOK(t, err, nil)
+	isOK(t, js.Run(fname), nil)
+	val, err := js.VM.Eval(`greeting`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "hello")
+
+	// the shebang still counts as line 1
+	fname = path.Join(dname, "broken.js")
+	err = ioutil.WriteFile(fname, []byte("#!/usr/bin/env ottomatic\nvar x = 1;\nvar = 2;\n"), 0775)
+	isOK(t, err, nil)
+	err = js.Run(fname)
+	if err == nil || strings.Contains(err.Error(), "Line 3:") == false {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+}
+
+func TestAutoCompleteRanking(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+
+	// terms returns the completer's function terms, the dot commands come first
+	terms := func(js *JavaScriptVM) []string {
+		var names []string
+		for _, item := range js.AutoCompleter.GetChildren() {
+			name := strings.TrimSpace(string(item.GetName()))
+			if strings.HasPrefix(name, ".") == false {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	js.HistoryFile = path.Join(dname, "history")
+	js.AutoCompleteLimit = 5
+	js.Stdout = new(bytes.Buffer)
+	js.AddAutoComplete()
+	isOK(t, len(terms(js)), 5)
+	isOK(t, strings.HasPrefix(terms(js)[0], "os.args("), true)
+
+	js.ReplWithReader(&testLineReader{lines: []string{"os.getpid();", "os.getpid() > 0;", "os.getpid();", "os.args();"}})
+	ranked := terms(js)
+	isOK(t, len(ranked), 5)
+	isOK(t, strings.HasPrefix(ranked[0], "os.getpid("), true)
+	isOK(t, strings.HasPrefix(ranked[1], "os.args("), true)
+
+	// the counts are kept next to the history for the next session
+	vm = otto.New()
+	js = New(vm)
+	js.AddHelp()
+	js.HistoryFile = path.Join(dname, "history")
+	js.Stdout = new(bytes.Buffer)
+	js.AddAutoComplete()
+	js.ReplWithReader(&testLineReader{})
+	isOK(t, strings.HasPrefix(terms(js)[0], "os.getpid("), true)
+}
+
+func TestLockFile(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	fname := path.Join(dname, "output.json")
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.VM.Set("fname", fname)
+	val, err := js.VM.Eval(`(function () {
+		var first = os.lockFile(fname);
+		var second = os.lockFile(fname);
+		var released = os.unlock(first);
+		var third = os.lockFile(fname);
+		return [typeof first, second.status, released, typeof third].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "number,error,true,number")
+
+	// the lock still held is released by Close
+	_, err = os.Stat(fname + ".lock")
+	isOK(t, err, nil)
+	isOK(t, js.Close(), nil)
+	_, err = os.Stat(fname + ".lock")
+	isOK(t, os.IsNotExist(err), true)
+}
+
+func TestXLSXDiff(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	val, err := js.VM.Eval(`xlsx.diff("testdata/Typed.xlsx", "testdata/Typed.xlsx").length`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "0")
+
+	// TypedModified.xlsx has 5 rather than 2 in Sheet1 A3
+	val, err = js.VM.Eval(`(function () {
+		var diffs = xlsx.diff("testdata/Typed.xlsx", "testdata/TypedModified.xlsx");
+		return [diffs.length, diffs[0].sheet, diffs[0].row, diffs[0].col, typeof diffs[0].a, diffs[0].a, diffs[0].b].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "1,Sheet1,2,0,number,2,5")
+}
+
+func TestAllowedHosts(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AllowedHosts = []string{"127.0.0.1"}
+	// a transport which would answer any host shows the blocked request never reaches it
+	rt := new(recordingTransport)
+	js.SetHTTPTransport(rt)
+	js.VM.Set("local", ts.URL)
+	val, err := js.VM.Eval(`http.get(local)`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "hello")
+
+	val, err = js.VM.Eval(`http.get("http://example.com/").error`)
+	isOK(t, err, nil)
+	if strings.Contains(val.String(), "requests to example.com are not allowed") == false {
+		t.Errorf("expected example.com to be blocked, got %q", val.String())
+	}
+	mu.Lock()
+	isOK(t, requests, 1)
+	mu.Unlock()
+	isOK(t, strings.Join(rt.urls, ","), ts.URL)
+}
+
+func TestWorkbookMapCells(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	val, err := js.VM.Eval(`(function () {
+		var wb = xlsx.New(), calls = 0, changed;
+		if (wb.read("testdata/Typed.xlsx") !== true) {
+			return "can't read testdata/Typed.xlsx";
+		}
+		changed = wb.mapCells("Sheet2", function (value, row, col) {
+			calls++;
+			return String(value).toUpperCase();
+		});
+		return [changed, calls, wb.getSheet("Sheet2")[0].join("|"), wb.getSheet("Sheet2")[1][0], wb.mapCells("NoSuchSheet", String)].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "3,9,S2, COL A|S2, COL B|S3, COL C,C,")
+}
+
+func TestODSReadWrite(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	js.VM.Set("fname", path.Join(dname, "copy.ods"))
+
+	// Sample.ods has repeated rows and cells, an annotation and padding to the edge of the sheet
+	expected := `{"People":[["name","age","note"],["Ada Lovelace","36","two  spaces\nsecond line"],["same","","x","x"],["same","","x","x"]],"Empty":[]}`
+	val, err := js.VM.Eval(`JSON.stringify(ods.read("testdata/Sample.ods"))`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), expected)
+
+	val, err = js.VM.Eval(`(function () {
+		var ok = ods.write(fname, ods.read("testdata/Sample.ods"));
+		return ok + "," + JSON.stringify(ods.read(fname));
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,"+expected)
+
+	val, err = js.VM.Eval(`ods.read("testdata/Typed.xlsx").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestSetClock(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	frozen := time.Date(2016, time.March, 1, 12, 30, 0, 0, time.UTC)
+	js.SetClock(func() time.Time {
+		return frozen
+	})
+	val, err := js.VM.Eval(`time.now().toISOString()`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2016-03-01T12:30:00.000Z")
+	val, err = js.VM.Eval(`time.now() instanceof Date`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true")
+
+	// without a clock the time is the current one
+	js.SetClock(nil)
+	val, err = js.VM.Eval(`time.now().getTime()`)
+	isOK(t, err, nil)
+	ms, _ := val.ToInteger()
+	if ms < frozen.Unix()*1000 {
+		t.Errorf("expected the current time, got %d", ms)
+	}
+}
+
+func TestCopyPreserveTimes(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	src := path.Join(dname, "source.txt")
+	isOK(t, ioutil.WriteFile(src, []byte("staged"), 0664), nil)
+	mtime := time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+	isOK(t, os.Chtimes(src, mtime, mtime), nil)
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.VM.Set("src", src)
+	js.VM.Set("dname", dname)
+	val, err := js.VM.Eval(`[
+		os.copyFile(src, dname + "/kept.txt", {preserveTimes: true}),
+		os.copyFile(src, dname + "/fresh.txt"),
+		os.copyGlob(src, dname + "/dist", {preserveTimes: true}).length
+	].join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "true,true,1")
+
+	for _, name := range []string{"kept.txt", "dist/source.txt"} {
+		info, err := os.Stat(path.Join(dname, name))
+		isOK(t, err, nil)
+		isOK(t, info.ModTime().Equal(mtime), true)
+	}
+	info, err := os.Stat(path.Join(dname, "fresh.txt"))
+	isOK(t, err, nil)
+	isOK(t, info.ModTime().Equal(mtime), false)
+}
+
+func TestCopyDir(t *testing.T) {
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	src := path.Join(dname, "site")
+	isOK(t, os.MkdirAll(path.Join(src, "css"), 0775), nil)
+	isOK(t, ioutil.WriteFile(path.Join(src, "index.html"), []byte("<p>hi</p>"), 0664), nil)
+	isOK(t, ioutil.WriteFile(path.Join(src, "css", "site.css"), []byte("p {}"), 0664), nil)
+	mtime := time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"css/site.css", "css", "index.html"} {
+		isOK(t, os.Chtimes(path.Join(src, name), mtime, mtime), nil)
+	}
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.VM.Set("src", src)
+	js.VM.Set("dname", dname)
+	val, err := js.VM.Eval(`[
+		os.copyDir(src, dname + "/dist", {preserveTimes: true}).length,
+		os.copyDir(src, dname + "/dist").error !== undefined,
+		os.copyDir(src, dname + "/dist", true).length,
+		os.copyDir(src, src + "/css/again").error !== undefined,
+		os.copyDir(src + "/index.html", dname + "/other").error !== undefined
+	].join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "2,true,2,true,true")
+
+	buf, err := ioutil.ReadFile(path.Join(dname, "dist", "css", "site.css"))
+	isOK(t, err, nil)
+	isOK(t, string(buf), "p {}")
+	// the overwrite copy didn't preserve times, the first copy's directory times are kept
+	info, err := os.Stat(path.Join(dname, "dist", "css"))
+	isOK(t, err, nil)
+	isOK(t, info.ModTime().Equal(mtime), true)
+	info, err = os.Stat(path.Join(dname, "dist", "index.html"))
+	isOK(t, err, nil)
+	isOK(t, info.ModTime().Equal(mtime), false)
+}
+
+func TestActiveOps(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.DisableHistory = true
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	isOK(t, len(js.ActiveOps()), 0)
+	_, err := js.VM.Eval(`var fired = false; util.debounce(function () { fired = true; }, 60000)();`)
+	isOK(t, err, nil)
+	ops := js.ActiveOps()
+	isOK(t, len(ops), 1)
+	isOK(t, ops[0].Kind, "util.debounce")
+	isOK(t, ops[0].Target, "1m0s")
+
+	js.ReplWithReader(&testLineReader{lines: []string{".ops"}})
+	isOK(t, strings.Contains(out.String(), "util.debounce"), true)
+
+	isOK(t, js.CancelOp(ops[0].ID), nil)
+	isOK(t, len(js.ActiveOps()), 0)
+	if err := js.CancelOp(ops[0].ID); err == nil {
+		t.Errorf("expected an error cancelling an operation twice")
+	}
+
+	// .ops cancel ID stops an operation from the repl
+	_, err = js.VM.Eval(`util.debounce(function () {}, 60000)();`)
+	isOK(t, err, nil)
+	js.ReplWithReader(&testLineReader{lines: []string{fmt.Sprintf(".ops cancel %d", js.ActiveOps()[0].ID)}})
+	isOK(t, len(js.ActiveOps()), 0)
+	js.Loop()
+	val, err := js.VM.Eval(`fired`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "false")
+}
+
+func TestXLSXWriteWorkbook(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	dname, err := ioutil.TempDir("", "ostdlib")
+	isOK(t, err, nil)
+	defer os.RemoveAll(dname)
+	js.VM.Set("fname", path.Join(dname, "out.xlsx"))
+
+	val, err := js.VM.Eval(`(function () {
+		var wb = xlsx.New({Sheet1: [["a", "b"], ["1", "2"]], Sheet2: [["c"]]});
+		var ok = xlsx.write(fname, wb);
+		return ok + "," + JSON.stringify(xlsx.read(fname));
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), `true,{"Sheet1":[["a","b"],["1","2"]],"Sheet2":[["c"]]}`)
+}
+
+func TestReplOutputIndent(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.DisableHistory = true
+	out := new(bytes.Buffer)
+	js.Stdout = out
+
+	js.ReplWithReader(&testLineReader{lines: []string{`({name: "ada", tags: ["a", "b"]})`}})
+	isOK(t, out.String(), "    {\n      \"name\": \"ada\",\n      \"tags\": [\n        \"a\",\n        \"b\"\n      ]\n    }\n")
+
+	out.Reset()
+	js.OutputIndent = ""
+	js.ReplWithReader(&testLineReader{lines: []string{`({name: "ada", tags: ["a", "b"]})`, `"text"`}})
+	isOK(t, out.String(), "    {\"name\":\"ada\",\"tags\":[\"a\",\"b\"]}\n    text\n")
+}
+
+func TestSizeByExt(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+
+	files, err := filepath.Glob("testdata/*.xlsx")
+	isOK(t, err, nil)
+	val, err := js.VM.Eval(`(function () {
+		var sizes = os.sizeByExt("testdata");
+		return [sizes[".xlsx"].bytes > 0, sizes[".xlsx"].files, sizes[".env"].files].join(",");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), fmt.Sprintf("true,%d,1", len(files)))
+
+	val, err = js.VM.Eval(`os.sizeByExt("testdata/no-such-dir").status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestHTTPPutDelete(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.Header.Get("Content-Type"), r.Header.Get("X-Token"), body)
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.AddHelp()
+	js.VM.Set("uri", ts.URL+"/items/1")
+	val, err := js.VM.Eval(`http.put(uri, "application/json", '{"id":1}', {"X-Token": "abc"})`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), `PUT application/json abc {"id":1}`)
+
+	val, err = js.VM.Eval(`http.delete(uri, {"X-Token": "abc"})`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "")
+	val, err = js.VM.Eval(`http.delete(uri)`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "")
+
+	out := new(bytes.Buffer)
+	js.Stdout = out
+	js.GetHelp("http", "put")
+	isOK(t, strings.Contains(out.String(), "http.put(uri string, mimeType string, payload string, headers object)"), true)
+}
+
+func TestUtilSortBy(t *testing.T) {
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	val, err := js.VM.Eval(`(function () {
+		var staff = [
+				{name: "Ann", dept: "sales", salary: 50},
+				{name: "Bob", dept: "eng", salary: 70},
+				{name: "Cy", dept: "sales", salary: 65},
+				{name: "Di", dept: "eng", salary: 9},
+				{name: "Ed", dept: "eng", salary: 70},
+				{name: "Flo", salary: 80}
+			],
+			sorted = util.sortBy(staff, ["dept", "-salary"]);
+		return sorted.map(function (r) { return r.name; }).join(",") + "|" + staff[0].name;
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "Flo,Bob,Ed,Di,Cy,Ann|Ann")
+
+	val, err = js.VM.Eval(`util.sortBy([{n: "10"}, {n: "9"}, {n: 10}, {n: 9}], ["n"]).map(function (r) { return r.n; }).join(",")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "9,10,10,9")
+
+	val, err = js.VM.Eval(`util.sortBy([{n: 1}], [""]).status`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "error")
+}
+
+func TestHTTPFullResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/new":
+			w.Header().Add("X-Seen", "a")
+			w.Header().Add("X-Seen", "b")
+			fmt.Fprintf(w, "%s new", r.Method)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	vm := otto.New()
+	js := New(vm)
+	js.AddExtensions()
+	js.VM.Set("base", ts.URL)
+	val, err := js.VM.Eval(`(function () {
+		var redirect = http.get(base + "/old", {}, true),
+			missing = http.get(base + "/gone", null, true),
+			found = http.get(base + "/new", {}, true);
+		return [redirect.status, redirect.statusText, redirect.headers["Location"][0],
+			missing.status, found.status, found.headers["X-Seen"].join(","), found.body].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "302|Found|/new|404|200|a,b|GET new")
+
+	val, err = js.VM.Eval(`(function () {
+		var redirect = http.post(base + "/old", "text/plain", "hi", {}, true);
+		return [redirect.status, redirect.headers["Location"][0]].join("|");
+	}())`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "302|/new")
+
+	// Without the flag the body is returned and redirects are followed as before
+	val, err = js.VM.Eval(`http.get(base + "/old")`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "GET new")
+	val, err = js.VM.Eval(`http.get(base + "/old", {}, false)`)
+	isOK(t, err, nil)
+	isOK(t, val.String(), "GET new")
+}
@@ -0,0 +1,414 @@
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package ostdlib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+// cfbSignature starts a Compound File Binary (OLE2) container, Office stores encrypted documents in one
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbEndOfChain = 0xFFFFFFFE
+	cfbFreeSector = 0xFFFFFFFF
+)
+
+// compoundFile reads the streams of a Compound File Binary container held in memory
+type compoundFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	entries        []cfbEntry
+}
+
+// cfbEntry is a directory entry of a compound file, kind 2 is a stream and 5 the root storage
+type cfbEntry struct {
+	name  string
+	kind  byte
+	start uint32
+	size  uint64
+}
+
+// openCompoundFile parses the header, allocation tables and directory of a compound file
+func openCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || bytes.Equal(data[:8], cfbSignature) == false {
+		return nil, fmt.Errorf("not a compound file")
+	}
+	le := binary.LittleEndian
+	cf := &compoundFile{
+		data:           data,
+		sectorSize:     1 << le.Uint16(data[0x1E:]),
+		miniSectorSize: 1 << le.Uint16(data[0x20:]),
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+	if cf.sectorSize != 512 && cf.sectorSize != 4096 {
+		return nil, fmt.Errorf("unsupported sector size %d", cf.sectorSize)
+	}
+	numFAT := int(le.Uint32(data[0x2C:]))
+	firstDir := le.Uint32(data[0x30:])
+	firstMiniFAT := le.Uint32(data[0x3C:])
+	nextDIFAT := le.Uint32(data[0x44:])
+	numDIFAT := int(le.Uint32(data[0x48:]))
+
+	// The FAT's sectors are listed in the header then in a chain of DIFAT sectors
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		if id := le.Uint32(data[0x4C+i*4:]); id != cfbFreeSector {
+			fatSectors = append(fatSectors, id)
+		}
+	}
+	perSector := cf.sectorSize/4 - 1
+	for i := 0; i < numDIFAT && nextDIFAT != cfbEndOfChain && nextDIFAT != cfbFreeSector; i++ {
+		sector, err := cf.sector(nextDIFAT)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < perSector; j++ {
+			if id := le.Uint32(sector[j*4:]); id != cfbFreeSector {
+				fatSectors = append(fatSectors, id)
+			}
+		}
+		nextDIFAT = le.Uint32(sector[perSector*4:])
+	}
+	if len(fatSectors) > numFAT {
+		fatSectors = fatSectors[:numFAT]
+	}
+	for _, id := range fatSectors {
+		sector, err := cf.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < cf.sectorSize; j += 4 {
+			cf.fat = append(cf.fat, le.Uint32(sector[j:]))
+		}
+	}
+
+	dir, err := cf.chain(firstDir, false)
+	if err != nil {
+		return nil, fmt.Errorf("can't read directory, %s", err)
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		entry := dir[off : off+128]
+		nameLen := int(le.Uint16(entry[64:]))
+		if nameLen < 2 || nameLen > 64 {
+			continue
+		}
+		name := make([]uint16, (nameLen-2)/2)
+		for i := range name {
+			name[i] = le.Uint16(entry[i*2:])
+		}
+		size := le.Uint64(entry[120:])
+		if cf.sectorSize == 512 {
+			// version 3 files may leave garbage in the high 32 bits
+			size = size & 0xFFFFFFFF
+		}
+		cf.entries = append(cf.entries, cfbEntry{
+			name:  string(utf16.Decode(name)),
+			kind:  entry[66],
+			start: le.Uint32(entry[116:]),
+			size:  size,
+		})
+	}
+	if len(cf.entries) == 0 || cf.entries[0].kind != 5 {
+		return nil, fmt.Errorf("missing root entry")
+	}
+
+	// Streams smaller than miniCutoff are stored in the mini stream, held by the root entry
+	if firstMiniFAT != cfbEndOfChain {
+		buf, err := cf.chain(firstMiniFAT, false)
+		if err != nil {
+			return nil, fmt.Errorf("can't read mini FAT, %s", err)
+		}
+		for j := 0; j+4 <= len(buf); j += 4 {
+			cf.miniFAT = append(cf.miniFAT, le.Uint32(buf[j:]))
+		}
+		root := cf.entries[0]
+		if cf.miniStream, err = cf.chain(root.start, false); err != nil {
+			return nil, fmt.Errorf("can't read mini stream, %s", err)
+		}
+	}
+	return cf, nil
+}
+
+// sector returns the contents of sector id
+func (cf *compoundFile) sector(id uint32) ([]byte, error) {
+	off := (int(id) + 1) * cf.sectorSize
+	if id >= cfbEndOfChain-3 || off+cf.sectorSize > len(cf.data) {
+		return nil, fmt.Errorf("sector %d is out of range", id)
+	}
+	return cf.data[off : off+cf.sectorSize], nil
+}
+
+// chain returns the contents of the sectors (or mini sectors) linked from start
+func (cf *compoundFile) chain(start uint32, mini bool) ([]byte, error) {
+	table, size := cf.fat, cf.sectorSize
+	if mini == true {
+		table, size = cf.miniFAT, cf.miniSectorSize
+	}
+	buf := new(bytes.Buffer)
+	for id, count := start, 0; id != cfbEndOfChain; id, count = table[id], count+1 {
+		if int(id) >= len(table) || count > len(table) {
+			return nil, fmt.Errorf("broken sector chain")
+		}
+		if mini == true {
+			off := int(id) * size
+			if off+size > len(cf.miniStream) {
+				return nil, fmt.Errorf("mini sector %d is out of range", id)
+			}
+			buf.Write(cf.miniStream[off : off+size])
+			continue
+		}
+		sector, err := cf.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(sector)
+	}
+	return buf.Bytes(), nil
+}
+
+// stream returns the contents of the named stream
+func (cf *compoundFile) stream(name string) ([]byte, error) {
+	for _, entry := range cf.entries {
+		if entry.kind != 2 || entry.name != name {
+			continue
+		}
+		buf, err := cf.chain(entry.start, entry.size < cf.miniCutoff)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s, %s", name, err)
+		}
+		if uint64(len(buf)) < entry.size {
+			return nil, fmt.Errorf("%s is truncated", name)
+		}
+		return buf[:entry.size], nil
+	}
+	return nil, fmt.Errorf("missing %s stream", name)
+}
+
+// agileEncryption is the XML descriptor of ECMA-376 agile encryption found in the EncryptionInfo stream
+type agileEncryption struct {
+	KeyData       agileKeyData `xml:"keyData"`
+	KeyEncryptors []struct {
+		URI          string       `xml:"uri,attr"`
+		EncryptedKey agileKeyData `xml:"encryptedKey"`
+	} `xml:"keyEncryptors>keyEncryptor"`
+}
+
+// agileKeyData describes how the package (keyData) or the package's key (encryptedKey) is encrypted
+type agileKeyData struct {
+	SaltValue                  string `xml:"saltValue,attr"`
+	BlockSize                  int    `xml:"blockSize,attr"`
+	KeyBits                    int    `xml:"keyBits,attr"`
+	CipherAlgorithm            string `xml:"cipherAlgorithm,attr"`
+	CipherChaining             string `xml:"cipherChaining,attr"`
+	HashAlgorithm              string `xml:"hashAlgorithm,attr"`
+	SpinCount                  int    `xml:"spinCount,attr"`
+	EncryptedVerifierHashInput string `xml:"encryptedVerifierHashInput,attr"`
+	EncryptedVerifierHashValue string `xml:"encryptedVerifierHashValue,attr"`
+	EncryptedKeyValue          string `xml:"encryptedKeyValue,attr"`
+}
+
+// agilePasswordURI identifies the password key encryptor of agile encryption
+const agilePasswordURI = "http://schemas.microsoft.com/office/2006/keyEncryptor/password"
+
+// agileMaxSpinCount is the most password hash iterations MS-OFFCRYPTO allows
+const agileMaxSpinCount = 10000000
+
+// agileHashes are the hash algorithms agile encryption may name
+var agileHashes = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA384": sha512.New384,
+	"SHA512": sha512.New,
+}
+
+// errWrongPassword is returned by decryptXLSX when the password doesn't unlock the workbook
+var errWrongPassword = fmt.Errorf("incorrect password")
+
+// decryptXLSX returns the xlsx package of an Office encrypted workbook (ECMA-376 agile encryption,
+// used by Excel 2010 and later) or errWrongPassword if password doesn't unlock it
+func decryptXLSX(data []byte, password string) ([]byte, error) {
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("not an encrypted workbook, %s", err)
+	}
+	info, err := cf.stream("EncryptionInfo")
+	if err != nil {
+		return nil, fmt.Errorf("not an encrypted workbook, %s", err)
+	}
+	if len(info) < 8 {
+		return nil, fmt.Errorf("EncryptionInfo is truncated")
+	}
+	major, minor := binary.LittleEndian.Uint16(info), binary.LittleEndian.Uint16(info[2:])
+	if major != 4 || minor != 4 {
+		return nil, fmt.Errorf("unsupported encryption version %d.%d, only agile encryption (Excel 2010 and later) is supported", major, minor)
+	}
+	desc := agileEncryption{}
+	if err := xml.Unmarshal(info[8:], &desc); err != nil {
+		return nil, fmt.Errorf("can't parse EncryptionInfo, %s", err)
+	}
+	var pwKey *agileKeyData
+	for i := range desc.KeyEncryptors {
+		if desc.KeyEncryptors[i].URI == agilePasswordURI {
+			pwKey = &desc.KeyEncryptors[i].EncryptedKey
+		}
+	}
+	if pwKey == nil {
+		return nil, fmt.Errorf("workbook isn't password encrypted")
+	}
+	for _, kd := range []*agileKeyData{&desc.KeyData, pwKey} {
+		if kd.CipherAlgorithm != "AES" || kd.CipherChaining != "ChainingModeCBC" || agileHashes[kd.HashAlgorithm] == nil {
+			return nil, fmt.Errorf("unsupported encryption %s %s %s", kd.CipherAlgorithm, kd.CipherChaining, kd.HashAlgorithm)
+		}
+		if kd.KeyBits <= 0 || kd.BlockSize <= 0 {
+			return nil, fmt.Errorf("invalid EncryptionInfo, keyBits %d, blockSize %d", kd.KeyBits, kd.BlockSize)
+		}
+	}
+	// each spin is a hash, an unbounded count would keep the CPU busy for minutes
+	if pwKey.SpinCount < 0 || pwKey.SpinCount > agileMaxSpinCount {
+		return nil, fmt.Errorf("invalid EncryptionInfo, spinCount %d", pwKey.SpinCount)
+	}
+	decode := func(s string) []byte {
+		b, e := base64.StdEncoding.DecodeString(s)
+		if e != nil && err == nil {
+			err = fmt.Errorf("can't decode EncryptionInfo, %s", e)
+		}
+		return b
+	}
+	pwSalt := decode(pwKey.SaltValue)
+	keySalt := decode(desc.KeyData.SaltValue)
+	verifierInput := decode(pwKey.EncryptedVerifierHashInput)
+	verifierValue := decode(pwKey.EncryptedVerifierHashValue)
+	encryptedKey := decode(pwKey.EncryptedKeyValue)
+	if err != nil {
+		return nil, err
+	}
+
+	// The password hash is iterated spinCount times then combined with a block key per value
+	pwHash := agileHashes[pwKey.HashAlgorithm]
+	pw := new(bytes.Buffer)
+	for _, r := range utf16.Encode([]rune(password)) {
+		binary.Write(pw, binary.LittleEndian, r)
+	}
+	h := agileHash(pwHash, pwSalt, pw.Bytes())
+	iteration := make([]byte, 4)
+	for i := 0; i < pwKey.SpinCount; i++ {
+		binary.LittleEndian.PutUint32(iteration, uint32(i))
+		h = agileHash(pwHash, iteration, h)
+	}
+	decryptValue := func(blockKey, value []byte) ([]byte, error) {
+		key := agileResize(agileHash(pwHash, h, blockKey), pwKey.KeyBits/8)
+		return decryptAESCBC(key, agileResize(pwSalt, pwKey.BlockSize), value)
+	}
+	input, err := decryptValue([]byte{0xfe, 0xa7, 0xd2, 0x76, 0x3b, 0x4b, 0x9e, 0x79}, verifierInput)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := decryptValue([]byte{0xd7, 0xaa, 0x0f, 0x6d, 0x30, 0x61, 0x34, 0x4e}, verifierValue)
+	if err != nil {
+		return nil, err
+	}
+	if len(input) > len(pwSalt) {
+		input = input[:len(pwSalt)]
+	}
+	actual := agileHash(pwHash, input)
+	if len(expected) < len(actual) || bytes.Equal(expected[:len(actual)], actual) == false {
+		return nil, errWrongPassword
+	}
+	secret, err := decryptValue([]byte{0x14, 0x6e, 0x0b, 0xe7, 0xab, 0xac, 0xd0, 0xd6}, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	secret = agileResize(secret, desc.KeyData.KeyBits/8)
+
+	// The package is encrypted in 4096 byte segments, each with its own IV
+	pkg, err := cf.stream("EncryptedPackage")
+	if err != nil {
+		return nil, err
+	}
+	if len(pkg) < 8 {
+		return nil, fmt.Errorf("EncryptedPackage is truncated")
+	}
+	size := binary.LittleEndian.Uint64(pkg)
+	out := new(bytes.Buffer)
+	segment := make([]byte, 4)
+	for i, off := 0, 8; off < len(pkg); i, off = i+1, off+4096 {
+		end := off + 4096
+		if end > len(pkg) {
+			end = len(pkg)
+		}
+		binary.LittleEndian.PutUint32(segment, uint32(i))
+		iv := agileResize(agileHash(agileHashes[desc.KeyData.HashAlgorithm], keySalt, segment), desc.KeyData.BlockSize)
+		plain, err := decryptAESCBC(secret, iv, pkg[off:end])
+		if err != nil {
+			return nil, err
+		}
+		out.Write(plain)
+	}
+	if uint64(out.Len()) < size {
+		return nil, fmt.Errorf("EncryptedPackage is truncated")
+	}
+	return out.Bytes()[:size], nil
+}
+
+// agileHash returns the digest of parts concatenated
+func agileHash(newHash func() hash.Hash, parts ...[]byte) []byte {
+	h := newHash()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// agileResize truncates b to n bytes or pads it with 0x36 as agile encryption requires
+func agileResize(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b[:n]
+	}
+	return append(append([]byte{}, b...), bytes.Repeat([]byte{0x36}, n-len(b))...)
+}
+
+// decryptAESCBC decrypts data with AES in CBC mode, data is a whole number of blocks
+func decryptAESCBC(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("encrypted data isn't a whole number of blocks")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	return plain, nil
+}